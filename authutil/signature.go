@@ -0,0 +1,40 @@
+package authutil
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+var documentUrlKey []byte
+
+func InitializeDocumentUrlKey() error {
+	documentUrlKey = []byte(os.Getenv("DOCUMENT_URL_KEY"))
+	if len(documentUrlKey) == 0 {
+		return fmt.Errorf("DOCUMENT_URL_KEY is empty")
+	}
+	return nil
+}
+
+// SignDocument returns the hex HMAC-SHA256 of the document id and its unix expiry
+func SignDocument(id string, expires int64) string {
+	mac := hmac.New(sha256.New, documentUrlKey)
+	mac.Write([]byte(id + "." + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyDocumentSignature compares the signature in constant time, the expiry has to be checked by the caller
+func VerifyDocumentSignature(id string, expires int64, signature string) bool {
+	expected, err := hex.DecodeString(SignDocument(id, expires))
+	if err != nil {
+		return false
+	}
+	actual, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, actual)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/models"
+	"github.com/Tus1688/kim-hackathon-2023-api/render"
+)
+
+// GetAgreement returns the agreement pdf, its sha256 is in the X-Content-Hash header to be sent back on acceptance
+func GetAgreement(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uid := r.Context().Value("uid").(string)
+	doc, contentHash, err := models.GetAgreement(id, uid)
+	if err != nil {
+		handleAgreementError(err, w)
+		return
+	}
+
+	w.Header().Set("X-Content-Hash", contentHash)
+	writeDocument(doc, w)
+}
+
+func AcceptAgreement(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req models.AcceptAgreementRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	err := req.AcceptAgreement(id, uid, documentRequester(r))
+	if err != nil {
+		handleAgreementError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func GetAgreementInfo(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.GetAgreementInfo(id)
+	if err != nil {
+		handleAgreementError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func handleAgreementError(err error, w http.ResponseWriter) {
+	if strings.Contains(err.Error(), "not found") {
+		render.HandleError([]string{err.Error()}, http.StatusNotFound, w)
+		return
+	}
+	if strings.Contains(err.Error(), "not approved") || strings.Contains(err.Error(), "already accepted") ||
+		strings.Contains(err.Error(), "does not match") || strings.Contains(err.Error(), "no longer") {
+		render.HandleError([]string{err.Error()}, http.StatusConflict, w)
+		return
+	}
+	if strings.Contains(err.Error(), "uuid_to_bin") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+}
@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/Tus1688/kim-hackathon-2023-api/models"
 	"github.com/Tus1688/kim-hackathon-2023-api/render"
@@ -58,3 +61,64 @@ func GetTopProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// portfolioRange reads the optional from and to dates, both inclusive
+func portfolioRange(r *http.Request) (time.Time, time.Time, error) {
+	var dates [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid %s, expected YYYY-MM-DD", name)
+		}
+		dates[i] = date
+	}
+	return dates[0], dates[1], nil
+}
+
+func GetPortfolioSummary(w http.ResponseWriter, r *http.Request) {
+	from, to, err := portfolioRange(r)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+		return
+	}
+	res, err := models.GetPortfolioSummary(from, to)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func GetPortfolioMonthly(w http.ResponseWriter, r *http.Request) {
+	from, to, err := portfolioRange(r)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+		return
+	}
+	res, err := models.GetPortfolioMonthly(from, to)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/models"
+	"github.com/Tus1688/kim-hackathon-2023-api/render"
+)
+
+func CreateCollateral(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req models.CollateralRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	res, err := req.Create(id, uid)
+	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
+		handleCollateralError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusCreated, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetCollateralsUser(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uid := r.Context().Value("uid").(string)
+	getCollaterals(id, uid, w)
+}
+
+func GetCollateralsAdmin(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	getCollaterals(id, "", w)
+}
+
+func getCollaterals(id string, uid string, w http.ResponseWriter) {
+	res, err := models.GetCollaterals(id, uid)
+	if err != nil {
+		handleCollateralError(err, w)
+		return
+	}
+	if len(res.Items) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func DeleteCollateral(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uid := r.Context().Value("uid").(string)
+	err := models.DeleteCollateral(id, uid)
+	if err != nil {
+		handleCollateralError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func AppraiseCollateral(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req models.CollateralAppraisalRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	err := req.Appraise(id, uid)
+	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
+		handleCollateralError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleCollateralError(err error, w http.ResponseWriter) {
+	if strings.Contains(err.Error(), "not found") {
+		render.HandleError([]string{err.Error()}, http.StatusNotFound, w)
+		return
+	}
+	if strings.Contains(err.Error(), "no longer pending") {
+		render.HandleError([]string{err.Error()}, http.StatusConflict, w)
+		return
+	}
+	if strings.Contains(err.Error(), "uuid_to_bin") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+}
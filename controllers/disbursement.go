@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/models"
+	"github.com/Tus1688/kim-hackathon-2023-api/render"
+)
+
+func CreateBankAccount(w http.ResponseWriter, r *http.Request) {
+	var req models.BankAccountRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	res, err := req.Create(uid)
+	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
+		if strings.Contains(err.Error(), "Duplicate") {
+			render.HandleError([]string{"bank account already exists"}, http.StatusConflict, w)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusCreated, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetBankAccounts(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("uid").(string)
+	res, err := models.GetBankAccounts(uid)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func SetPrimaryBankAccount(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uid := r.Context().Value("uid").(string)
+	err := models.SetPrimaryBankAccount(id, uid)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			render.HandleError([]string{err.Error()}, http.StatusNotFound, w)
+			return
+		}
+		if strings.Contains(err.Error(), "uuid_to_bin") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DisburseLending sends the lending in the id query to the bank account in the body, or to the primary account of the
+// borrower when the body is empty
+func DisburseLending(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req models.DisbursementRequest
+	if r.ContentLength != 0 {
+		if err := jsonutil.ShouldBind(r, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	uid := r.Context().Value("uid").(string)
+	res, err := req.DisburseLending(id, uid)
+	if err != nil {
+		handleDisbursementError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusCreated, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func RefreshDisbursement(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.RefreshDisbursement(id)
+	if err != nil {
+		handleDisbursementError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetLendingDisbursements(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.GetLendingDisbursements(id)
+	if err != nil {
+		if strings.Contains(err.Error(), "uuid_to_bin") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func handleDisbursementError(err error, w http.ResponseWriter) {
+	if strings.Contains(err.Error(), "not found") {
+		render.HandleError([]string{err.Error()}, http.StatusNotFound, w)
+		return
+	}
+	if strings.Contains(err.Error(), "not awaiting") || strings.Contains(err.Error(), "not been accepted") ||
+		strings.Contains(err.Error(), "not been fully funded") || strings.Contains(err.Error(), "not been collected") ||
+		strings.Contains(err.Error(), "no payout reference") {
+		render.HandleError([]string{err.Error()}, http.StatusConflict, w)
+		return
+	}
+	if strings.Contains(err.Error(), "not configured") {
+		render.HandleError([]string{err.Error()}, http.StatusServiceUnavailable, w)
+		return
+	}
+	if strings.Contains(err.Error(), "uuid_to_bin") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/models"
+	"github.com/Tus1688/kim-hackathon-2023-api/render"
+	"github.com/Tus1688/kim-hackathon-2023-api/uploadutil"
+)
+
+func UploadDocument(w http.ResponseWriter, r *http.Request) {
+	file, err := uploadutil.Process(w, r, "file", uploadutil.DocumentPolicy)
+	if err != nil {
+		handleUploadError(err, w)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	res, err := models.UploadDocument(uid, r.FormValue("type"), file)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusCreated, res)
+}
+
+func GetDocumentUser(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("uid").(string)
+	res, err := models.GetDocumentsAsUser(uid)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+// clientIp is the remote address without its port, it fits the VARCHAR(45) of the audit rows
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func documentRequester(r *http.Request) models.DocumentRequester {
+	requester := models.DocumentRequester{
+		IpAddress: clientIp(r),
+		UserAgent: r.UserAgent(),
+	}
+	if uid, ok := r.Context().Value("uid").(string); ok {
+		requester.Uid = uid
+	}
+	if roles, ok := r.Context().Value("roles").([]string); ok {
+		requester.Roles = roles
+	}
+	return requester
+}
+
+func handleDocumentError(err error, w http.ResponseWriter) {
+	if strings.Contains(err.Error(), "not found") {
+		render.HandleError([]string{"document not found"}, http.StatusNotFound, w)
+		return
+	}
+	if strings.Contains(err.Error(), "cannot") || strings.Contains(err.Error(), "signature") {
+		render.HandleError([]string{err.Error()}, http.StatusForbidden, w)
+		return
+	}
+	render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+}
+
+func writeDocument(doc models.DocumentStream, w http.ResponseWriter) {
+	defer doc.Body.Close()
+	if doc.ContentType != "" {
+		w.Header().Set("Content-Type", doc.ContentType)
+	}
+	if doc.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(doc.ContentLength, 10))
+	}
+	// kk and ktp must never be kept by shared caches
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, doc.Body)
+}
+
+func GetDocument(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	doc, err := models.StreamDocument(id, documentRequester(r))
+	if err != nil {
+		handleDocumentError(err, w)
+		return
+	}
+	writeDocument(doc, w)
+}
+
+func CreateDocumentUrl(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	res, err := models.CreateDocumentUrl(id, documentRequester(r))
+	if err != nil {
+		handleDocumentError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetSignedDocument(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	signature := r.URL.Query().Get("signature")
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if id == "" || signature == "" || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	doc, err := models.StreamSignedDocument(id, expires, signature, documentRequester(r))
+	if err != nil {
+		handleDocumentError(err, w)
+		return
+	}
+	writeDocument(doc, w)
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/models"
+	"github.com/Tus1688/kim-hackathon-2023-api/render"
+)
+
+func ExportReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, err := time.Parse("2006-01-02", query.Get("from"))
+	if err != nil {
+		render.HandleError([]string{"invalid from, expected YYYY-MM-DD"}, http.StatusBadRequest, w)
+		return
+	}
+	to, err := time.Parse("2006-01-02", query.Get("to"))
+	if err != nil {
+		render.HandleError([]string{"invalid to, expected YYYY-MM-DD"}, http.StatusBadRequest, w)
+		return
+	}
+	uid := r.Context().Value("uid").(string)
+	export, err := models.NewReportExport(query.Get("report"), query.Get("format"), from, to, uid)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="`+export.FileName()+`"`)
+	w.Header().Set("X-Export-Id", export.Id)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	//	the status is already sent, a failure is only visible in the export log
+	err = export.Stream(w)
+	if err != nil {
+		log.Print("export ", export.Id, " failed: ", err)
+	}
+}
+
+func GetExportLogs(w http.ResponseWriter, r *http.Request) {
+	res, err := models.GetExportLogs()
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/models"
+	"github.com/Tus1688/kim-hackathon-2023-api/render"
+)
+
+func RegisterAsLender(w http.ResponseWriter, r *http.Request) {
+	var req models.RegisterAsLender
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err := req.Register()
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			render.HandleError([]string{"user already exists"}, http.StatusConflict, w)
+			return
+		}
+
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func GetMarketplace(w http.ResponseWriter, r *http.Request) {
+	res, err := models.GetMarketplace()
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func CommitFunding(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req models.CommitmentRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	res, err := req.Commit(id, uid)
+	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
+		handleFundingError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func WithdrawCommitment(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uid := r.Context().Value("uid").(string)
+	err := models.WithdrawCommitment(id, uid)
+	if err != nil {
+		handleFundingError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func GetLenderCommitments(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("uid").(string)
+	res, err := models.GetLenderCommitments(uid)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetLenderDistributions(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("uid").(string)
+	res, err := models.GetLenderDistributions(uid)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetLendingCommitments(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.GetLendingCommitments(id)
+	if err != nil {
+		handleFundingError(err, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func CollectCommitment(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req models.LenderTransferRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	res, err := req.CollectCommitment(id, uid)
+	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
+		handleFundingError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusCreated, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func PayLender(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req models.LenderTransferRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	res, err := req.PayLender(id, uid)
+	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
+		handleFundingError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusCreated, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetLendingTransfers(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.GetLendingTransfers(id)
+	if err != nil {
+		handleFundingError(err, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func handleFundingError(err error, w http.ResponseWriter) {
+	if strings.Contains(err.Error(), "not found") {
+		render.HandleError([]string{err.Error()}, http.StatusNotFound, w)
+		return
+	}
+	if strings.Contains(err.Error(), "cannot") {
+		render.HandleError([]string{err.Error()}, http.StatusForbidden, w)
+		return
+	}
+	if strings.Contains(err.Error(), "open for funding") || strings.Contains(err.Error(), "already") ||
+		strings.Contains(err.Error(), "not been collected") || strings.Contains(err.Error(), "nothing left") {
+		render.HandleError([]string{err.Error()}, http.StatusConflict, w)
+		return
+	}
+	if strings.Contains(err.Error(), "uuid_to_bin") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+}
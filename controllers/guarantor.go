@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/models"
+	"github.com/Tus1688/kim-hackathon-2023-api/render"
+)
+
+func CreateGuarantor(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req models.GuarantorRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	res, err := req.Create(id, uid)
+	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
+		handleGuarantorError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusCreated, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetGuarantorsUser(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uid := r.Context().Value("uid").(string)
+	getGuarantors(id, uid, w)
+}
+
+func GetGuarantorsAdmin(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	getGuarantors(id, "", w)
+}
+
+func getGuarantors(id string, uid string, w http.ResponseWriter) {
+	res, err := models.GetGuarantors(id, uid)
+	if err != nil {
+		handleGuarantorError(err, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func DeleteGuarantor(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uid := r.Context().Value("uid").(string)
+	err := models.DeleteGuarantor(id, uid)
+	if err != nil {
+		handleGuarantorError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func ReinviteGuarantor(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uid := r.Context().Value("uid").(string)
+	res, err := models.ReinviteGuarantor(id, uid)
+	if err != nil {
+		handleGuarantorError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetGuarantorInvitation(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.GetInvitation(token)
+	if err != nil {
+		handleGuarantorError(err, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func ConfirmGuarantorInvitation(w http.ResponseWriter, r *http.Request) {
+	answerGuarantorInvitation(true, w, r)
+}
+
+func DeclineGuarantorInvitation(w http.ResponseWriter, r *http.Request) {
+	answerGuarantorInvitation(false, w, r)
+}
+
+func answerGuarantorInvitation(confirm bool, w http.ResponseWriter, r *http.Request) {
+	var req models.InvitationAnswerRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err := req.AnswerInvitation(confirm, documentRequester(r))
+	if err != nil {
+		handleGuarantorError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGuarantorError(err error, w http.ResponseWriter) {
+	if strings.Contains(err.Error(), "not found") {
+		render.HandleError([]string{err.Error()}, http.StatusNotFound, w)
+		return
+	}
+	if strings.Contains(err.Error(), "expired") {
+		render.HandleError([]string{err.Error()}, http.StatusGone, w)
+		return
+	}
+	if strings.Contains(err.Error(), "no longer pending") || strings.Contains(err.Error(), "already") {
+		render.HandleError([]string{err.Error()}, http.StatusConflict, w)
+		return
+	}
+	if strings.Contains(err.Error(), "unable to send the invitation") {
+		render.HandleError([]string{err.Error()}, http.StatusBadGateway, w)
+		return
+	}
+	if strings.Contains(err.Error(), "uuid_to_bin") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/models"
+	"github.com/Tus1688/kim-hackathon-2023-api/render"
+)
+
+func GetJournalEntries(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.GetJournalEntries(id)
+	if err != nil {
+		if strings.Contains(err.Error(), "uuid_to_bin") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetTrialBalance(w http.ResponseWriter, r *http.Request) {
+	var asOf time.Time
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			render.HandleError([]string{"invalid as_of, expected YYYY-MM-DD"}, http.StatusBadRequest, w)
+			return
+		}
+		asOf = date
+	}
+	res, err := models.GetTrialBalance(asOf)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
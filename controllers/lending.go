@@ -1,8 +1,12 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
 	"github.com/Tus1688/kim-hackathon-2023-api/models"
@@ -30,23 +34,6 @@ func RegisterAsBorrower(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
-func UploadDocument(w http.ResponseWriter, r *http.Request) {
-	file, m, err := r.FormFile("file")
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-
-	res, err := models.UploadDocument(file, m)
-	if err != nil {
-		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
-		return
-	}
-
-	err = render.JSON(w, http.StatusCreated, res)
-}
-
 func CreateLendingProposal(w http.ResponseWriter, r *http.Request) {
 	var req models.LendingRequest
 	if err := jsonutil.ShouldBind(r, &req); err != nil {
@@ -56,8 +43,14 @@ func CreateLendingProposal(w http.ResponseWriter, r *http.Request) {
 
 	req.RequesterUid = r.Context().Value("uid").(string)
 
-	err := req.Create()
+	res, err := req.Create()
 	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
+
 		if strings.Contains(err.Error(), "uuid_to_bin") {
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -77,12 +70,69 @@ func CreateLendingProposal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	err = render.JSON(w, http.StatusCreated, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+// requestLanguage picks the language of the labels from Accept-Language
+func requestLanguage(w http.ResponseWriter, r *http.Request) string {
+	language := models.ParseLanguage(r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Language", language)
+	w.Header().Add("Vary", "Accept-Language")
+	return language
+}
+
+// GetLendingEnums lists the codes accepted by the proposal with their labels
+func GetLendingEnums(w http.ResponseWriter, r *http.Request) {
+	err := render.JSON(w, http.StatusOK, models.GetLendingEnums(requestLanguage(w, r)))
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetLendingRules(w http.ResponseWriter, r *http.Request) {
+	err := render.JSON(w, http.StatusOK, models.GetLendingRules())
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+// QuoteLending returns the installment table of the amount, tenor and optional product query before a proposal is
+// submitted
+func QuoteLending(w http.ResponseWriter, r *http.Request) {
+	amount, err := strconv.ParseFloat(r.URL.Query().Get("amount"), 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	tenor, err := strconv.Atoi(r.URL.Query().Get("tenor"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	res, err := models.QuoteLending(amount, tenor, r.URL.Query().Get("product"))
+	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
 }
 
 func GetLendingProposalUser(w http.ResponseWriter, r *http.Request) {
 	uid := r.Context().Value("uid").(string)
-	res, err := models.GetLendingAsUser(uid)
+	res, err := models.GetLendingAsUser(uid, requestLanguage(w, r))
 	if err != nil {
 		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
 		return
@@ -98,13 +148,74 @@ func GetLendingProposalUser(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// lendingAdminFilter reads the filters of the admin lending queue from the query parameters
+func lendingAdminFilter(r *http.Request) (models.LendingAdminFilter, error) {
+	query := r.URL.Query()
+	f := models.LendingAdminFilter{
+		ScoreBand: query.Get("score_band"),
+		Search:    query.Get("search"),
+		Sort:      query.Get("sort"),
+		Cursor:    query.Get("cursor"),
+	}
+	if statuses := query.Get("status"); statuses != "" {
+		seen := map[string]bool{}
+		for _, status := range strings.Split(statuses, ",") {
+			status = strings.TrimSpace(status)
+			if status != "" && !seen[status] {
+				seen[status] = true
+				f.Statuses = append(f.Statuses, status)
+			}
+		}
+	}
+	var err error
+	for _, param := range []struct {
+		name string
+		date *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if value := query.Get(param.name); value != "" {
+			*param.date, err = time.Parse("2006-01-02", value)
+			if err != nil {
+				return models.LendingAdminFilter{}, fmt.Errorf("invalid %s, expected YYYY-MM-DD", param.name)
+			}
+		}
+	}
+	for _, param := range []struct {
+		name   string
+		amount *float64
+	}{{"min_amount", &f.MinAmount}, {"max_amount", &f.MaxAmount}} {
+		if value := query.Get(param.name); value != "" {
+			*param.amount, err = strconv.ParseFloat(value, 64)
+			if err != nil || *param.amount < 0 {
+				return models.LendingAdminFilter{}, fmt.Errorf("invalid %s", param.name)
+			}
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		f.Limit, err = strconv.Atoi(value)
+		if err != nil || f.Limit <= 0 {
+			return models.LendingAdminFilter{}, fmt.Errorf("invalid limit")
+		}
+	}
+	return f, nil
+}
+
 func GetLendingProposalAdmin(w http.ResponseWriter, r *http.Request) {
-	res, err := models.GetLendingAsAdmin()
+	filter, err := lendingAdminFilter(r)
 	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+		return
+	}
+	res, err := models.GetLendingAsAdmin(filter, requestLanguage(w, r))
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+			return
+		}
 		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
 		return
 	}
-	if len(res) == 0 {
+	//	an empty page is still returned when another status has lending so the tabs keep their counts
+	if len(res.StatusCounts) == 0 {
 		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
 		return
 	}
@@ -121,8 +232,57 @@ func PredictCreditScore(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	res, err := models.PredictCreditScore(id)
+	uid := r.Context().Value("uid").(string)
+	res, err := models.PredictCreditScore(id, uid)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			render.HandleError([]string{"lending proposal not found"}, http.StatusNotFound, w)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func StartBatchScoring(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("uid").(string)
+	res, err := models.StartBatchScoring(uid)
+	if err != nil {
+		if strings.Contains(err.Error(), "already running") {
+			render.HandleError([]string{err.Error()}, http.StatusConflict, w)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusAccepted, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetBatchScoring(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.GetBatchScoring(id)
 	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			render.HandleError([]string{err.Error()}, http.StatusNotFound, w)
+			return
+		}
+		if strings.Contains(err.Error(), "uuid_to_bin") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
 		return
 	}
@@ -133,18 +293,64 @@ func PredictCreditScore(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func GetCreditScoreHistory(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.GetCreditScoreHistory(id)
+	if err != nil {
+		if strings.Contains(err.Error(), "uuid_to_bin") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
 func ApproveLending(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	err := models.ApproveLending(id)
+	var req models.ApprovalRequest
+	if err := jsonutil.ShouldBind(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uid := r.Context().Value("uid").(string)
+	err := req.Approve(id, uid)
 	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			render.HandleFieldError(fieldErrors, http.StatusUnprocessableEntity, w)
+			return
+		}
 		if strings.Contains(err.Error(), "not found") {
 			render.HandleError([]string{"lending proposal not found"}, http.StatusNotFound, w)
 			return
 		}
+		if strings.Contains(err.Error(), "cannot") {
+			render.HandleError([]string{err.Error()}, http.StatusForbidden, w)
+			return
+		}
+		if strings.Contains(err.Error(), "uuid_to_bin") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
 		return
 	}
@@ -152,6 +358,24 @@ func ApproveLending(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func GetLendingApprovalQueue(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("uid").(string)
+	res, err := models.GetLendingApprovalQueue(uid)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
 func RejectLending(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
@@ -172,19 +396,45 @@ func RejectLending(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func MakePayment(w http.ResponseWriter, r *http.Request) {
+func DecideLending(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	res, err := models.MakePayment(id)
+	uid := r.Context().Value("uid").(string)
+	res, err := models.DecideLending(id, uid)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			render.HandleError([]string{"lending proposal not found"}, http.StatusNotFound, w)
 			return
 		}
+		if strings.Contains(err.Error(), "not pending") {
+			render.HandleError([]string{err.Error()}, http.StatusConflict, w)
+			return
+		}
+		if strings.Contains(err.Error(), "uuid_to_bin") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetLendingDecisions(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := models.GetLendingDecisions(id)
+	if err != nil {
 		if strings.Contains(err.Error(), "uuid_to_bin") {
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -192,6 +442,57 @@ func MakePayment(w http.ResponseWriter, r *http.Request) {
 		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
 		return
 	}
+	if len(res) == 0 {
+		render.HandleError([]string{"no data found"}, http.StatusNotFound, w)
+		return
+	}
+
+	err = render.JSON(w, http.StatusOK, res)
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+func GetDecisionPolicy(w http.ResponseWriter, r *http.Request) {
+	err := render.JSON(w, http.StatusOK, models.GetDecisionPolicy())
+	if err != nil {
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+	}
+}
+
+// SimulateDecisionPolicy runs the policy in the body, or the current one when the body is empty, against the
+// proposals created between the from and to query (YYYY-MM-DD)
+func SimulateDecisionPolicy(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if _, err := time.Parse("2006-01-02", from); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", to); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	policy := models.GetDecisionPolicy()
+	if r.ContentLength != 0 {
+		var candidate models.DecisionPolicy
+		if err := jsonutil.ShouldBind(r, &candidate); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		policy = candidate
+	}
+
+	res, err := models.SimulateDecisionPolicy(policy, from, to)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+			return
+		}
+		render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
 
 	err = render.JSON(w, http.StatusOK, res)
 	if err != nil {
@@ -7,6 +7,7 @@ import (
 	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
 	"github.com/Tus1688/kim-hackathon-2023-api/models"
 	"github.com/Tus1688/kim-hackathon-2023-api/render"
+	"github.com/Tus1688/kim-hackathon-2023-api/uploadutil"
 )
 
 func CreateProduct(w http.ResponseWriter, r *http.Request) {
@@ -117,21 +118,14 @@ func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateProductImage(w http.ResponseWriter, r *http.Request) {
-	file, m, err := r.FormFile("file")
+	file, err := uploadutil.Process(w, r, "file", uploadutil.ImagePolicy)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-
-	// validate image by checking extension of the file (jpg, jpeg, png only)
-	if m.Filename[len(m.Filename)-3:] != "jpg" && m.Filename[len(m.Filename)-4:] != "jpeg" && m.Filename[len(m.Filename)-3:] != "png" {
-		render.HandleError([]string{"invalid image format"}, http.StatusBadRequest, w)
+		handleUploadError(err, w)
 		return
 	}
 
 	id := r.FormValue("id")
-	res, err := models.CreateProductImage(id, file, m)
+	res, err := models.CreateProductImage(id, file)
 	if err != nil {
 		if strings.Contains(err.Error(), "uuid_to_bin") {
 			w.WriteHeader(http.StatusBadRequest)
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/render"
+	"github.com/Tus1688/kim-hackathon-2023-api/uploadutil"
+)
+
+func handleUploadError(err error, w http.ResponseWriter) {
+	var infectedErr *uploadutil.InfectedError
+	if errors.As(err, &infectedErr) {
+		render.HandleError([]string{"file rejected by malware scan"}, http.StatusUnprocessableEntity, w)
+		return
+	}
+	if errors.Is(err, uploadutil.ErrScannerUnavailable) {
+		render.HandleError([]string{err.Error()}, http.StatusServiceUnavailable, w)
+		return
+	}
+	if errors.Is(err, uploadutil.ErrTooLarge) {
+		render.HandleError([]string{err.Error()}, http.StatusRequestEntityTooLarge, w)
+		return
+	}
+	if strings.Contains(err.Error(), "invalid") {
+		render.HandleError([]string{err.Error()}, http.StatusBadRequest, w)
+		return
+	}
+	render.HandleError([]string{err.Error()}, http.StatusInternalServerError, w)
+}
@@ -0,0 +1,101 @@
+package exportutil
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Writer writes a table one row at a time to the underlying writer, nothing but the current row is kept in memory.
+// Close must be called once every row is written
+type Writer interface {
+	// Write takes string, int, int64, float64 and bool cells, a nil cell is left empty
+	Write(row []any) error
+	Close() error
+}
+
+func IsValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatXLSX
+}
+
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// New returns the Writer of the format, sheet is the name of the xlsx worksheet
+func New(format string, w io.Writer, sheet string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatXLSX:
+		return newXLSXWriter(w, sheet)
+	}
+	return nil, fmt.Errorf("invalid format")
+}
+
+// neutralize prefixes text a spreadsheet would evaluate as a formula with a quote so it is shown as it is
+func neutralize(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// formatCell returns the text of the cell and whether it is a number, text that is not a number is neutralized
+func formatCell(cell any) (string, bool) {
+	switch v := cell.(type) {
+	case nil:
+		return "", false
+	case string:
+		return neutralize(v), false
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), false
+	}
+	return neutralize(fmt.Sprint(cell)), false
+}
+
+type csvWriter struct {
+	w    *csv.Writer
+	rows int
+}
+
+// flushEvery keeps the csv buffer small while still writing in chunks
+const flushEvery = 100
+
+func (c *csvWriter) Write(row []any) error {
+	record := make([]string, len(row))
+	for i, cell := range row {
+		record[i], _ = formatCell(cell)
+	}
+	err := c.w.Write(record)
+	if err != nil {
+		return err
+	}
+	c.rows++
+	if c.rows%flushEvery == 0 {
+		c.w.Flush()
+		return c.w.Error()
+	}
+	return nil
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,152 @@
+package exportutil
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCSVWriter(t *testing.T) {
+	tests := []struct {
+		name string
+		row  []any
+		want string
+	}{
+		{name: "plain cells", row: []any{"lending", 10, int64(-5), 1.5, true, nil}, want: "lending,10,-5,1.5,true,\n"},
+		{
+			name: "quotes and separators", row: []any{`say "hi"`, "a,b", "two\nlines"},
+			want: "\"say \"\"hi\"\"\",\"a,b\",\"two\nlines\"\n",
+		},
+		{
+			name: "formula", row: []any{"=HYPERLINK(\"x\")", "+1", "-1", "@SUM(A1)"},
+			want: "\"'=HYPERLINK(\"\"x\"\")\",'+1,'-1,'@SUM(A1)\n",
+		},
+		{name: "tab and carriage return", row: []any{"\tcmd", "\rcmd"}, want: "'\tcmd,\"'\rcmd\"\n"},
+		{name: "formula sign inside the text", row: []any{"a=b", "1-2"}, want: "a=b,1-2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				w, err := New(FormatCSV, &buf, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = w.Write(tt.row)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = w.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if buf.String() != tt.want {
+					t.Fatalf("got %q, want %q", buf.String(), tt.want)
+				}
+			},
+		)
+	}
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Ref   string `xml:"r,attr"`
+		Cells []struct {
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+type xlsxBook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+func readZipPart(t *testing.T, z *zip.Reader, name string, v any) {
+	t.Helper()
+	f, err := z.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = xml.Unmarshal(content, v)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+}
+
+func TestXLSXWriterRoundTrip(t *testing.T) {
+	rows := [][]any{
+		{"id", "amount", "note"},
+		{"a<b&c", 1_000_000, "=1+1"},
+		{nil, -2.5, "\tline one\nline two"},
+	}
+	var buf bytes.Buffer
+	w, err := New(FormatXLSX, &buf, "Lending & bills")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		err = w.Write(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		var part struct{}
+		readZipPart(t, z, name, &part)
+	}
+	var book xlsxBook
+	readZipPart(t, z, "xl/workbook.xml", &book)
+	if len(book.Sheets) != 1 || book.Sheets[0].Name != "Lending & bills" {
+		t.Fatalf("got sheets %+v, want the escaped name", book.Sheets)
+	}
+
+	var sheet xlsxSheet
+	readZipPart(t, z, "xl/worksheets/sheet1.xml", &sheet)
+	var got [][]string
+	for i, row := range sheet.Rows {
+		if row.Ref != string(rune('1'+i)) {
+			t.Fatalf("row %d has reference %q", i, row.Ref)
+		}
+		var cells []string
+		for _, cell := range row.Cells {
+			switch cell.Type {
+			case "inlineStr":
+				cells = append(cells, "text:"+cell.Inline)
+			case "":
+				cells = append(cells, "value:"+cell.Value)
+			default:
+				t.Fatalf("unexpected cell type %q", cell.Type)
+			}
+		}
+		got = append(got, cells)
+	}
+	want := [][]string{
+		{"text:id", "text:amount", "text:note"},
+		{"text:a<b&c", "value:1000000", "text:'=1+1"},
+		{"value:", "value:-2.5", "text:'\tline one\nline two"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
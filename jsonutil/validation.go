@@ -0,0 +1,25 @@
+package jsonutil
+
+import "strings"
+
+// FieldError describes why a single field of the request is rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// FieldErrors is returned when one or more fields of the request violate the validation rules
+type FieldErrors []FieldError
+
+func (f FieldErrors) Error() string {
+	return strings.Join(f.Messages(), ", ")
+}
+
+func (f FieldErrors) Messages() []string {
+	messages := make([]string, len(f))
+	for i, fieldErr := range f {
+		messages[i] = fieldErr.Message
+	}
+	return messages
+}
@@ -0,0 +1,138 @@
+package loancalc
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Installment amounts are in whole rupiah
+type Installment struct {
+	Number             int    `json:"number"`
+	DueDate            string `json:"due_date"`
+	Principal          int64  `json:"principal"`
+	Interest           int64  `json:"interest"`
+	Amount             int64  `json:"amount"`
+	RemainingPrincipal int64  `json:"remaining_principal"`
+}
+
+// Schedule is the repayment of a loan, every quote, eligibility check and bill is derived from it
+type Schedule struct {
+	Amount int64 `json:"amount"`
+	Tenor  int   `json:"tenor"`
+	// InterestRate is the yearly percentage, or the percentage over the whole tenor with MethodFlatTotal
+	InterestRate   int           `json:"interest_rate"`
+	InterestMethod string        `json:"interest_method"`
+	Installments   []Installment `json:"installments"`
+	TotalInterest  int64         `json:"total_interest"`
+	TotalRepayment int64         `json:"total_repayment"`
+}
+
+// Rupiah rounds a decimal amount to the nearest whole rupiah
+func Rupiah(amount float64) int64 {
+	return int64(math.Round(amount))
+}
+
+// NewSchedule splits the loan into tenor monthly installments with the method, interestRate is the yearly percentage
+// except for MethodFlatTotal. The first installment is due a month after start
+func NewSchedule(method string, amount int64, interestRate int, tenor int, start time.Time) (Schedule, error) {
+	if amount <= 0 || tenor <= 0 || interestRate < 0 {
+		return Schedule{}, fmt.Errorf("invalid loan, amount and tenor must be positive")
+	}
+	calculator, ok := methods[method]
+	if !ok {
+		return Schedule{}, fmt.Errorf("invalid interest method %s", method)
+	}
+	return newSchedule(method, amount, interestRate, start, calculator.Installments(amount, interestRate, tenor)), nil
+}
+
+// Reschedule spreads the principal left after a prepayment over the remaining installments of a loan of tenor months.
+// The rate of MethodFlatTotal covers the whole tenor so it is pro-rated to the remaining months, yearly rates apply
+// as they are
+func Reschedule(
+	method string, principal int64, interestRate int, tenor int, remaining int, start time.Time,
+) (Schedule, error) {
+	if method != MethodFlatTotal {
+		return NewSchedule(method, principal, interestRate, remaining, start)
+	}
+	if principal <= 0 || remaining <= 0 || remaining > tenor || interestRate < 0 {
+		return Schedule{}, fmt.Errorf("invalid loan, amount and tenor must be positive")
+	}
+	//	rounded down like flatTotal
+	totalInterest := principal * int64(interestRate) * int64(remaining) / (100 * int64(tenor))
+	return newSchedule(method, principal, interestRate, start, evenInstallments(principal, totalInterest, remaining)), nil
+}
+
+// newSchedule numbers and dates the installments and sums them up
+func newSchedule(method string, amount int64, interestRate int, start time.Time, installments []Installment) Schedule {
+	schedule := Schedule{
+		Amount:         amount,
+		Tenor:          len(installments),
+		InterestRate:   interestRate,
+		InterestMethod: method,
+		Installments:   installments,
+	}
+	for i := range schedule.Installments {
+		installment := &schedule.Installments[i]
+		installment.Number = i + 1
+		installment.DueDate = addMonths(start, i+1).Format("2006-01-02")
+		installment.Amount = installment.Principal + installment.Interest
+		schedule.TotalInterest += installment.Interest
+	}
+	schedule.TotalRepayment = amount + schedule.TotalInterest
+	return schedule
+}
+
+// addMonths keeps the day of start, clamped to the last day of shorter months, so a loan started on the 31st is due on
+// the 28th of february instead of rolling over to march
+func addMonths(start time.Time, months int) time.Time {
+	firstOfMonth := time.Date(start.Year(), start.Month()+time.Month(months), 1, 0, 0, 0, 0, start.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	day := start.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+// MaxInstallment is the largest installment of the schedule, used for affordability checks
+func (s *Schedule) MaxInstallment() int64 {
+	var largest int64
+	for _, installment := range s.Installments {
+		if installment.Amount > largest {
+			largest = installment.Amount
+		}
+	}
+	return largest
+}
+
+// APR is the annual percentage rate, the nominal yearly rate at which the installments are worth the disbursed amount
+// (the amount minus the upfront fee). It is rounded to 2 decimals
+func (s *Schedule) APR(fee int64) float64 {
+	disbursed := float64(s.Amount - fee)
+	presentValue := func(monthlyRate float64) float64 {
+		var total float64
+		for _, installment := range s.Installments {
+			total += float64(installment.Amount) / math.Pow(1+monthlyRate, float64(installment.Number))
+		}
+		return total
+	}
+	if disbursed <= 0 || presentValue(0) <= disbursed {
+		return 0
+	}
+
+	//	the present value decreases as the rate grows so the rate is found by bisection
+	low, high := 0.0, 1.0
+	for presentValue(high) > disbursed {
+		high *= 2
+	}
+	for i := 0; i < 100; i++ {
+		mid := (low + high) / 2
+		if presentValue(mid) > disbursed {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return math.Round((low+high)/2*12*100*100) / 100
+}
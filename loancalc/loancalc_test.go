@@ -0,0 +1,183 @@
+package loancalc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewSchedule(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name               string
+		method             string
+		amount             int64
+		interestRate       int
+		tenor              int
+		wantTotalInterest  int64
+		wantMaxInstallment int64
+		wantDueDates       []string
+	}{
+		{
+			name: "flat", method: MethodFlat, amount: 12_000_000, interestRate: 12, tenor: 12,
+			wantTotalInterest: 1_440_000, wantMaxInstallment: 1_120_000,
+		},
+		{
+			name:   "flat total keeps the lump sum of the lending priced over the whole tenor",
+			method: MethodFlatTotal, amount: 12_000_000, interestRate: 12, tenor: 12,
+			wantTotalInterest: 1_440_000, wantMaxInstallment: 1_120_000,
+		},
+		{
+			name: "annuity", method: MethodAnnuity, amount: 12_000_000, interestRate: 12, tenor: 12,
+			wantTotalInterest: 794_226, wantMaxInstallment: 1_066_191,
+		},
+		{
+			name: "declining balance", method: MethodDecliningBalance, amount: 12_000_000, interestRate: 12, tenor: 12,
+			wantTotalInterest: 780_000, wantMaxInstallment: 1_120_000,
+		},
+		{
+			name: "due dates are clamped to the end of shorter months", method: MethodFlat, amount: 3_000_000,
+			interestRate: 12, tenor: 3, wantTotalInterest: 90_000, wantMaxInstallment: 1_030_000,
+			wantDueDates: []string{"2024-02-29", "2024-03-31", "2024-04-30"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				schedule, err := NewSchedule(tt.method, tt.amount, tt.interestRate, tt.tenor, start)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if schedule.TotalInterest != tt.wantTotalInterest {
+					t.Fatalf("total interest = %d, want %d", schedule.TotalInterest, tt.wantTotalInterest)
+				}
+				if schedule.TotalRepayment != tt.amount+tt.wantTotalInterest {
+					t.Fatalf("total repayment = %d, want %d", schedule.TotalRepayment, tt.amount+tt.wantTotalInterest)
+				}
+				if got := schedule.MaxInstallment(); got != tt.wantMaxInstallment {
+					t.Fatalf("max installment = %d, want %d", got, tt.wantMaxInstallment)
+				}
+				var principal int64
+				for i, installment := range schedule.Installments {
+					principal += installment.Principal
+					if installment.Number != i+1 || installment.Amount != installment.Principal+installment.Interest {
+						t.Fatalf("installment %d is %+v", i, installment)
+					}
+					if i < len(tt.wantDueDates) && installment.DueDate != tt.wantDueDates[i] {
+						t.Fatalf("installment %d due on %s, want %s", i, installment.DueDate, tt.wantDueDates[i])
+					}
+				}
+				if principal != tt.amount {
+					t.Fatalf("principal adds up to %d, want %d", principal, tt.amount)
+				}
+			},
+		)
+	}
+}
+
+func TestNewScheduleInvalid(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		amount       int64
+		interestRate int
+		tenor        int
+	}{
+		{"unknown method", "compound", 1_000_000, 12, 12},
+		{"zero amount", MethodFlat, 0, 12, 12},
+		{"zero tenor", MethodFlat, 1_000_000, 12, 0},
+		{"negative rate", MethodFlat, 1_000_000, -1, 12},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := NewSchedule(tt.method, tt.amount, tt.interestRate, tt.tenor, time.Now())
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			},
+		)
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	start := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name              string
+		method            string
+		principal         int64
+		interestRate      int
+		tenor             int
+		remaining         int
+		wantTotalInterest int64
+		wantInterests     []int64
+	}{
+		{
+			name: "flat total rate is pro-rated to the remaining months", method: MethodFlatTotal, principal: 5_000_000,
+			interestRate: 10, tenor: 6, remaining: 3, wantTotalInterest: 250_000,
+			wantInterests: []int64{83_333, 83_333, 83_334},
+		},
+		{
+			name: "flat total over the whole tenor", method: MethodFlatTotal, principal: 12_000_000, interestRate: 12,
+			tenor: 12, remaining: 12, wantTotalInterest: 1_440_000,
+		},
+		{
+			name: "yearly rate applies as it is", method: MethodFlat, principal: 6_000_000, interestRate: 12, tenor: 12,
+			remaining: 6, wantTotalInterest: 360_000, wantInterests: []int64{60_000, 60_000, 60_000, 60_000, 60_000, 60_000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				schedule, err := Reschedule(tt.method, tt.principal, tt.interestRate, tt.tenor, tt.remaining, start)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if schedule.Tenor != tt.remaining || len(schedule.Installments) != tt.remaining {
+					t.Fatalf("got %d installments, want %d", len(schedule.Installments), tt.remaining)
+				}
+				if schedule.TotalInterest != tt.wantTotalInterest {
+					t.Fatalf("total interest = %d, want %d", schedule.TotalInterest, tt.wantTotalInterest)
+				}
+				var principal int64
+				for i, installment := range schedule.Installments {
+					principal += installment.Principal
+					if tt.wantInterests != nil && installment.Interest != tt.wantInterests[i] {
+						t.Fatalf("installment %d interest = %d, want %d", i+1, installment.Interest, tt.wantInterests[i])
+					}
+				}
+				if principal != tt.principal {
+					t.Fatalf("principal sum = %d, want %d", principal, tt.principal)
+				}
+			},
+		)
+	}
+
+	_, err := Reschedule(MethodFlatTotal, 1_000_000, 10, 6, 7, start)
+	if err == nil {
+		t.Fatal("more remaining installments than the tenor was accepted")
+	}
+}
+
+func TestAPR(t *testing.T) {
+	tests := []struct {
+		name string
+		fee  int64
+		want float64
+	}{
+		{"without fee", 0, 21.46},
+		{"fee takes the whole amount", 12_000_000, 0},
+	}
+	schedule, err := NewSchedule(MethodFlat, 12_000_000, 12, 12, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := schedule.APR(tt.fee); got != tt.want {
+					t.Fatalf("apr = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
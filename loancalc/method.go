@@ -0,0 +1,117 @@
+package loancalc
+
+import "math"
+
+const (
+	// MethodFlat charges the yearly rate on the original amount for the whole tenor
+	MethodFlat = "flat"
+	// MethodAnnuity has equal installments, the interest is charged on the remaining principal
+	MethodAnnuity = "annuity"
+	// MethodDecliningBalance repays an equal principal every month, the interest is charged on the remaining principal
+	MethodDecliningBalance = "declining_balance"
+	// MethodFlatTotal charges the rate on the original amount once over the whole tenor instead of yearly, it keeps the
+	// cost of the lending priced before the rates became yearly
+	MethodFlatTotal = "flat_total"
+)
+
+// Calculator splits a loan into its installments, only Principal, Interest and RemainingPrincipal have to be set.
+// The principal of the installments must add up to amount
+type Calculator interface {
+	Installments(amount int64, interestRate int, tenor int) []Installment
+}
+
+var methods = map[string]Calculator{
+	MethodFlat:             flat{},
+	MethodAnnuity:          annuity{},
+	MethodDecliningBalance: decliningBalance{},
+	MethodFlatTotal:        flatTotal{},
+}
+
+func IsValidMethod(method string) bool {
+	_, ok := methods[method]
+	return ok
+}
+
+// RegisterMethod plugs a new calculator or replaces an existing one, it has to be called before serving requests
+func RegisterMethod(method string, calculator Calculator) {
+	methods[method] = calculator
+}
+
+// monthlyInterest is the interest of a month on principal at the yearly interestRate, rounded half up to the rupiah
+func monthlyInterest(principal int64, interestRate int) int64 {
+	return (principal*int64(interestRate)*2 + 1200) / 2400
+}
+
+// evenPrincipal splits amount into tenor equal parts, the rupiah left by the division goes to the last part
+func evenPrincipal(amount int64, tenor int, i int) int64 {
+	principal := amount / int64(tenor)
+	if i == tenor-1 {
+		return amount - principal*int64(tenor-1)
+	}
+	return principal
+}
+
+// evenInstallments splits both the amount and the totalInterest evenly over the tenor
+func evenInstallments(amount int64, totalInterest int64, tenor int) []Installment {
+	installments := make([]Installment, tenor)
+	remaining := amount
+	for i := range installments {
+		installments[i].Principal = evenPrincipal(amount, tenor, i)
+		installments[i].Interest = evenPrincipal(totalInterest, tenor, i)
+		remaining -= installments[i].Principal
+		installments[i].RemainingPrincipal = remaining
+	}
+	return installments
+}
+
+type flat struct{}
+
+func (flat) Installments(amount int64, interestRate int, tenor int) []Installment {
+	totalInterest := (amount*int64(interestRate)*int64(tenor)*2 + 1200) / 2400
+	return evenInstallments(amount, totalInterest, tenor)
+}
+
+type flatTotal struct{}
+
+func (flatTotal) Installments(amount int64, interestRate int, tenor int) []Installment {
+	//	rounded down like the lump sum these lending were billed with
+	return evenInstallments(amount, amount*int64(interestRate)/100, tenor)
+}
+
+type annuity struct{}
+
+func (annuity) Installments(amount int64, interestRate int, tenor int) []Installment {
+	if interestRate == 0 {
+		return decliningBalance{}.Installments(amount, interestRate, tenor)
+	}
+	rate := float64(interestRate) / 1200
+	payment := Rupiah(float64(amount) * rate / (1 - math.Pow(1+rate, -float64(tenor))))
+
+	installments := make([]Installment, tenor)
+	remaining := amount
+	for i := range installments {
+		interest := monthlyInterest(remaining, interestRate)
+		principal := payment - interest
+		//	the last installment settles whatever the rounding left
+		if i == tenor-1 || principal > remaining {
+			principal = remaining
+		}
+		remaining -= principal
+		installments[i] = Installment{Principal: principal, Interest: interest, RemainingPrincipal: remaining}
+	}
+	return installments
+}
+
+type decliningBalance struct{}
+
+func (decliningBalance) Installments(amount int64, interestRate int, tenor int) []Installment {
+	installments := make([]Installment, tenor)
+	remaining := amount
+	for i := range installments {
+		interest := monthlyInterest(remaining, interestRate)
+		principal := evenPrincipal(amount, tenor, i)
+		remaining -= principal
+		installments[i] = Installment{Principal: principal, Interest: interest, RemainingPrincipal: remaining}
+	}
+	return installments
+}
@@ -0,0 +1,119 @@
+package loancalc
+
+import (
+	"reflect"
+	"testing"
+)
+
+// each row is principal, interest and remaining principal
+func installments(rows ...[3]int64) []Installment {
+	res := make([]Installment, len(rows))
+	for i, row := range rows {
+		res[i] = Installment{Principal: row[0], Interest: row[1], RemainingPrincipal: row[2]}
+	}
+	return res
+}
+
+func TestInstallments(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		amount       int64
+		interestRate int
+		tenor        int
+		want         []Installment
+	}{
+		{
+			name: "flat", method: MethodFlat, amount: 12_000_000, interestRate: 12, tenor: 3,
+			want: installments(
+				[3]int64{4_000_000, 120_000, 8_000_000},
+				[3]int64{4_000_000, 120_000, 4_000_000},
+				[3]int64{4_000_000, 120_000, 0},
+			),
+		},
+		{
+			name: "flat remainder goes to the last installment", method: MethodFlat, amount: 1_000_000,
+			interestRate: 10, tenor: 3,
+			want: installments(
+				[3]int64{333_333, 8_333, 666_667},
+				[3]int64{333_333, 8_333, 333_334},
+				[3]int64{333_334, 8_334, 0},
+			),
+		},
+		{
+			name: "flat total", method: MethodFlatTotal, amount: 10_000_000, interestRate: 10, tenor: 6,
+			want: installments(
+				[3]int64{1_666_666, 166_666, 8_333_334},
+				[3]int64{1_666_666, 166_666, 6_666_668},
+				[3]int64{1_666_666, 166_666, 5_000_002},
+				[3]int64{1_666_666, 166_666, 3_333_336},
+				[3]int64{1_666_666, 166_666, 1_666_670},
+				[3]int64{1_666_670, 166_670, 0},
+			),
+		},
+		{
+			name: "annuity", method: MethodAnnuity, amount: 12_000_000, interestRate: 12, tenor: 12,
+			want: installments(
+				[3]int64{946_185, 120_000, 11_053_815},
+				[3]int64{955_647, 110_538, 10_098_168},
+				[3]int64{965_203, 100_982, 9_132_965},
+				[3]int64{974_855, 91_330, 8_158_110},
+				[3]int64{984_604, 81_581, 7_173_506},
+				[3]int64{994_450, 71_735, 6_179_056},
+				[3]int64{1_004_394, 61_791, 5_174_662},
+				[3]int64{1_014_438, 51_747, 4_160_224},
+				[3]int64{1_024_583, 41_602, 3_135_641},
+				[3]int64{1_034_829, 31_356, 2_100_812},
+				[3]int64{1_045_177, 21_008, 1_055_635},
+				[3]int64{1_055_635, 10_556, 0},
+			),
+		},
+		{
+			name: "annuity last installment settles the rounding", method: MethodAnnuity, amount: 5_000_000,
+			interestRate: 18, tenor: 6,
+			want: installments(
+				[3]int64{802_626, 75_000, 4_197_374},
+				[3]int64{814_665, 62_961, 3_382_709},
+				[3]int64{826_885, 50_741, 2_555_824},
+				[3]int64{839_289, 38_337, 1_716_535},
+				[3]int64{851_878, 25_748, 864_657},
+				[3]int64{864_657, 12_970, 0},
+			),
+		},
+		{
+			name: "annuity without interest", method: MethodAnnuity, amount: 1_000_000, interestRate: 0, tenor: 3,
+			want: installments(
+				[3]int64{333_333, 0, 666_667},
+				[3]int64{333_333, 0, 333_334},
+				[3]int64{333_334, 0, 0},
+			),
+		},
+		{
+			name: "declining balance", method: MethodDecliningBalance, amount: 12_000_000, interestRate: 12, tenor: 4,
+			want: installments(
+				[3]int64{3_000_000, 120_000, 9_000_000},
+				[3]int64{3_000_000, 90_000, 6_000_000},
+				[3]int64{3_000_000, 60_000, 3_000_000},
+				[3]int64{3_000_000, 30_000, 0},
+			),
+		},
+		{
+			name: "declining balance rounds half up", method: MethodDecliningBalance, amount: 1_000_100,
+			interestRate: 6, tenor: 2,
+			want: installments(
+				[3]int64{500_050, 5_001, 500_050},
+				[3]int64{500_050, 2_500, 0},
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got := methods[tt.method].Installments(tt.amount, tt.interestRate, tt.tenor)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %+v, want %+v", got, tt.want)
+				}
+			},
+		)
+	}
+}
@@ -12,6 +12,10 @@ import (
 	"github.com/Tus1688/kim-hackathon-2023-api/middlewares"
 	"github.com/Tus1688/kim-hackathon-2023-api/midtrans"
 	"github.com/Tus1688/kim-hackathon-2023-api/models"
+	"github.com/Tus1688/kim-hackathon-2023-api/payout"
+	"github.com/Tus1688/kim-hackathon-2023-api/scoring"
+	"github.com/Tus1688/kim-hackathon-2023-api/sms"
+	"github.com/Tus1688/kim-hackathon-2023-api/uploadutil"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -37,7 +41,47 @@ func main() {
 
 	models.InitializeGoBlobBaseUrl()
 	models.InitializeGoBlobAuthorization()
-	models.InitializeFlaskMLBaseUrl()
+	uploadutil.InitializeScanner()
+
+	err = scoring.Initialize()
+	if err != nil {
+		log.Fatal("unable to initialize credit scoring", err)
+	}
+
+	err = models.InitializeDualApprovalThreshold()
+	if err != nil {
+		log.Fatal("unable to initialize dual approval threshold", err)
+	}
+
+	err = models.InitializeLendingRules()
+	if err != nil {
+		log.Fatal("unable to initialize lending rules", err)
+	}
+
+	err = models.InitializeDecisionPolicy()
+	if err != nil {
+		log.Fatal("unable to initialize decision policy", err)
+	}
+
+	err = models.InitializeAgreementTemplate()
+	if err != nil {
+		log.Fatal("unable to initialize agreement template", err)
+	}
+
+	err = payout.Initialize()
+	if err != nil {
+		log.Fatal("unable to initialize payout", err)
+	}
+
+	err = sms.Initialize()
+	if err != nil {
+		log.Fatal("unable to initialize sms", err)
+	}
+
+	err = authutil.InitializeDocumentUrlKey()
+	if err != nil {
+		log.Fatal("unable to initialize document url key", err)
+	}
 
 	err = database.InitAdmin()
 	if err != nil {
@@ -50,6 +94,7 @@ func main() {
 	midtrans.BaseUrlSnap = os.Getenv("MIDTRANS_BASE_URL_SNAP")
 	midtrans.BaseUrlCoreApi = os.Getenv("MIDTRANS_BASE_URL_CORE_API")
 	midtrans.BaseOrderId = os.Getenv("MIDTRANS_BASE_ORDER_ID")
+	midtrans.SettleBill = models.SettleBill
 
 	log.Print("server running on port 3000")
 	r := initRouter()
@@ -78,6 +123,8 @@ func initRouter() *chi.Mux {
 					r.Get("/total-sme", controllers.GetTotalSME)
 					r.Get("/total-awaiting", controllers.GetAwaitingApproval)
 					r.Get("/top-product", controllers.GetTopProduct)
+					r.Get("/portfolio", controllers.GetPortfolioSummary)
+					r.Get("/portfolio-monthly", controllers.GetPortfolioMonthly)
 				},
 			)
 
@@ -145,6 +192,9 @@ func initRouter() *chi.Mux {
 						// unprotected routes for borrower
 						"/user", func(r chi.Router) {
 							r.Post("/register", controllers.RegisterAsBorrower)
+							r.Get("/rules", controllers.GetLendingRules)
+							r.Get("/lookup", controllers.GetLendingEnums)
+							r.Get("/quote", controllers.QuoteLending)
 
 							// protected route for borrower
 							r.Group(
@@ -152,29 +202,130 @@ func initRouter() *chi.Mux {
 									r.Use(middlewares.EnforceAuthentication([]string{"user"}, 3, true))
 
 									r.Post("/document", controllers.UploadDocument)
+									r.Get("/document", controllers.GetDocumentUser)
 									r.Post("/proposal", controllers.CreateLendingProposal)
 
 									r.Get("/proposal", controllers.GetLendingProposalUser)
+									r.Patch("/proposal", controllers.ModifyLendingProposal)
+									r.Post("/proposal-cancel", controllers.CancelLendingProposal)
+									r.Get("/proposal-offer", controllers.GetLendingOffer)
+									r.Post("/proposal-offer", controllers.CreateLendingOffer)
+									r.Post("/proposal-offer-accept", controllers.AcceptLendingOffer)
+									r.Post("/proposal-guarantor", controllers.CreateGuarantor)
+									r.Get("/proposal-guarantor", controllers.GetGuarantorsUser)
+									r.Delete("/proposal-guarantor", controllers.DeleteGuarantor)
+									r.Post("/proposal-guarantor-invite", controllers.ReinviteGuarantor)
+									r.Post("/collateral", controllers.CreateCollateral)
+									r.Get("/collateral", controllers.GetCollateralsUser)
+									r.Delete("/collateral", controllers.DeleteCollateral)
+									r.Post("/bank-account", controllers.CreateBankAccount)
+									r.Get("/bank-account", controllers.GetBankAccounts)
+									r.Post("/bank-account-primary", controllers.SetPrimaryBankAccount)
+									r.Get("/agreement", controllers.GetAgreement)
+									r.Post("/agreement-accept", controllers.AcceptAgreement)
+									r.Get("/installment", controllers.GetLendingInstallments)
+									r.Get("/payoff", controllers.GetPayoffQuote)
+									r.Post("/payment", controllers.CreateLendingPayment)
+								},
+							)
+						},
+					)
+
+					r.Route(
+						// unprotected routes for lender
+						"/lender", func(r chi.Router) {
+							r.Post("/register", controllers.RegisterAsLender)
+
+							// protected route for lender
+							r.Group(
+								func(r chi.Router) {
+									r.Use(middlewares.EnforceAuthentication([]string{"lender"}, 3, true))
+
+									r.Get("/marketplace", controllers.GetMarketplace)
+									r.Post("/commitment", controllers.CommitFunding)
+									r.Delete("/commitment", controllers.WithdrawCommitment)
+									r.Get("/commitment", controllers.GetLenderCommitments)
+									r.Get("/distribution", controllers.GetLenderDistributions)
 								},
 							)
 						},
 					)
 
+					// unprotected routes for guarantor, the invitation token identifies them
+					r.Route(
+						"/guarantor", func(r chi.Router) {
+							r.Get("/invitation", controllers.GetGuarantorInvitation)
+							r.Post("/invitation-confirm", controllers.ConfirmGuarantorInvitation)
+							r.Post("/invitation-decline", controllers.DeclineGuarantorInvitation)
+						},
+					)
+
 					// protected route for admin
 					r.Route(
 						"/admin", func(r chi.Router) {
-							r.Use(middlewares.EnforceAuthentication([]string{"admin"}, 3, false))
+							r.Use(middlewares.EnforceAuthentication([]string{"admin"}, 3, true))
 
 							r.Get("/proposal", controllers.GetLendingProposalAdmin)
 							r.Get("/proposal-predict", controllers.PredictCreditScore)
+							r.Post("/proposal-predict-batch", controllers.StartBatchScoring)
+							r.Get("/proposal-predict-batch", controllers.GetBatchScoring)
+							r.Get("/proposal-score", controllers.GetCreditScoreHistory)
+							r.Get("/proposal-decision", controllers.GetLendingDecisions)
+							r.Get("/proposal-revision", controllers.GetLendingRevisions)
+							r.Get("/proposal-guarantor", controllers.GetGuarantorsAdmin)
+							r.Get("/collateral", controllers.GetCollateralsAdmin)
+							r.Post("/collateral-appraise", controllers.AppraiseCollateral)
+							r.Get("/commitment", controllers.GetLendingCommitments)
+							r.Post("/commitment-collect", controllers.CollectCommitment)
+							r.Post("/commitment-payout", controllers.PayLender)
+							r.Get("/commitment-transfer", controllers.GetLendingTransfers)
+							r.Get("/decision-policy", controllers.GetDecisionPolicy)
+							r.Post("/decision-simulate", controllers.SimulateDecisionPolicy)
+							r.Get("/pricing-grid", controllers.GetPricingGrids)
+							r.Post("/pricing-grid", controllers.CreatePricingGrid)
+							r.Post("/pricing-grid-activate", controllers.ActivatePricingGrid)
 
-							r.Post("/proposal-approve", controllers.ApproveLending)
+							// approving, manually or through the decision policy, requires the approver permission on top
+							// of admin
+							r.Group(
+								func(r chi.Router) {
+									r.Use(middlewares.EnforceAuthentication([]string{"admin", "approver"}, 3, true))
+
+									r.Get("/proposal-approval-queue", controllers.GetLendingApprovalQueue)
+									r.Post("/proposal-approve", controllers.ApproveLending)
+									r.Post("/proposal-decide", controllers.DecideLending)
+								},
+							)
 							r.Post("/proposal-reject", controllers.RejectLending)
-							r.Post("/make-payment", controllers.MakePayment)
+							r.Get("/agreement", controllers.GetAgreementInfo)
+							r.Post("/disburse", controllers.DisburseLending)
+							r.Post("/disbursement-refresh", controllers.RefreshDisbursement)
+							r.Get("/disbursement", controllers.GetLendingDisbursements)
+							r.Get("/export", controllers.ExportReport)
+							r.Get("/export-log", controllers.GetExportLogs)
+							r.Get("/ledger", controllers.GetJournalEntries)
+							r.Get("/ledger-trial-balance", controllers.GetTrialBalance)
 						},
 					)
 				},
 			)
+			r.Route(
+				"/document", func(r chi.Router) {
+					// unprotected route, the signature is the authorization
+					r.Get("/signed", controllers.GetSignedDocument)
+
+					// protected routes for the owner or staff with document_viewer permission
+					r.Group(
+						func(r chi.Router) {
+							r.Use(middlewares.EnforceAuthentication([]string{}, 3, true))
+
+							r.Get("/", controllers.GetDocument)
+							r.Get("/url", controllers.CreateDocumentUrl)
+						},
+					)
+				},
+			)
+
 			r.Get("/public/product", controllers.GetPublicProduct)
 
 			r.Post("/webhook/midtrans", midtrans.HandleNotifications)
@@ -30,8 +30,7 @@ func EnforceAuthentication(
 			}
 			if len(requiredRoles) == 0 {
 				if passUserId {
-					ctx := context.WithValue(r.Context(), "uid", claim.Uid)
-					r = r.WithContext(ctx)
+					r = r.WithContext(withClaim(r.Context(), claim))
 				}
 				next.ServeHTTP(w, r)
 				return
@@ -43,8 +42,7 @@ func EnforceAuthentication(
 			}
 
 			if passUserId {
-				ctx := context.WithValue(r.Context(), "uid", claim.Uid)
-				r = r.WithContext(ctx)
+				r = r.WithContext(withClaim(r.Context(), claim))
 			}
 			next.ServeHTTP(w, r)
 		}
@@ -52,6 +50,12 @@ func EnforceAuthentication(
 	}
 }
 
+// withClaim passes the uid and roles of the requester to the next handler
+func withClaim(ctx context.Context, claim *authutil.JWTClaimAccessUser) context.Context {
+	ctx = context.WithValue(ctx, "uid", claim.Uid)
+	return context.WithValue(ctx, "roles", claim.Roles)
+}
+
 func verifyRoles(requiredRoles []string, userRoles []string) bool {
 	for _, role := range requiredRoles {
 		found := false
@@ -3,7 +3,9 @@ package midtrans
 import (
 	"bytes"
 	"crypto/sha512"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -17,6 +19,9 @@ var ServerKeyEncoded string
 var BaseUrlSnap string
 var BaseUrlCoreApi string
 
+// SettleBill allocates a settled bill to its lending, it is set by main as models depends on this package
+var SettleBill func(id string, status string) error
+
 // BaseOrderId is used to prefix the order id in database
 // for example if the order id is 1, then the order id in midtrans is "something-1"
 var BaseOrderId string
@@ -76,29 +81,71 @@ func HandleNotifications(w http.ResponseWriter, r *http.Request) {
 	// strip the BaseOrderId+"-" from the order id
 	// for example if the order id is "something-1", then the order id in database is 1
 	OrderId := request.OrderId[len(BaseOrderId)+1:]
-	// if there is FraudStatus, always check if it is "accept"
-	// if transaction_status value is settlement or capture change the is_paid to true
-	if request.TransactionStatus == "settlement" || request.TransactionStatus == "capture" {
-		if request.FraudStatus != "deny" && request.FraudStatus != "challenge" {
-			_, err := database.MysqlInstance.Exec(
-				`UPDATE lending set is_paid = true, status = ? WHERE id = UUID_TO_BIN(?)`, request.TransactionStatus,
-				OrderId,
-			)
-			if err != nil {
-				log.Print(err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+
+	// every repayment of a lending is collected through a bill, the order id is the id of the bill
+	var isBill int
+	err := database.MysqlInstance.QueryRow(`SELECT 1 FROM bill WHERE id = UUID_TO_BIN(?)`, OrderId).Scan(&isBill)
+	if err == nil {
+		handleBillNotification(w, OrderId, request)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// lump sum payments created before the bills used the id of the lending as order id
+	var isLending int
+	err = database.MysqlInstance.QueryRow(
+		`SELECT 1 FROM lending WHERE id = UUID_TO_BIN(?) AND payment_token IS NOT NULL`, OrderId,
+	).Scan(&isLending)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("notification for unknown order %s", request.OrderId)
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
-	} else {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	handleLendingNotification(w, OrderId, request)
+}
+
+// handleLendingNotification settles a lump sum payment of the whole lending. These lending predate the ledger and the
+// installments so only the lending is marked as paid, any other status leaves the lending as it is
+func handleLendingNotification(w http.ResponseWriter, id string, request WebhookNotification) {
+	if (request.TransactionStatus == "settlement" || request.TransactionStatus == "capture") &&
+		request.FraudStatus != "deny" && request.FraudStatus != "challenge" {
 		_, err := database.MysqlInstance.Exec(
-			`UPDATE lending set status = ? WHERE id = UUID_TO_BIN(?)`, request.TransactionStatus, OrderId,
+			`UPDATE lending SET is_paid = TRUE, status = 'paid' WHERE id = UUID_TO_BIN(?) AND is_paid = FALSE`, id,
 		)
 		if err != nil {
 			log.Print(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+	} else {
+		log.Printf("lump sum payment of lending %s is %s", id, request.TransactionStatus)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleBillNotification(w http.ResponseWriter, id string, request WebhookNotification) {
+	var err error
+	if (request.TransactionStatus == "settlement" || request.TransactionStatus == "capture") &&
+		request.FraudStatus != "deny" && request.FraudStatus != "challenge" {
+		err = SettleBill(id, request.TransactionStatus)
+	} else {
+		_, err = database.MysqlInstance.Exec(
+			`UPDATE bill SET status = ? WHERE id = UUID_TO_BIN(?) AND is_paid = FALSE`, request.TransactionStatus, id,
+		)
+	}
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,326 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/loancalc"
+	"github.com/Tus1688/kim-hackathon-2023-api/pdfutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/uploadutil"
+)
+
+// defaultAgreementTemplate renders one pdf line per line, a line starting with "# " is a heading
+const defaultAgreementTemplate = `# Loan Agreement
+Agreement for lending {{.LendingId}}, generated on {{.GeneratedOn}}.
+
+# Borrower
+Username: {{.Username}}
+Age: {{.Age}}
+Monthly income: Rp {{rupiah .Income}}
+
+# Loan
+Amount: Rp {{rupiah .Schedule.Amount}}
+Origination fee: Rp {{rupiah .Fee}}, deducted from the disbursement
+Disbursed amount: Rp {{rupiah .Disbursed}}
+Interest rate: {{.InterestRate}}, {{.Schedule.InterestMethod}} method
+Tenor: {{.Schedule.Tenor}} months
+Annual percentage rate: {{printf "%.2f" .APR}}%
+Total interest: Rp {{rupiah .Schedule.TotalInterest}}
+Total repayment: Rp {{rupiah .Schedule.TotalRepayment}}
+Late fee: Rp {{rupiah .LateFee}} charged once on every overdue installment
+
+# Repayment schedule
+The first installment is due one month after the disbursement and every month after that.
+{{range .Schedule.Installments}}  Installment {{.Number}}: principal Rp {{rupiah .Principal}}, interest Rp {{rupiah .Interest}}, total Rp {{rupiah .Amount}}
+{{end}}
+# Terms
+Payments are allocated to fees first, then to the interest due, then to the principal.
+The borrower may repay any amount from Rp {{rupiah .MinPayment}} up to the payoff amount at any time, a prepayment reduces the following installments.
+The loan is only disbursed once the borrower accepts this agreement.
+`
+
+type agreementData struct {
+	LendingId   string
+	GeneratedOn string
+	Username    string
+	Age         int
+	Income      int64
+	Schedule    loancalc.Schedule
+	// InterestRate is the rate with its period, yearly or over the whole tenor for loancalc.MethodFlatTotal
+	InterestRate string
+	Fee          int64
+	Disbursed    int64
+	APR          float64
+	LateFee      int64
+	MinPayment   int64
+}
+
+type AcceptAgreementRequest struct {
+	// ContentHash is the sha256 of the pdf the borrower accepts, it has to match the stored agreement
+	ContentHash string `json:"content_hash" binding:"required"`
+}
+
+type AgreementResponse struct {
+	LendingId         string `json:"lending_id"`
+	ContentHash       string `json:"content_hash"`
+	TemplateVersion   string `json:"template_version"`
+	GeneratedOn       string `json:"generated_on"`
+	AcceptedOn        string `json:"accepted_on,omitempty"`
+	AcceptedIpAddress string `json:"accepted_ip_address,omitempty"`
+	AcceptedUserAgent string `json:"accepted_user_agent,omitempty"`
+}
+
+var agreementTemplate *template.Template
+var agreementTemplateVersion string
+
+// formatRupiah groups the thousands with dots, e.g. 1.000.000
+func formatRupiah(amount int64) string {
+	digits := strconv.FormatInt(amount, 10)
+	sign := ""
+	if amount < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(digit)
+	}
+	return sign + b.String()
+}
+
+// InitializeAgreementTemplate parses the template pointed by AGREEMENT_TEMPLATE_FILE or the default one, the version
+// stored with every agreement is derived from the template content
+func InitializeAgreementTemplate() error {
+	text := defaultAgreementTemplate
+	agreementTemplateVersion = "default-v2"
+	if path := os.Getenv("AGREEMENT_TEMPLATE_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		text = string(content)
+		sum := sha256.Sum256(content)
+		agreementTemplateVersion = "file-" + hex.EncodeToString(sum[:])[:12]
+	}
+
+	tmpl, err := template.New("agreement").Funcs(template.FuncMap{"rupiah": formatRupiah}).Parse(text)
+	if err != nil {
+		return err
+	}
+	agreementTemplate = tmpl
+	return nil
+}
+
+// renderAgreement builds the pdf of the lending from the template, the terms it returns are stored with the agreement
+// so the lending is disbursed with the fee the borrower accepted
+func renderAgreement(id string) ([]byte, agreementData, error) {
+	data := agreementData{LendingId: id, GeneratedOn: time.Now().UTC().Format("2006-01-02")}
+	var amount, income float64
+	var interestRate, tenor int
+	var interestMethod string
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT u.username, l.age, l.income, l.amount, l.interest_rate, l.tenor, l.interest_method
+		FROM lending l
+		INNER JOIN users u ON u.id = l.user_refer
+		WHERE l.id = UUID_TO_BIN(?)
+	`, id,
+	).Scan(&data.Username, &data.Age, &income, &amount, &interestRate, &tenor, &interestMethod)
+	if err != nil {
+		return nil, agreementData{}, err
+	}
+
+	data.Income = loancalc.Rupiah(income)
+	data.Schedule, err = loancalc.NewSchedule(interestMethod, loancalc.Rupiah(amount), interestRate, tenor, time.Now())
+	if err != nil {
+		return nil, agreementData{}, err
+	}
+	data.InterestRate = fmt.Sprintf("%d%% per year", interestRate)
+	if interestMethod == loancalc.MethodFlatTotal {
+		data.InterestRate = fmt.Sprintf("%d%% over the %d months tenor", interestRate, tenor)
+	}
+	data.Fee = originationFee(data.Schedule.Amount)
+	data.Disbursed = data.Schedule.Amount - data.Fee
+	data.APR = data.Schedule.APR(data.Fee)
+	data.LateFee = lendingRules.LateFee
+	data.MinPayment = lendingRules.MinPaymentAmount
+
+	var text bytes.Buffer
+	err = agreementTemplate.Execute(&text, data)
+	if err != nil {
+		return nil, agreementData{}, err
+	}
+	doc := pdfutil.New("Loan Agreement " + id)
+	for _, line := range strings.Split(strings.TrimRight(text.String(), "\n"), "\n") {
+		if heading, ok := strings.CutPrefix(line, "# "); ok {
+			doc.Heading(heading)
+			continue
+		}
+		doc.Text(line)
+	}
+	return doc.Bytes(), data, nil
+}
+
+// GetAgreement returns the agreement pdf of the approved lending of uid with its sha256, it is generated and stored
+// the first time so the borrower always accepts the exact same document
+func GetAgreement(id string, uid string) (DocumentStream, string, error) {
+	var isApproved bool
+	err := database.MysqlInstance.QueryRow(
+		`SELECT is_approved FROM lending WHERE id = UUID_TO_BIN(?) AND user_refer = UUID_TO_BIN(?)`, id, uid,
+	).Scan(&isApproved)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DocumentStream{}, "", fmt.Errorf("lending not found")
+		}
+		return DocumentStream{}, "", err
+	}
+	if !isApproved {
+		return DocumentStream{}, "", fmt.Errorf("lending is not approved")
+	}
+
+	var fileName, contentHash string
+	err = database.MysqlInstance.QueryRow(
+		`SELECT file_name, content_hash FROM agreements WHERE lending_refer = UUID_TO_BIN(?)`, id,
+	).Scan(&fileName, &contentHash)
+	if err == nil {
+		doc, err := fetchDocument(fileName)
+		return doc, contentHash, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return DocumentStream{}, "", err
+	}
+
+	content, data, err := renderAgreement(id)
+	if err != nil {
+		return DocumentStream{}, "", err
+	}
+	sum := sha256.Sum256(content)
+	contentHash = hex.EncodeToString(sum[:])
+	blob, err := uploadToGoBlob(
+		uploadutil.File{Filename: "agreement-" + id + ".pdf", ContentType: "application/pdf", Content: content},
+	)
+	if err != nil {
+		return DocumentStream{}, "", err
+	}
+	_, err = database.MysqlInstance.Exec(
+		`INSERT INTO agreements (lending_refer, file_name, content_hash, template_version, amount, fee, interest_rate, tenor, interest_method) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, blob.Filename, contentHash, agreementTemplateVersion, data.Schedule.Amount, data.Fee,
+		data.Schedule.InterestRate, data.Schedule.Tenor, data.Schedule.InterestMethod,
+	)
+	if err != nil {
+		//	generated concurrently, serve the one that was stored first
+		if strings.Contains(err.Error(), "Duplicate") {
+			return GetAgreement(id, uid)
+		}
+		return DocumentStream{}, "", err
+	}
+	return DocumentStream{
+		Body:          io.NopCloser(bytes.NewReader(content)),
+		ContentType:   "application/pdf",
+		ContentLength: int64(len(content)),
+	}, contentHash, nil
+}
+
+// AcceptAgreement records the acceptance of the agreement the borrower has viewed, the hash has to match the
+// stored pdf and the lending has to be still waiting for its disbursement
+func (a *AcceptAgreementRequest) AcceptAgreement(id string, uid string, requester DocumentRequester) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var contentHash, status string
+	var accepted, isApproved, isDisbursed bool
+	err = tx.QueryRow(
+		`
+		SELECT a.content_hash, a.accepted_at IS NOT NULL, l.status, l.is_approved, l.disbursed_at IS NOT NULL
+		FROM agreements a
+		INNER JOIN lending l ON l.id = a.lending_refer
+		WHERE a.lending_refer = UUID_TO_BIN(?) AND l.user_refer = UUID_TO_BIN(?)
+		FOR UPDATE
+	`, id, uid,
+	).Scan(&contentHash, &accepted, &status, &isApproved, &isDisbursed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("agreement not found")
+		}
+		return err
+	}
+	if accepted {
+		return fmt.Errorf("agreement is already accepted")
+	}
+	if !isApproved || isDisbursed || status != "approved" {
+		return fmt.Errorf("lending is no longer awaiting disbursement")
+	}
+	if !strings.EqualFold(a.ContentHash, contentHash) {
+		return fmt.Errorf("content hash does not match the agreement")
+	}
+
+	_, err = tx.Exec(
+		`UPDATE agreements SET accepted_at = CURRENT_TIMESTAMP, accepted_ip_address = ?, accepted_user_agent = ? WHERE lending_refer = UUID_TO_BIN(?)`,
+		requester.IpAddress, requester.truncatedUserAgent(), id,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func GetAgreementInfo(id string) (AgreementResponse, error) {
+	res := AgreementResponse{LendingId: id}
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT content_hash, template_version, created_at, COALESCE(accepted_at, ''), COALESCE(accepted_ip_address, ''),
+		       COALESCE(accepted_user_agent, '')
+		FROM agreements
+		WHERE lending_refer = UUID_TO_BIN(?)
+	`, id,
+	).Scan(
+		&res.ContentHash, &res.TemplateVersion, &res.GeneratedOn, &res.AcceptedOn, &res.AcceptedIpAddress,
+		&res.AcceptedUserAgent,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AgreementResponse{}, fmt.Errorf("agreement not found")
+		}
+		return AgreementResponse{}, err
+	}
+	return res, nil
+}
+
+// agreementTerms are the terms of the loan as rendered in the agreement the borrower accepted
+type agreementTerms struct {
+	amount         int64
+	fee            int64
+	interestRate   int
+	tenor          int
+	interestMethod string
+}
+
+// getAcceptedAgreementTerms is required before a lending is disbursed, it fails when the borrower has not accepted the
+// agreement
+func getAcceptedAgreementTerms(tx *sql.Tx, id string) (agreementTerms, error) {
+	var terms agreementTerms
+	err := tx.QueryRow(
+		`SELECT amount, fee, interest_rate, tenor, interest_method FROM agreements WHERE lending_refer = UUID_TO_BIN(?) AND accepted_at IS NOT NULL`,
+		id,
+	).Scan(&terms.amount, &terms.fee, &terms.interestRate, &terms.tenor, &terms.interestMethod)
+	if errors.Is(err, sql.ErrNoRows) {
+		return agreementTerms{}, fmt.Errorf("agreement has not been accepted by the borrower")
+	}
+	return terms, err
+}
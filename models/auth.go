@@ -18,9 +18,12 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Username string `json:"username"`
-	IsAdmin  bool   `json:"is_admin"`
-	IsUser   bool   `json:"is_user"`
+	Username         string `json:"username"`
+	IsAdmin          bool   `json:"is_admin"`
+	IsUser           bool   `json:"is_user"`
+	IsApprover       bool   `json:"is_approver"`
+	IsDocumentViewer bool   `json:"is_document_viewer"`
+	IsLender         bool   `json:"is_lender"`
 }
 
 type compareUser struct {
@@ -28,6 +31,9 @@ type compareUser struct {
 	hashedPassword string
 	isAdmin        bool
 	isUser         bool
+	isApprover     bool
+	isViewer       bool
+	isLender       bool
 }
 
 type internalRefresh struct {
@@ -37,22 +43,28 @@ type internalRefresh struct {
 }
 
 type CreateUser struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
-	IsAdmin  bool   `json:"is_admin"`
+	Username         string `json:"username" binding:"required"`
+	Password         string `json:"password" binding:"required"`
+	IsAdmin          bool   `json:"is_admin"`
+	IsApprover       bool   `json:"is_approver"`
+	IsDocumentViewer bool   `json:"is_document_viewer"`
 }
 
 type UserResponse struct {
-	Id        string `json:"id"`
-	Username  string `json:"username"`
-	IsAdmin   bool   `json:"is_admin"`
-	UpdatedOn string `json:"updated_on"`
+	Id               string `json:"id"`
+	Username         string `json:"username"`
+	IsAdmin          bool   `json:"is_admin"`
+	IsApprover       bool   `json:"is_approver"`
+	IsDocumentViewer bool   `json:"is_document_viewer"`
+	UpdatedOn        string `json:"updated_on"`
 }
 
 type ModifyUser struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password"`
-	IsAdmin  bool   `json:"is_admin"`
+	Username         string `json:"username" binding:"required"`
+	Password         string `json:"password"`
+	IsAdmin          bool   `json:"is_admin"`
+	IsApprover       bool   `json:"is_approver"`
+	IsDocumentViewer bool   `json:"is_document_viewer"`
 }
 
 func (c *compareUser) SerializeRoles() []string {
@@ -63,14 +75,24 @@ func (c *compareUser) SerializeRoles() []string {
 	if c.isUser {
 		roles = append(roles, "user")
 	}
+	if c.isApprover {
+		roles = append(roles, "approver")
+	}
+	if c.isViewer {
+		roles = append(roles, "document_viewer")
+	}
+	if c.isLender {
+		roles = append(roles, "lender")
+	}
 	return roles
 }
 
 func (l *LoginRequest) Login() (string, string, error, LoginResponse) {
 	var row compareUser
 	err := database.MysqlInstance.QueryRow(
-		`SELECT BIN_TO_UUID(id), hashed_password, is_admin, is_user FROM users WHERE username = ?`, l.Username,
-	).Scan(&row.id, &row.hashedPassword, &row.isAdmin, &row.isUser)
+		`SELECT BIN_TO_UUID(id), hashed_password, is_admin, is_user, is_approver, is_document_viewer, is_lender FROM users WHERE username = ?`,
+		l.Username,
+	).Scan(&row.id, &row.hashedPassword, &row.isAdmin, &row.isUser, &row.isApprover, &row.isViewer, &row.isLender)
 	if err != nil {
 		time.Sleep(55 * time.Millisecond)
 		return "", "", fmt.Errorf("invalid username or password"), LoginResponse{}
@@ -104,9 +126,12 @@ func (l *LoginRequest) Login() (string, string, error, LoginResponse) {
 	}
 
 	return accessToken, refreshToken, nil, LoginResponse{
-		Username: l.Username,
-		IsAdmin:  row.isAdmin,
-		IsUser:   row.isUser,
+		Username:         l.Username,
+		IsAdmin:          row.isAdmin,
+		IsUser:           row.isUser,
+		IsApprover:       row.isApprover,
+		IsDocumentViewer: row.isViewer,
+		IsLender:         row.isLender,
 	}
 }
 
@@ -139,8 +164,8 @@ func (c *CreateUser) Create() error {
 		return err
 	}
 	_, err = database.MysqlInstance.Exec(
-		"INSERT INTO users (username, hashed_password, is_admin) VALUES (?, ?, ?)",
-		c.Username, string(bytes), c.IsAdmin,
+		"INSERT INTO users (username, hashed_password, is_admin, is_approver, is_document_viewer) VALUES (?, ?, ?, ?, ?)",
+		c.Username, string(bytes), c.IsAdmin, c.IsApprover, c.IsDocumentViewer,
 	)
 	if err != nil {
 		return err
@@ -149,7 +174,9 @@ func (c *CreateUser) Create() error {
 }
 
 func GetAllUsers() ([]UserResponse, error) {
-	rows, err := database.MysqlInstance.Query("SELECT BIN_TO_UUID(id), username, is_admin, updated_at FROM users")
+	rows, err := database.MysqlInstance.Query(
+		"SELECT BIN_TO_UUID(id), username, is_admin, is_approver, is_document_viewer, updated_at FROM users",
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -158,7 +185,9 @@ func GetAllUsers() ([]UserResponse, error) {
 	var res []UserResponse
 	for rows.Next() {
 		var temp UserResponse
-		err := rows.Scan(&temp.Id, &temp.Username, &temp.IsAdmin, &temp.UpdatedOn)
+		err := rows.Scan(
+			&temp.Id, &temp.Username, &temp.IsAdmin, &temp.IsApprover, &temp.IsDocumentViewer, &temp.UpdatedOn,
+		)
 		if err != nil {
 			return nil, err
 		}
@@ -199,6 +228,16 @@ func (m *ModifyUser) Modify() error {
 	} else {
 		query += ", is_admin = FALSE"
 	}
+	if m.IsApprover {
+		query += ", is_approver = TRUE"
+	} else {
+		query += ", is_approver = FALSE"
+	}
+	if m.IsDocumentViewer {
+		query += ", is_document_viewer = TRUE"
+	} else {
+		query += ", is_document_viewer = FALSE"
+	}
 	query += " WHERE username = ?"
 	args = append(args, m.Username)
 
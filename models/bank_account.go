@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/google/uuid"
+)
+
+type BankAccountRequest struct {
+	// BankCode follows the payout provider, e.g. bca, bni, mandiri
+	BankCode      string `json:"bank_code" binding:"required"`
+	AccountNumber string `json:"account_number" binding:"required"`
+	AccountHolder string `json:"account_holder" binding:"required"`
+}
+
+type BankAccountResponse struct {
+	Id            string `json:"id"`
+	BankCode      string `json:"bank_code"`
+	AccountNumber string `json:"account_number"`
+	AccountHolder string `json:"account_holder"`
+	IsPrimary     bool   `json:"is_primary"`
+	CreatedOn     string `json:"created_on"`
+}
+
+func (b *BankAccountRequest) validate() error {
+	var fieldErrors jsonutil.FieldErrors
+	if len(b.AccountNumber) < 5 || len(b.AccountNumber) > 20 {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "account_number",
+				Code:    "invalid_length",
+				Message: "account_number must be between 5 and 20 digits",
+			},
+		)
+	}
+	for _, c := range b.AccountNumber {
+		if c < '0' || c > '9' {
+			fieldErrors = append(
+				fieldErrors, jsonutil.FieldError{
+					Field:   "account_number",
+					Code:    "not_numeric",
+					Message: "account_number must only contain digits",
+				},
+			)
+			break
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+	return nil
+}
+
+// Create registers the bank account of uid, the first account becomes the primary one
+func (b *BankAccountRequest) Create(uid string) (BankAccountResponse, error) {
+	err := b.validate()
+	if err != nil {
+		return BankAccountResponse{}, err
+	}
+
+	id := uuid.New().String()
+	_, err = database.MysqlInstance.Exec(
+		`INSERT INTO bank_accounts (id, user_refer, bank_code, account_number, account_holder, is_primary) SELECT UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, NOT EXISTS (SELECT 1 FROM bank_accounts WHERE user_refer = UUID_TO_BIN(?))`,
+		id, uid, b.BankCode, b.AccountNumber, b.AccountHolder, uid,
+	)
+	if err != nil {
+		return BankAccountResponse{}, err
+	}
+
+	res := BankAccountResponse{Id: id}
+	err = database.MysqlInstance.QueryRow(
+		`SELECT bank_code, account_number, account_holder, is_primary, created_at FROM bank_accounts WHERE id = UUID_TO_BIN(?)`,
+		id,
+	).Scan(&res.BankCode, &res.AccountNumber, &res.AccountHolder, &res.IsPrimary, &res.CreatedOn)
+	if err != nil {
+		return BankAccountResponse{}, err
+	}
+	return res, nil
+}
+
+func GetBankAccounts(uid string) ([]BankAccountResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`SELECT BIN_TO_UUID(id), bank_code, account_number, account_holder, is_primary, created_at FROM bank_accounts WHERE user_refer = UUID_TO_BIN(?) ORDER BY is_primary DESC, created_at DESC`,
+		uid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []BankAccountResponse
+	for rows.Next() {
+		var temp BankAccountResponse
+		err := rows.Scan(
+			&temp.Id, &temp.BankCode, &temp.AccountNumber, &temp.AccountHolder, &temp.IsPrimary, &temp.CreatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// SetPrimaryBankAccount makes the account the one new lending of uid is disbursed to
+func SetPrimaryBankAccount(id string, uid string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow(
+		`SELECT 1 FROM bank_accounts WHERE id = UUID_TO_BIN(?) AND user_refer = UUID_TO_BIN(?)`, id, uid,
+	).Scan(&exists)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("bank account not found")
+		}
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE bank_accounts SET is_primary = (id = UUID_TO_BIN(?)) WHERE user_refer = UUID_TO_BIN(?)`, id, uid,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,368 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/google/uuid"
+)
+
+const (
+	CollateralVehicle   = "vehicle"
+	CollateralLand      = "land"
+	CollateralInventory = "inventory"
+)
+
+const (
+	CollateralPendingAppraisal = "pending_appraisal"
+	CollateralPledged          = "pledged"
+	CollateralReleased         = "released"
+)
+
+// maxCollateralDocuments bounds the supporting documents of a single item
+const maxCollateralDocuments = 5
+
+func IsValidCollateralType(collateralType string) bool {
+	return collateralType == CollateralVehicle || collateralType == CollateralLand ||
+		collateralType == CollateralInventory
+}
+
+type CollateralRequest struct {
+	Type        string `json:"type" binding:"required"`
+	Description string `json:"description" binding:"required"`
+	// Identifier is the BPKB number of a vehicle, the certificate number of land or the reference of the inventory
+	Identifier  string   `json:"identifier" binding:"required"`
+	DocumentIds []string `json:"document_ids" binding:"required"`
+}
+
+type CreateCollateralResponse struct {
+	Id string `json:"id"`
+}
+
+type CollateralAppraisalRequest struct {
+	AppraisalValue float64 `json:"appraisal_value" binding:"required"`
+}
+
+type CollateralResponse struct {
+	Id          string   `json:"id"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Identifier  string   `json:"identifier"`
+	DocumentIds []string `json:"document_ids"`
+	Status      string   `json:"status"`
+	// AppraisalValue is 0 until an admin appraised the item
+	AppraisalValue float64 `json:"appraisal_value"`
+	AppraisedBy    string  `json:"appraised_by,omitempty"`
+	AppraisedOn    string  `json:"appraised_on,omitempty"`
+	ReleasedOn     string  `json:"released_on,omitempty"`
+	CreatedOn      string  `json:"created_on"`
+}
+
+type LendingCollateralResponse struct {
+	LendingId string  `json:"lending_id"`
+	Amount    float64 `json:"amount"`
+	// AppraisedValue sums the pledged items, LoanToValue is the amount over it in percent and 0 without any
+	AppraisedValue float64              `json:"appraised_value"`
+	LoanToValue    float64              `json:"loan_to_value"`
+	Items          []CollateralResponse `json:"items"`
+}
+
+func (c *CollateralRequest) validate(uid string) error {
+	var fieldErrors jsonutil.FieldErrors
+	if !IsValidCollateralType(c.Type) {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "type",
+				Code:    "invalid_value",
+				Message: fmt.Sprintf("type must be one of %s, %s or %s", CollateralVehicle, CollateralLand, CollateralInventory),
+			},
+		)
+	}
+	if len(c.Description) > 255 {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field: "description", Code: "too_long", Message: "description must be at most 255 characters",
+			},
+		)
+	}
+	if len(c.Identifier) > 64 {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field: "identifier", Code: "too_long", Message: "identifier must be at most 64 characters",
+			},
+		)
+	}
+	if len(c.DocumentIds) > maxCollateralDocuments {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "document_ids",
+				Code:    "too_many",
+				Message: fmt.Sprintf("at most %d documents can support an item", maxCollateralDocuments),
+			},
+		)
+	}
+	for _, documentId := range c.DocumentIds {
+		_, err := ownedDocumentFileName(documentId, uid, DocumentTypeCollateral)
+		if err == nil {
+			continue
+		}
+		if !strings.Contains(err.Error(), "not found") && !strings.Contains(err.Error(), "mismatch") {
+			return err
+		}
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "document_ids",
+				Code:    "invalid_document",
+				Message: documentId + " must refer to a collateral document you uploaded",
+			},
+		)
+	}
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+	return nil
+}
+
+// Create registers the item on the proposal of uid while it is pending review, it only counts towards the loan to
+// value once appraised
+func (c *CollateralRequest) Create(id string, uid string) (CreateCollateralResponse, error) {
+	err := c.validate(uid)
+	if err != nil {
+		return CreateCollateralResponse{}, err
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return CreateCollateralResponse{}, err
+	}
+	defer tx.Rollback()
+
+	err = lockEditableLending(tx, id, uid, RevisionCollateral, "added "+c.Type+" "+c.Identifier)
+	if err != nil {
+		return CreateCollateralResponse{}, err
+	}
+	collateralId := uuid.New().String()
+	//	an item can only secure one lending until it is released, the unique active_identifier enforces it
+	_, err = tx.Exec(
+		`INSERT INTO collaterals (id, lending_refer, type, description, identifier, status) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, ?)`,
+		collateralId, id, c.Type, c.Description, c.Identifier, CollateralPendingAppraisal,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			return CreateCollateralResponse{}, jsonutil.FieldErrors{
+				{Field: "identifier", Code: "duplicate", Message: "the item already secures a lending"},
+			}
+		}
+		return CreateCollateralResponse{}, err
+	}
+	for _, documentId := range c.DocumentIds {
+		_, err = tx.Exec(
+			`INSERT IGNORE INTO collateral_documents (collateral_refer, document_refer) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?))`,
+			collateralId, documentId,
+		)
+		if err != nil {
+			return CreateCollateralResponse{}, err
+		}
+	}
+	return CreateCollateralResponse{Id: collateralId}, tx.Commit()
+}
+
+// DeleteCollateral removes the item from the proposal of uid while it is pending review
+func DeleteCollateral(id string, uid string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lendingId, collateralType, identifier string
+	err = tx.QueryRow(
+		`SELECT BIN_TO_UUID(lending_refer), type, identifier FROM collaterals WHERE id = UUID_TO_BIN(?)`, id,
+	).Scan(&lendingId, &collateralType, &identifier)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("collateral not found")
+		}
+		return err
+	}
+	err = lockEditableLending(tx, lendingId, uid, RevisionCollateral, "removed "+collateralType+" "+identifier)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM collaterals WHERE id = UUID_TO_BIN(?)`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Appraise records the appraisal value of the item, it can be appraised again until the lending is approved.
+// uid is the admin appraising it
+func (c *CollateralAppraisalRequest) Appraise(id string, uid string) error {
+	if c.AppraisalValue <= 0 {
+		return jsonutil.FieldErrors{
+			{Field: "appraisal_value", Code: "out_of_range", Message: "appraisal_value must be greater than 0"},
+		}
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var pending bool
+	err = tx.QueryRow(
+		`
+		SELECT c.status != ? AND l.is_approved = FALSE AND l.is_rejected = FALSE AND l.status != 'cancelled'
+		FROM collaterals c
+		INNER JOIN lending l ON l.id = c.lending_refer
+		WHERE c.id = UUID_TO_BIN(?)
+		FOR UPDATE
+	`, CollateralReleased, id,
+	).Scan(&pending)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("collateral not found")
+		}
+		return err
+	}
+	if !pending {
+		return fmt.Errorf("lending is no longer pending review")
+	}
+	_, err = tx.Exec(
+		`UPDATE collaterals SET appraisal_value = ?, appraised_by = UUID_TO_BIN(?), appraised_at = CURRENT_TIMESTAMP, status = ? WHERE id = UUID_TO_BIN(?)`,
+		c.AppraisalValue, uid, CollateralPledged, id,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// releaseCollateral releases every item of the lending, once it is repaid or will never be disbursed
+func releaseCollateral(tx *sql.Tx, id string) error {
+	_, err := tx.Exec(
+		`UPDATE collaterals SET status = ?, released_at = CURRENT_TIMESTAMP WHERE lending_refer = UUID_TO_BIN(?) AND status != ?`,
+		CollateralReleased, id, CollateralReleased,
+	)
+	return err
+}
+
+// getAppraisedValue sums the pledged items of the lending
+func getAppraisedValue(id string) (float64, error) {
+	var value float64
+	err := database.MysqlInstance.QueryRow(
+		`SELECT COALESCE(SUM(appraisal_value), 0) FROM collaterals WHERE lending_refer = UUID_TO_BIN(?) AND status = ?`,
+		id, CollateralPledged,
+	).Scan(&value)
+	return value, err
+}
+
+func loanToValue(amount float64, appraisedValue float64) float64 {
+	if appraisedValue <= 0 {
+		return 0
+	}
+	return math.Round(amount*10000/appraisedValue) / 100
+}
+
+// validateLoanToValue checks the stored proposal against the collateral rules, lending under SecuredFromAmount
+// is unsecured and only needs to stay under MaxLoanToValue when it has pledged collateral anyway
+func validateLoanToValue(id string, amount float64) (jsonutil.FieldErrors, error) {
+	rules := lendingRules
+	appraisedValue, err := getAppraisedValue(id)
+	if err != nil {
+		return nil, err
+	}
+	secured := rules.SecuredFromAmount > 0 && amount >= rules.SecuredFromAmount
+	if appraisedValue <= 0 {
+		if !secured {
+			return nil, nil
+		}
+		return jsonutil.FieldErrors{
+			{
+				Field:   "collateral",
+				Code:    "collateral_required",
+				Message: fmt.Sprintf("lending of %.0f or more requires appraised collateral", rules.SecuredFromAmount),
+			},
+		}, nil
+	}
+	if rules.MaxLoanToValue > 0 && amount/appraisedValue > rules.MaxLoanToValue {
+		return jsonutil.FieldErrors{
+			{
+				Field: "collateral",
+				Code:  "loan_to_value_exceeded",
+				Message: fmt.Sprintf(
+					"loan to value of %.2f%% exceeds %.0f%%", loanToValue(amount, appraisedValue),
+					rules.MaxLoanToValue*100,
+				),
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+// GetCollaterals returns the items of the lending with its loan to value, uid restricts it to the lending of the
+// borrower and is empty for admins
+func GetCollaterals(id string, uid string) (LendingCollateralResponse, error) {
+	res := LendingCollateralResponse{LendingId: id}
+	query := `SELECT amount FROM lending WHERE id = UUID_TO_BIN(?)`
+	args := []interface{}{id}
+	if uid != "" {
+		query += " AND user_refer = UUID_TO_BIN(?)"
+		args = append(args, uid)
+	}
+	err := database.MysqlInstance.QueryRow(query, args...).Scan(&res.Amount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LendingCollateralResponse{}, fmt.Errorf("lending not found")
+		}
+		return LendingCollateralResponse{}, err
+	}
+
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(c.id), c.type, c.description, c.identifier, c.status, COALESCE(c.appraisal_value, 0),
+		       COALESCE(u.username, ''), COALESCE(c.appraised_at, ''), COALESCE(c.released_at, ''), c.created_at,
+		       COALESCE((
+		           SELECT GROUP_CONCAT(BIN_TO_UUID(cd.document_refer)) FROM collateral_documents cd
+		           WHERE cd.collateral_refer = c.id
+		       ), '')
+		FROM collaterals c
+		LEFT JOIN users u ON u.id = c.appraised_by
+		WHERE c.lending_refer = UUID_TO_BIN(?)
+		ORDER BY c.created_at
+	`, id,
+	)
+	if err != nil {
+		return LendingCollateralResponse{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var temp CollateralResponse
+		var documentIds string
+		err := rows.Scan(
+			&temp.Id, &temp.Type, &temp.Description, &temp.Identifier, &temp.Status, &temp.AppraisalValue,
+			&temp.AppraisedBy, &temp.AppraisedOn, &temp.ReleasedOn, &temp.CreatedOn, &documentIds,
+		)
+		if err != nil {
+			return LendingCollateralResponse{}, err
+		}
+		temp.DocumentIds = []string{}
+		if documentIds != "" {
+			temp.DocumentIds = strings.Split(documentIds, ",")
+		}
+		if temp.Status == CollateralPledged {
+			res.AppraisedValue += temp.AppraisalValue
+		}
+		res.Items = append(res.Items, temp)
+	}
+	res.LoanToValue = loanToValue(res.Amount, res.AppraisedValue)
+	return res, nil
+}
@@ -0,0 +1,346 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/scoring"
+	"github.com/google/uuid"
+)
+
+const (
+	// scoringWorkers bounds the concurrent calls to the scoring engine during batch scoring
+	scoringWorkers = 4
+	scoringTimeout = 10 * time.Second
+)
+
+type LendingPredictResponse struct {
+	Id           string   `json:"id"`
+	Predictions  []string `json:"predictions"`
+	ModelVersion string   `json:"model_version,omitempty"`
+	// Engine is either ml or scorecard
+	Engine         string           `json:"engine"`
+	Score          *float64         `json:"score,omitempty"`
+	Threshold      *float64         `json:"threshold,omitempty"`
+	Reasons        []scoring.Reason `json:"reasons"`
+	FallbackReason string           `json:"fallback_reason,omitempty"`
+	CreatedOn      string           `json:"created_on"`
+}
+
+// CreditScoreSummary is the latest score shown next to a lending proposal
+type CreditScoreSummary struct {
+	Id           string   `json:"id"`
+	Prediction   string   `json:"prediction"`
+	ModelVersion string   `json:"model_version,omitempty"`
+	Engine       string   `json:"engine"`
+	Score        *float64 `json:"score,omitempty"`
+	CreatedOn    string   `json:"created_on"`
+}
+
+const (
+	BatchScoreRunning   = "running"
+	BatchScoreCompleted = "completed"
+)
+
+// batchScoreStaleAfter is how long a running batch blocks a new one, a batch interrupted by a restart stays running
+const batchScoreStaleAfter = time.Hour
+
+type BatchScoreFailure struct {
+	Id    string `json:"id"`
+	Error string `json:"error"`
+}
+
+type BatchScoreResponse struct {
+	Id        string              `json:"id"`
+	Status    string              `json:"status"`
+	Total     int                 `json:"total"`
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+	Failures  []BatchScoreFailure `json:"failures"`
+	CreatedOn string              `json:"created_on"`
+	// CompletedOn is empty while the batch is running
+	CompletedOn string `json:"completed_on,omitempty"`
+}
+
+type CreditScoreHistoryResponse struct {
+	Id             string           `json:"id"`
+	Features       scoring.Features `json:"features"`
+	RawOutput      json.RawMessage  `json:"raw_output"`
+	Prediction     string           `json:"prediction"`
+	ModelVersion   string           `json:"model_version,omitempty"`
+	Engine         string           `json:"engine"`
+	Score          *float64         `json:"score,omitempty"`
+	Threshold      *float64         `json:"threshold,omitempty"`
+	Reasons        []scoring.Reason `json:"reasons"`
+	FallbackReason string           `json:"fallback_reason,omitempty"`
+	CreatedBy      string           `json:"created_by,omitempty"`
+	CreatedOn      string           `json:"created_on"`
+}
+
+func getLendingFeatures(id string) (scoring.Features, error) {
+	var features scoring.Features
+	err := database.MysqlInstance.QueryRow(
+		`
+	SELECT l.age, COALESCE(l.gender, 0), FLOOR(l.income), COALESCE(l.last_education, 0), COALESCE(l.marital_status, 0), l.number_of_children, COALESCE(l.home_ownership, 0) FROM lending l
+	WHERE l.id = UUID_TO_BIN(?)
+	`, id,
+	).Scan(
+		&features.Age, &features.Gender, &features.Income, &features.Education, &features.MaritalStatus,
+		&features.NumberOfChildren, &features.HomeOwnership,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return scoring.Features{}, fmt.Errorf("order id not found")
+		}
+		return scoring.Features{}, err
+	}
+	features.Guarantors, err = getGuarantorFeatures(id)
+	if err != nil {
+		return scoring.Features{}, err
+	}
+	return features, nil
+}
+
+// PredictCreditScore scores the lending with the configured engine and stores the result, uid is the admin
+// requesting it
+func PredictCreditScore(id string, uid string) (LendingPredictResponse, error) {
+	features, err := getLendingFeatures(id)
+	if err != nil {
+		return LendingPredictResponse{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scoringTimeout)
+	defer cancel()
+	result, err := scoring.Score(ctx, features)
+	if err != nil {
+		return LendingPredictResponse{}, err
+	}
+	return storeCreditScore(id, uid, features, result)
+}
+
+// StartBatchScoring queues every proposal waiting for its offer or for review and scores them in the background using
+// a bounded worker pool, the progress is read back with GetBatchScoring
+func StartBatchScoring(uid string) (BatchScoreResponse, error) {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return BatchScoreResponse{}, err
+	}
+	defer tx.Rollback()
+
+	//	the lock on the running batches serializes two admins starting a batch at the same time
+	var running int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM scoring_batches WHERE status = ? AND created_at > ? FOR UPDATE`,
+		BatchScoreRunning, time.Now().Add(-batchScoreStaleAfter),
+	).Scan(&running)
+	if err != nil {
+		return BatchScoreResponse{}, err
+	}
+	if running > 0 {
+		return BatchScoreResponse{}, fmt.Errorf("a batch scoring is already running")
+	}
+
+	rows, err := tx.Query(
+		`SELECT BIN_TO_UUID(id) FROM lending WHERE status IN ('pending_offer', 'pending') AND is_approved = FALSE AND is_rejected = FALSE ORDER BY created_at`,
+	)
+	if err != nil {
+		return BatchScoreResponse{}, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return BatchScoreResponse{}, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	res := BatchScoreResponse{
+		Id:        uuid.New().String(),
+		Status:    BatchScoreRunning,
+		Total:     len(ids),
+		Failures:  []BatchScoreFailure{},
+		CreatedOn: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	_, err = tx.Exec(
+		`INSERT INTO scoring_batches (id, status, total, created_by) VALUES (UUID_TO_BIN(?), ?, ?, UUID_TO_BIN(?))`,
+		res.Id, res.Status, res.Total, uid,
+	)
+	if err != nil {
+		return BatchScoreResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return BatchScoreResponse{}, err
+	}
+
+	go runBatchScoring(res.Id, ids, uid)
+	return res, nil
+}
+
+func runBatchScoring(batchId string, ids []string, uid string) {
+	jobs := make(chan string)
+	failures := make(chan BatchScoreFailure, len(ids))
+	var wg sync.WaitGroup
+	for i := 0; i < scoringWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				_, err := PredictCreditScore(id, uid)
+				if err != nil {
+					failures <- BatchScoreFailure{Id: id, Error: err.Error()}
+				}
+			}
+		}()
+	}
+	for _, id := range ids {
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+	close(failures)
+
+	list := []BatchScoreFailure{}
+	for failure := range failures {
+		list = append(list, failure)
+	}
+	failuresJson, err := json.Marshal(list)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	_, err = database.MysqlInstance.Exec(
+		`UPDATE scoring_batches SET status = ?, succeeded = ?, failed = ?, failures = ?, completed_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)`,
+		BatchScoreCompleted, len(ids)-len(list), len(list), string(failuresJson), batchId,
+	)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func GetBatchScoring(id string) (BatchScoreResponse, error) {
+	var res BatchScoreResponse
+	var failuresJson sql.NullString
+	var completedOn sql.NullString
+	err := database.MysqlInstance.QueryRow(
+		`SELECT BIN_TO_UUID(id), status, total, succeeded, failed, failures, created_at, completed_at FROM scoring_batches WHERE id = UUID_TO_BIN(?)`,
+		id,
+	).Scan(
+		&res.Id, &res.Status, &res.Total, &res.Succeeded, &res.Failed, &failuresJson, &res.CreatedOn, &completedOn,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return BatchScoreResponse{}, fmt.Errorf("batch not found")
+		}
+		return BatchScoreResponse{}, err
+	}
+	res.CompletedOn = completedOn.String
+	res.Failures = []BatchScoreFailure{}
+	if failuresJson.Valid {
+		err = json.Unmarshal([]byte(failuresJson.String), &res.Failures)
+		if err != nil {
+			return BatchScoreResponse{}, err
+		}
+	}
+	return res, nil
+}
+
+func storeCreditScore(id string, uid string, features scoring.Features, result scoring.Result) (
+	LendingPredictResponse, error,
+) {
+	featuresJson, err := json.Marshal(features)
+	if err != nil {
+		return LendingPredictResponse{}, err
+	}
+	var prediction string
+	if len(result.Predictions) > 0 {
+		prediction = result.Predictions[0]
+	}
+	if result.Reasons == nil {
+		result.Reasons = []scoring.Reason{}
+	}
+	reasonsJson, err := json.Marshal(result.Reasons)
+	if err != nil {
+		return LendingPredictResponse{}, err
+	}
+
+	res := LendingPredictResponse{
+		Id:             uuid.New().String(),
+		Predictions:    result.Predictions,
+		ModelVersion:   result.ModelVersion,
+		Engine:         result.Engine,
+		Score:          result.Score,
+		Threshold:      result.Threshold,
+		Reasons:        result.Reasons,
+		FallbackReason: result.FallbackReason,
+	}
+	_, err = database.MysqlInstance.Exec(
+		`INSERT INTO credit_scores (id, lending_refer, features, raw_output, prediction, model_version, engine, score, threshold, reasons, fallback_reason, created_by) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, NULLIF(?, ''), IF(? = '', NULL, UUID_TO_BIN(?)))`,
+		res.Id, id, string(featuresJson), string(result.RawOutput), prediction, res.ModelVersion, res.Engine, res.Score,
+		res.Threshold, string(reasonsJson), res.FallbackReason, uid, uid,
+	)
+	if err != nil {
+		return LendingPredictResponse{}, err
+	}
+	err = database.MysqlInstance.QueryRow(
+		`SELECT created_at FROM credit_scores WHERE id = UUID_TO_BIN(?)`, res.Id,
+	).Scan(&res.CreatedOn)
+	if err != nil {
+		return LendingPredictResponse{}, err
+	}
+	return res, nil
+}
+
+// GetCreditScoreHistory returns every score of the lending, newest first
+func GetCreditScoreHistory(id string) ([]CreditScoreHistoryResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(cs.id), cs.features, cs.raw_output, COALESCE(cs.prediction, ''), COALESCE(cs.model_version, ''),
+		       cs.engine, cs.score, cs.threshold, COALESCE(cs.reasons, '[]'), COALESCE(cs.fallback_reason, ''),
+		       COALESCE(u.username, ''), cs.created_at
+		FROM credit_scores cs
+		LEFT JOIN users u ON u.id = cs.created_by
+		WHERE cs.lending_refer = UUID_TO_BIN(?)
+		ORDER BY cs.created_at DESC
+	`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []CreditScoreHistoryResponse
+	for rows.Next() {
+		var temp CreditScoreHistoryResponse
+		var features, rawOutput, reasons []byte
+		err := rows.Scan(
+			&temp.Id, &features, &rawOutput, &temp.Prediction, &temp.ModelVersion, &temp.Engine, &temp.Score,
+			&temp.Threshold, &reasons, &temp.FallbackReason, &temp.CreatedBy, &temp.CreatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(reasons, &temp.Reasons)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(features, &temp.Features)
+		if err != nil {
+			return nil, err
+		}
+		temp.RawOutput = rawOutput
+		res = append(res, temp)
+	}
+	return res, nil
+}
@@ -0,0 +1,399 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/google/uuid"
+)
+
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+	DecisionManual  = "manual"
+)
+
+// DecisionThresholds auto-approves a score above ApproveAbove and auto-rejects a score below RejectBelow, anything in
+// between goes to manual review
+type DecisionThresholds struct {
+	ApproveAbove float64 `json:"approve_above"`
+	RejectBelow  float64 `json:"reject_below"`
+}
+
+type DecisionPolicy struct {
+	Version string `json:"version" binding:"required"`
+	Enabled bool   `json:"enabled"`
+	// Thresholds is keyed by scoring engine as ml returns a probability while the scorecard returns points
+	Thresholds map[string]DecisionThresholds `json:"thresholds" binding:"required"`
+}
+
+// decisionPolicy is disabled until DECISION_POLICY_FILE is configured, every proposal then needs a manual decision
+var decisionPolicy = DecisionPolicy{
+	Version:    "manual",
+	Thresholds: map[string]DecisionThresholds{},
+}
+
+func InitializeDecisionPolicy() error {
+	path := os.Getenv("DECISION_POLICY_FILE")
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var policy DecisionPolicy
+	err = json.NewDecoder(file).Decode(&policy)
+	if err != nil {
+		return err
+	}
+	err = policy.validate()
+	if err != nil {
+		return err
+	}
+	decisionPolicy = policy
+	return nil
+}
+
+func GetDecisionPolicy() DecisionPolicy {
+	return decisionPolicy
+}
+
+func (p *DecisionPolicy) validate() error {
+	if p.Version == "" {
+		return fmt.Errorf("invalid decision policy, version is required")
+	}
+	for engine, thresholds := range p.Thresholds {
+		if thresholds.RejectBelow > thresholds.ApproveAbove {
+			return fmt.Errorf("invalid decision policy, reject_below is above approve_above for %s", engine)
+		}
+	}
+	return nil
+}
+
+type LendingDecisionResponse struct {
+	// Id is empty for simulated decisions
+	Id            string   `json:"id,omitempty"`
+	LendingId     string   `json:"lending_id"`
+	PolicyVersion string   `json:"policy_version"`
+	CreditScoreId string   `json:"credit_score_id,omitempty"`
+	Outcome       string   `json:"outcome"`
+	Reasons       []string `json:"reasons"`
+	Applied       bool     `json:"applied"`
+	DecidedBy     string   `json:"decided_by,omitempty"`
+	CreatedOn     string   `json:"created_on,omitempty"`
+}
+
+type SimulatedDecision struct {
+	LendingDecisionResponse
+	// ActualStatus is the status the proposal ended up with
+	ActualStatus string `json:"actual_status"`
+}
+
+type DecisionSimulationResponse struct {
+	PolicyVersion string              `json:"policy_version"`
+	Total         int                 `json:"total"`
+	Approve       int                 `json:"approve"`
+	Reject        int                 `json:"reject"`
+	Manual        int                 `json:"manual"`
+	Results       []SimulatedDecision `json:"results"`
+}
+
+// latestCreditScore is the part of the latest score the policy needs
+type latestCreditScore struct {
+	id         string
+	engine     string
+	prediction string
+	score      *float64
+}
+
+func getLatestCreditScore(id string) (*latestCreditScore, error) {
+	var latest latestCreditScore
+	err := database.MysqlInstance.QueryRow(
+		`SELECT BIN_TO_UUID(id), engine, COALESCE(prediction, ''), score FROM credit_scores WHERE lending_refer = UUID_TO_BIN(?) ORDER BY created_at DESC LIMIT 1`,
+		id,
+	).Scan(&latest.id, &latest.engine, &latest.prediction, &latest.score)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &latest, nil
+}
+
+// getStoredLendingRequest loads the proposal back into a LendingRequest so the eligibility rules can run again
+func getStoredLendingRequest(id string) (LendingRequest, string, error) {
+	l := LendingRequest{Id: id}
+	var status string
+	err := database.MysqlInstance.QueryRow(
+		`SELECT BIN_TO_UUID(user_refer), amount, interest_rate, interest_method, tenor, age, income, status FROM lending WHERE id = UUID_TO_BIN(?)`,
+		id,
+	).Scan(&l.RequesterUid, &l.Amount, &l.InterestRate, &l.InterestMethod, &l.Tenor, &l.Age, &l.Income, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LendingRequest{}, "", fmt.Errorf("lending not found")
+		}
+		return LendingRequest{}, "", err
+	}
+	return l, status, nil
+}
+
+// evaluate decides the outcome of a proposal, only approvals also require every eligibility rule to pass
+func (p *DecisionPolicy) evaluate(l *LendingRequest, score *latestCreditScore) (string, []string, error) {
+	if score == nil || score.score == nil {
+		return DecisionManual, []string{"no numeric credit score"}, nil
+	}
+	thresholds, ok := p.Thresholds[score.engine]
+	if !ok {
+		return DecisionManual, []string{"no threshold for engine " + score.engine}, nil
+	}
+	if *score.score < thresholds.RejectBelow {
+		return DecisionReject, []string{
+			fmt.Sprintf("score %.4g is below %.4g", *score.score, thresholds.RejectBelow),
+		}, nil
+	}
+	if *score.score <= thresholds.ApproveAbove {
+		return DecisionManual, []string{
+			fmt.Sprintf(
+				"score %.4g is between %.4g and %.4g", *score.score, thresholds.RejectBelow, thresholds.ApproveAbove,
+			),
+		}, nil
+	}
+
+	err := l.Validate()
+	if err != nil {
+		var fieldErrors jsonutil.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			return DecisionManual, fieldErrors.Messages(), nil
+		}
+		return "", nil, err
+	}
+	fieldErrors, err := validateLoanToValue(l.Id, l.Amount)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(fieldErrors) > 0 {
+		return DecisionManual, fieldErrors.Messages(), nil
+	}
+	//	the maker-checker rule cannot be bypassed by the policy
+	if dualApprovalThreshold > 0 && l.Amount > dualApprovalThreshold {
+		return DecisionManual, []string{"amount requires dual approval"}, nil
+	}
+	return DecisionApprove, []string{
+		fmt.Sprintf("score %.4g is above %.4g and every eligibility rule passed", *score.score, thresholds.ApproveAbove),
+	}, nil
+}
+
+// DecideLending runs the decision policy on a pending proposal, scoring it first when it has never been scored,
+// and records the decision. uid is empty when triggered by the system
+func DecideLending(id string, uid string) (LendingDecisionResponse, error) {
+	policy := decisionPolicy
+	l, status, err := getStoredLendingRequest(id)
+	if err != nil {
+		return LendingDecisionResponse{}, err
+	}
+	if status != "pending" {
+		return LendingDecisionResponse{}, fmt.Errorf("lending is not pending")
+	}
+
+	score, err := getLatestCreditScore(id)
+	if err != nil {
+		return LendingDecisionResponse{}, err
+	}
+	if score == nil {
+		predicted, err := PredictCreditScore(id, uid)
+		if err != nil {
+			return LendingDecisionResponse{}, err
+		}
+		score = &latestCreditScore{id: predicted.Id, engine: predicted.Engine, score: predicted.Score}
+	}
+
+	outcome, reasons, err := policy.evaluate(&l, score)
+	if err != nil {
+		return LendingDecisionResponse{}, err
+	}
+	res := LendingDecisionResponse{
+		Id:            uuid.New().String(),
+		LendingId:     id,
+		PolicyVersion: policy.Version,
+		CreditScoreId: score.id,
+		Outcome:       outcome,
+		Reasons:       reasons,
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return LendingDecisionResponse{}, err
+	}
+	defer tx.Rollback()
+
+	if policy.Enabled && outcome != DecisionManual {
+		var result sql.Result
+		if outcome == DecisionApprove {
+			result, err = tx.Exec(
+				`UPDATE lending SET status = 'approved', is_approved = TRUE WHERE id = UUID_TO_BIN(?) AND status = 'pending' AND is_approved = FALSE AND is_rejected = FALSE`,
+				id,
+			)
+		} else {
+			result, err = tx.Exec(
+				`UPDATE lending SET status = 'rejected', is_rejected = TRUE WHERE id = UUID_TO_BIN(?) AND status = 'pending' AND is_approved = FALSE AND is_rejected = FALSE`,
+				id,
+			)
+		}
+		if err != nil {
+			return LendingDecisionResponse{}, err
+		}
+		affected, _ := result.RowsAffected()
+		res.Applied = affected > 0
+		if res.Applied && outcome == DecisionApprove {
+			//	the approval trail records the admin who triggered the decision, or no one when the system did
+			_, err = tx.Exec(
+				`INSERT INTO lending_approvals (lending_refer, approver_refer, credit_score_refer) VALUES (UUID_TO_BIN(?), IF(? = '', NULL, UUID_TO_BIN(?)), UUID_TO_BIN(?))`,
+				id, uid, uid, score.id,
+			)
+			if err != nil {
+				return LendingDecisionResponse{}, err
+			}
+		}
+		if res.Applied && outcome == DecisionReject {
+			err = releaseCollateral(tx, id)
+			if err != nil {
+				return LendingDecisionResponse{}, err
+			}
+		}
+	}
+
+	reasonsJson, err := json.Marshal(reasons)
+	if err != nil {
+		return LendingDecisionResponse{}, err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO lending_decisions (id, lending_refer, policy_version, credit_score_refer, outcome, reasons, applied, decided_by) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, UUID_TO_BIN(?), ?, ?, ?, IF(? = '', NULL, UUID_TO_BIN(?)))`,
+		res.Id, id, res.PolicyVersion, res.CreditScoreId, res.Outcome, string(reasonsJson), res.Applied, uid, uid,
+	)
+	if err != nil {
+		return LendingDecisionResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return LendingDecisionResponse{}, err
+	}
+	return res, nil
+}
+
+// GetLendingDecisions returns the audit trail of automated decisions of a proposal, newest first
+func GetLendingDecisions(id string) ([]LendingDecisionResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(d.id), BIN_TO_UUID(d.lending_refer), d.policy_version, COALESCE(BIN_TO_UUID(d.credit_score_refer), ''),
+		       d.outcome, d.reasons, d.applied, COALESCE(u.username, ''), d.created_at
+		FROM lending_decisions d
+		LEFT JOIN users u ON u.id = d.decided_by
+		WHERE d.lending_refer = UUID_TO_BIN(?)
+		ORDER BY d.created_at DESC
+	`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []LendingDecisionResponse
+	for rows.Next() {
+		var temp LendingDecisionResponse
+		var reasons []byte
+		err := rows.Scan(
+			&temp.Id, &temp.LendingId, &temp.PolicyVersion, &temp.CreditScoreId, &temp.Outcome, &reasons,
+			&temp.Applied, &temp.DecidedBy, &temp.CreatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(reasons, &temp.Reasons)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// SimulateDecisionPolicy evaluates the policy against the proposals created between from and to (inclusive dates,
+// YYYY-MM-DD) using their latest score without applying anything
+func SimulateDecisionPolicy(policy DecisionPolicy, from string, to string) (DecisionSimulationResponse, error) {
+	err := policy.validate()
+	if err != nil {
+		return DecisionSimulationResponse{}, err
+	}
+
+	rows, err := database.MysqlInstance.Query(
+		`SELECT BIN_TO_UUID(id) FROM lending WHERE created_at >= ? AND created_at < DATE_ADD(?, INTERVAL 1 DAY) ORDER BY created_at`,
+		from, to,
+	)
+	if err != nil {
+		return DecisionSimulationResponse{}, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return DecisionSimulationResponse{}, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	res := DecisionSimulationResponse{
+		PolicyVersion: policy.Version,
+		Results:       []SimulatedDecision{},
+	}
+	for _, id := range ids {
+		l, status, err := getStoredLendingRequest(id)
+		if err != nil {
+			return DecisionSimulationResponse{}, err
+		}
+		score, err := getLatestCreditScore(id)
+		if err != nil {
+			return DecisionSimulationResponse{}, err
+		}
+		outcome, reasons, err := policy.evaluate(&l, score)
+		if err != nil {
+			return DecisionSimulationResponse{}, err
+		}
+
+		simulated := SimulatedDecision{
+			LendingDecisionResponse: LendingDecisionResponse{
+				LendingId:     id,
+				PolicyVersion: policy.Version,
+				Outcome:       outcome,
+				Reasons:       reasons,
+			},
+			ActualStatus: status,
+		}
+		if score != nil {
+			simulated.CreditScoreId = score.id
+		}
+		res.Results = append(res.Results, simulated)
+
+		switch outcome {
+		case DecisionApprove:
+			res.Approve++
+		case DecisionReject:
+			res.Reject++
+		default:
+			res.Manual++
+		}
+	}
+	res.Total = len(res.Results)
+	return res, nil
+}
@@ -0,0 +1,319 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/loancalc"
+	"github.com/Tus1688/kim-hackathon-2023-api/payout"
+	"github.com/google/uuid"
+)
+
+const payoutTimeout = 30 * time.Second
+
+const (
+	DisbursementPending    = "pending"
+	DisbursementProcessing = "processing"
+	DisbursementCompleted  = "completed"
+	DisbursementFailed     = "failed"
+)
+
+type DisbursementRequest struct {
+	// BankAccountId defaults to the primary bank account of the borrower
+	BankAccountId string `json:"bank_account_id"`
+}
+
+type DisbursementResponse struct {
+	Id            string `json:"id"`
+	LendingId     string `json:"lending_id"`
+	BankCode      string `json:"bank_code"`
+	AccountNumber string `json:"account_number"`
+	AccountHolder string `json:"account_holder"`
+	// Amount is what the borrower receives, the lending amount minus the origination Fee
+	Amount        int64  `json:"amount"`
+	Fee           int64  `json:"fee"`
+	Status        string `json:"status"`
+	ReferenceNo   string `json:"reference_no,omitempty"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	CreatedBy     string `json:"created_by"`
+	CreatedOn     string `json:"created_on"`
+	CompletedOn   string `json:"completed_on,omitempty"`
+}
+
+// DisburseLending sends an approved lending to the bank account of the borrower, the repayment schedule only starts
+// once the payout provider confirms the transfer. uid is the admin requesting it
+func (d *DisbursementRequest) DisburseLending(id string, uid string) (DisbursementResponse, error) {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return DisbursementResponse{}, err
+	}
+	defer tx.Rollback()
+
+	var borrowerUid, status string
+	var amount float64
+	var isApproved, isDisbursed, isFunded bool
+	err = tx.QueryRow(
+		`SELECT BIN_TO_UUID(user_refer), amount, status, is_approved, disbursed_at IS NOT NULL, funded_at IS NOT NULL FROM lending WHERE id = UUID_TO_BIN(?) FOR UPDATE`,
+		id,
+	).Scan(&borrowerUid, &amount, &status, &isApproved, &isDisbursed, &isFunded)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DisbursementResponse{}, fmt.Errorf("lending not found")
+		}
+		return DisbursementResponse{}, err
+	}
+	if !isApproved || isDisbursed || status != "approved" {
+		return DisbursementResponse{}, fmt.Errorf("lending is not awaiting disbursement")
+	}
+	terms, err := getAcceptedAgreementTerms(tx, id)
+	if err != nil {
+		return DisbursementResponse{}, err
+	}
+	if terms.amount != loancalc.Rupiah(amount) {
+		return DisbursementResponse{}, fmt.Errorf("agreement does not match the lending amount")
+	}
+	if lendingRules.RequireFunding {
+		if !isFunded {
+			return DisbursementResponse{}, fmt.Errorf("lending has not been fully funded by lenders")
+		}
+		//	the disbursement is paid out of the money of the lenders
+		var uncollected bool
+		err = tx.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM lending_commitments WHERE lending_refer = UUID_TO_BIN(?) AND collected_at IS NULL)`,
+			id,
+		).Scan(&uncollected)
+		if err != nil {
+			return DisbursementResponse{}, err
+		}
+		if uncollected {
+			return DisbursementResponse{}, fmt.Errorf("lending has not been collected from every lender")
+		}
+	}
+
+	query := `SELECT BIN_TO_UUID(id), bank_code, account_number, account_holder FROM bank_accounts WHERE user_refer = UUID_TO_BIN(?) AND is_primary = TRUE`
+	args := []interface{}{borrowerUid}
+	if d.BankAccountId != "" {
+		query = `SELECT BIN_TO_UUID(id), bank_code, account_number, account_holder FROM bank_accounts WHERE user_refer = UUID_TO_BIN(?) AND id = UUID_TO_BIN(?)`
+		args = append(args, d.BankAccountId)
+	}
+	var bankAccountId string
+	res := DisbursementResponse{Id: uuid.New().String(), LendingId: id, Status: DisbursementPending}
+	err = tx.QueryRow(query, args...).Scan(&bankAccountId, &res.BankCode, &res.AccountNumber, &res.AccountHolder)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DisbursementResponse{}, fmt.Errorf("bank account not found")
+		}
+		return DisbursementResponse{}, err
+	}
+
+	//	the fee is the one of the accepted agreement, the rules may have changed since
+	res.Fee = terms.fee
+	res.Amount = terms.amount - res.Fee
+	_, err = tx.Exec(
+		`INSERT INTO disbursements (id, lending_refer, bank_account_refer, amount, fee, status, created_by) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, UUID_TO_BIN(?))`,
+		res.Id, id, bankAccountId, res.Amount, res.Fee, res.Status, uid,
+	)
+	if err != nil {
+		return DisbursementResponse{}, err
+	}
+	//	disbursing keeps a second disbursement from being started while the payout is in flight
+	_, err = tx.Exec(`UPDATE lending SET status = 'disbursing' WHERE id = UUID_TO_BIN(?)`, id)
+	if err != nil {
+		return DisbursementResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return DisbursementResponse{}, err
+	}
+
+	err = sendPayout(res)
+	if err != nil {
+		return DisbursementResponse{}, err
+	}
+	return GetDisbursement(res.Id)
+}
+
+// sendPayout creates the payout of the disbursement, its id is the idempotency key so it can be sent again when the
+// outcome of a previous attempt is unknown. Only a payout the provider rejected fails the disbursement, any other error
+// keeps it processing to be reconciled with RefreshDisbursement
+func sendPayout(res DisbursementResponse) error {
+	ctx, cancel := context.WithTimeout(context.Background(), payoutTimeout)
+	defer cancel()
+	result, err := payout.Create(
+		ctx, payout.Payout{
+			Id:                 res.Id,
+			BeneficiaryName:    res.AccountHolder,
+			BeneficiaryAccount: res.AccountNumber,
+			BeneficiaryBank:    res.BankCode,
+			Amount:             res.Amount,
+			Notes:              "lending " + res.LendingId,
+		},
+	)
+	if err != nil {
+		if errors.Is(err, payout.ErrRejected) {
+			result = payout.Result{Status: payout.StatusFailed, FailureReason: err.Error()}
+		} else {
+			log.Printf("payout of disbursement %s is unknown: %v", res.Id, err)
+			result.Status = payout.StatusProcessing
+		}
+	}
+	return applyPayoutResult(res.Id, result)
+}
+
+// applyPayoutResult moves the disbursement to the state reported by the provider, a completed payout marks the lending
+// as disbursed and starts its repayment schedule while a failed one lets the admin disburse it again. Disbursements
+// that are already completed or failed are left untouched
+func applyPayoutResult(id string, result payout.Result) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lendingId, status string
+	err = tx.QueryRow(
+		`SELECT BIN_TO_UUID(lending_refer), status FROM disbursements WHERE id = UUID_TO_BIN(?) FOR UPDATE`, id,
+	).Scan(&lendingId, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("disbursement not found")
+		}
+		return err
+	}
+	if status == DisbursementCompleted || status == DisbursementFailed {
+		return nil
+	}
+
+	switch result.Status {
+	case payout.StatusCompleted:
+		_, err = tx.Exec(
+			`UPDATE disbursements SET status = ?, reference_no = NULLIF(?, ''), completed_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)`,
+			DisbursementCompleted, result.ReferenceNo, id,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`UPDATE lending SET status = 'disbursed', disbursed_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)`,
+			lendingId,
+		)
+		if err != nil {
+			return err
+		}
+		err = createInstallments(tx, lendingId, time.Now())
+		if err != nil {
+			return err
+		}
+		err = postDisbursement(tx, id)
+	case payout.StatusFailed:
+		_, err = tx.Exec(
+			`UPDATE disbursements SET status = ?, reference_no = NULLIF(?, ''), failure_reason = ? WHERE id = UUID_TO_BIN(?)`,
+			DisbursementFailed, result.ReferenceNo, result.FailureReason, id,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`UPDATE lending SET status = 'approved' WHERE id = UUID_TO_BIN(?) AND status = 'disbursing'`, lendingId,
+		)
+	default:
+		_, err = tx.Exec(
+			`UPDATE disbursements SET status = ?, reference_no = NULLIF(?, '') WHERE id = UUID_TO_BIN(?)`,
+			DisbursementProcessing, result.ReferenceNo, id,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RefreshDisbursement asks the payout provider for the latest state of a disbursement that is still processing, or
+// sends it again when the provider never returned a reference
+func RefreshDisbursement(id string) (DisbursementResponse, error) {
+	res, err := GetDisbursement(id)
+	if err != nil {
+		return DisbursementResponse{}, err
+	}
+	if res.Status == DisbursementCompleted || res.Status == DisbursementFailed {
+		return res, nil
+	}
+	//	the payout may never have reached the provider, sending it again with the same idempotency key either creates
+	//	it or returns the one already created
+	if res.ReferenceNo == "" {
+		err = sendPayout(res)
+		if err != nil {
+			return DisbursementResponse{}, err
+		}
+		return GetDisbursement(id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), payoutTimeout)
+	defer cancel()
+	result, err := payout.Get(ctx, res.ReferenceNo)
+	if err != nil {
+		return DisbursementResponse{}, err
+	}
+	err = applyPayoutResult(id, result)
+	if err != nil {
+		return DisbursementResponse{}, err
+	}
+	return GetDisbursement(id)
+}
+
+const disbursementQuery = `
+	SELECT BIN_TO_UUID(d.id), BIN_TO_UUID(d.lending_refer), b.bank_code, b.account_number, b.account_holder, d.amount,
+	       d.fee, d.status, COALESCE(d.reference_no, ''), COALESCE(d.failure_reason, ''), u.username, d.created_at,
+	       COALESCE(d.completed_at, '')
+	FROM disbursements d
+	INNER JOIN bank_accounts b ON b.id = d.bank_account_refer
+	INNER JOIN users u ON u.id = d.created_by
+`
+
+func scanDisbursement(row interface{ Scan(...any) error }) (DisbursementResponse, error) {
+	var res DisbursementResponse
+	err := row.Scan(
+		&res.Id, &res.LendingId, &res.BankCode, &res.AccountNumber, &res.AccountHolder, &res.Amount, &res.Fee,
+		&res.Status, &res.ReferenceNo, &res.FailureReason, &res.CreatedBy, &res.CreatedOn, &res.CompletedOn,
+	)
+	return res, err
+}
+
+func GetDisbursement(id string) (DisbursementResponse, error) {
+	res, err := scanDisbursement(
+		database.MysqlInstance.QueryRow(disbursementQuery+`WHERE d.id = UUID_TO_BIN(?)`, id),
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DisbursementResponse{}, fmt.Errorf("disbursement not found")
+		}
+		return DisbursementResponse{}, err
+	}
+	return res, nil
+}
+
+// GetLendingDisbursements returns every disbursement attempt of the lending, newest first
+func GetLendingDisbursements(id string) ([]DisbursementResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		disbursementQuery+`WHERE d.lending_refer = UUID_TO_BIN(?) ORDER BY d.created_at DESC`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []DisbursementResponse
+	for rows.Next() {
+		temp, err := scanDisbursement(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
@@ -0,0 +1,322 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/authutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/uploadutil"
+	"github.com/google/uuid"
+)
+
+const (
+	DocumentTypeKK  = "kk"
+	DocumentTypeKTP = "ktp"
+	// DocumentTypeCollateral supports a collateral item, e.g. the BPKB of a vehicle or a land certificate
+	DocumentTypeCollateral = "collateral"
+)
+
+// documentUrlTTL is how long a signed document url stays valid
+const documentUrlTTL = 5 * time.Minute
+
+type DocumentResponse struct {
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	Filename string `json:"filename"`
+}
+
+// DocumentStream is the document content proxied from go-blob, Body has to be closed by the caller
+type DocumentStream struct {
+	Body          io.ReadCloser
+	ContentType   string
+	ContentLength int64
+}
+
+type DocumentUrlResponse struct {
+	Url       string `json:"url"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// DocumentRequester identifies who is accessing a document, Uid is empty when accessed through a signed url
+type DocumentRequester struct {
+	Uid       string
+	Roles     []string
+	IpAddress string
+	UserAgent string
+}
+
+type DocumentListResponse struct {
+	Id          string `json:"id"`
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentHash string `json:"content_hash"`
+	CreatedOn   string `json:"created_on"`
+}
+
+func IsValidDocumentType(docType string) bool {
+	return docType == DocumentTypeKK || docType == DocumentTypeKTP || docType == DocumentTypeCollateral
+}
+
+// UploadDocument stores the file in go-blob and records it as a document owned by uid
+func UploadDocument(uid string, docType string, file uploadutil.File) (DocumentResponse, error) {
+	if !IsValidDocumentType(docType) {
+		return DocumentResponse{}, fmt.Errorf("invalid document type")
+	}
+
+	blob, err := uploadToGoBlob(file)
+	if err != nil {
+		return DocumentResponse{}, err
+	}
+
+	hash := sha256.Sum256(file.Content)
+	id := uuid.New().String()
+	_, err = database.MysqlInstance.Exec(
+		`INSERT INTO documents (id, user_refer, type, file_name, size, content_hash) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, ?)`,
+		id, uid, docType, blob.Filename, len(file.Content), hex.EncodeToString(hash[:]),
+	)
+	if err != nil {
+		return DocumentResponse{}, err
+	}
+	return DocumentResponse{
+		Id:       id,
+		Type:     docType,
+		Filename: blob.Filename,
+	}, nil
+}
+
+func GetDocumentsAsUser(uid string) ([]DocumentListResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`SELECT BIN_TO_UUID(id), type, file_name, size, content_hash, created_at FROM documents WHERE user_refer = UUID_TO_BIN(?) ORDER BY created_at DESC`,
+		uid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []DocumentListResponse
+	for rows.Next() {
+		var temp DocumentListResponse
+		err := rows.Scan(&temp.Id, &temp.Type, &temp.Filename, &temp.Size, &temp.ContentHash, &temp.CreatedOn)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// ownedDocumentFileName returns the blob file name of the document when it is owned by uid and of the expected type
+func ownedDocumentFileName(id string, uid string, docType string) (string, error) {
+	var fileName, actualType string
+	err := database.MysqlInstance.QueryRow(
+		`SELECT file_name, type FROM documents WHERE id = UUID_TO_BIN(?) AND user_refer = UUID_TO_BIN(?)`, id, uid,
+	).Scan(&fileName, &actualType)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "uuid_to_bin") {
+			return "", fmt.Errorf("document not found")
+		}
+		return "", err
+	}
+	if actualType != docType {
+		return "", fmt.Errorf("document type mismatch")
+	}
+	return fileName, nil
+}
+
+// resolveDocuments makes sure the kk and ktp documents belong to the requester and returns their blob file names
+func (l *LendingRequest) resolveDocuments() (string, string, error) {
+	var fieldErrors jsonutil.FieldErrors
+	slots := []struct {
+		field   string
+		id      string
+		docType string
+	}{
+		{"kk_document_id", l.KkDocumentId, DocumentTypeKK},
+		{"ktp_document_id", l.KtpDocumentId, DocumentTypeKTP},
+	}
+	fileNames := make([]string, len(slots))
+	for i, slot := range slots {
+		fileName, err := ownedDocumentFileName(slot.id, l.RequesterUid, slot.docType)
+		if err != nil {
+			switch {
+			case strings.Contains(err.Error(), "not found"):
+				fieldErrors = append(
+					fieldErrors, jsonutil.FieldError{
+						Field:   slot.field,
+						Code:    "not_found",
+						Message: slot.field + " does not refer to a document you uploaded",
+					},
+				)
+			case strings.Contains(err.Error(), "mismatch"):
+				fieldErrors = append(
+					fieldErrors, jsonutil.FieldError{
+						Field:   slot.field,
+						Code:    "type_mismatch",
+						Message: slot.field + " must refer to a " + slot.docType + " document",
+					},
+				)
+			default:
+				return "", "", err
+			}
+			continue
+		}
+		fileNames[i] = fileName
+	}
+	if len(fieldErrors) > 0 {
+		return "", "", fieldErrors
+	}
+	return fileNames[0], fileNames[1], nil
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// truncatedUserAgent fits the user agent of the requester in the VARCHAR(255) columns that audit it
+func (d DocumentRequester) truncatedUserAgent() string {
+	if len(d.UserAgent) > 255 {
+		return d.UserAgent[:255]
+	}
+	return d.UserAgent
+}
+
+func logDocumentAccess(id string, requester DocumentRequester, accessType string) error {
+	userAgent := requester.truncatedUserAgent()
+	_, err := database.MysqlInstance.Exec(
+		`INSERT INTO document_access_logs (document_refer, user_refer, access_type, ip_address, user_agent) VALUES (UUID_TO_BIN(?), IF(? = '', NULL, UUID_TO_BIN(?)), ?, ?, ?)`,
+		id, requester.Uid, requester.Uid, accessType, requester.IpAddress, userAgent,
+	)
+	return err
+}
+
+// authorizeDocument returns the blob file name and how the requester is allowed to access it, which is either as the
+// owner or as staff holding the document_viewer permission
+func authorizeDocument(id string, requester DocumentRequester) (string, string, error) {
+	var fileName, owner string
+	err := database.MysqlInstance.QueryRow(
+		`SELECT file_name, BIN_TO_UUID(user_refer) FROM documents WHERE id = UUID_TO_BIN(?)`, id,
+	).Scan(&fileName, &owner)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "uuid_to_bin") {
+			return "", "", fmt.Errorf("document not found")
+		}
+		return "", "", err
+	}
+	if owner == requester.Uid {
+		return fileName, "owner", nil
+	}
+	if hasRole(requester.Roles, "document_viewer") {
+		return fileName, "staff", nil
+	}
+	return "", "", fmt.Errorf("cannot access document")
+}
+
+// goBlobHttpClient bounds a document download, including streaming its body, so a stalled storage does not hang it
+var goBlobHttpClient = &http.Client{Timeout: 60 * time.Second}
+
+func fetchDocument(fileName string) (DocumentStream, error) {
+	req, err := http.NewRequest("GET", goBlobBaseUrl+"/"+fileName, nil)
+	if err != nil {
+		return DocumentStream{}, err
+	}
+	req.Header.Set("Authorization", goBlobAuthorization)
+
+	resp, err := goBlobHttpClient.Do(req)
+	if err != nil {
+		return DocumentStream{}, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return DocumentStream{}, fmt.Errorf("document not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return DocumentStream{}, fmt.Errorf("image server error")
+	}
+	return DocumentStream{
+		Body:          resp.Body,
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+	}, nil
+}
+
+// StreamDocument proxies the document to its owner or to staff with the document_viewer permission
+func StreamDocument(id string, requester DocumentRequester) (DocumentStream, error) {
+	fileName, accessType, err := authorizeDocument(id, requester)
+	if err != nil {
+		return DocumentStream{}, err
+	}
+	err = logDocumentAccess(id, requester, accessType)
+	if err != nil {
+		return DocumentStream{}, err
+	}
+	return fetchDocument(fileName)
+}
+
+// CreateDocumentUrl mints a signed url that can be embedded without the access cookie until it expires
+func CreateDocumentUrl(id string, requester DocumentRequester) (DocumentUrlResponse, error) {
+	_, _, err := authorizeDocument(id, requester)
+	if err != nil {
+		return DocumentUrlResponse{}, err
+	}
+	err = logDocumentAccess(id, requester, "sign")
+	if err != nil {
+		return DocumentUrlResponse{}, err
+	}
+
+	expiresAt := time.Now().Add(documentUrlTTL)
+	query := url.Values{}
+	query.Set("id", id)
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", authutil.SignDocument(id, expiresAt.Unix()))
+	return DocumentUrlResponse{
+		Url:       "/api/v1/document/signed?" + query.Encode(),
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// StreamSignedDocument proxies the document when the signature minted by CreateDocumentUrl is valid and not expired
+func StreamSignedDocument(id string, expires int64, signature string, requester DocumentRequester) (
+	DocumentStream, error,
+) {
+	if !authutil.VerifyDocumentSignature(id, expires, signature) {
+		return DocumentStream{}, fmt.Errorf("invalid signature")
+	}
+	if time.Now().Unix() > expires {
+		return DocumentStream{}, fmt.Errorf("signature expired")
+	}
+
+	var fileName string
+	err := database.MysqlInstance.QueryRow(
+		`SELECT file_name FROM documents WHERE id = UUID_TO_BIN(?)`, id,
+	).Scan(&fileName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DocumentStream{}, fmt.Errorf("document not found")
+		}
+		return DocumentStream{}, err
+	}
+	err = logDocumentAccess(id, requester, "signed")
+	if err != nil {
+		return DocumentStream{}, err
+	}
+	return fetchDocument(fileName)
+}
@@ -0,0 +1,344 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/loancalc"
+)
+
+// LoanProduct is the configuration of a kind of lending, a proposal names its product by code
+type LoanProduct struct {
+	// InterestMethod is stored on every new lending of the product, it is one of the loancalc methods besides
+	// MethodFlatTotal which only prices the lending made before the rates became yearly
+	InterestMethod string `json:"interest_method"`
+}
+
+// LendingRules is the eligibility configuration every lending proposal is validated against
+type LendingRules struct {
+	MinAmount float64 `json:"min_amount"`
+	MaxAmount float64 `json:"max_amount"`
+	// MinInterestRate and MaxInterestRate bound the yearly rates a pricing grid may offer
+	MinInterestRate int `json:"min_interest_rate"`
+	MaxInterestRate int `json:"max_interest_rate"`
+	// AllowedTenors is in months
+	AllowedTenors []int `json:"allowed_tenors"`
+	MinAge        int   `json:"min_age"`
+	// MaxDebtToIncome is the maximum ratio of the total monthly installment (including the other open lending) to the
+	// monthly income, 0.3 means 30% of the income
+	MaxDebtToIncome float64 `json:"max_debt_to_income"`
+	// MaxOpenLoans is the maximum lending a borrower may have that are neither rejected nor paid
+	MaxOpenLoans int `json:"max_open_loans"`
+	// OriginationFeePercent of the amount is deducted from the disbursement
+	OriginationFeePercent float64 `json:"origination_fee_percent"`
+	// Products is keyed by the code a proposal names, DefaultProduct is used when it names none
+	Products       map[string]LoanProduct `json:"products"`
+	DefaultProduct string                 `json:"default_product"`
+	// MinPaymentAmount is the smallest partial repayment in rupiah, unless the payoff amount is smaller
+	MinPaymentAmount int64 `json:"min_payment_amount"`
+	// LateFee in rupiah is charged once on every overdue installment
+	LateFee int64 `json:"late_fee"`
+	// SecuredFromAmount is the amount from which a lending requires appraised collateral, 0 never requires it
+	SecuredFromAmount float64 `json:"secured_from_amount"`
+	// MaxLoanToValue is the maximum ratio of the amount to the appraised value of the collateral, 0.8 means the
+	// amount is at most 80% of it. 0 disables the limit
+	MaxLoanToValue float64 `json:"max_loan_to_value"`
+	// RequireFunding holds the disbursement until the commitments of the lenders reach the amount and are collected
+	RequireFunding bool `json:"require_funding"`
+	// MinCommitmentAmount is the smallest commitment of a lender in rupiah, unless the remaining amount is smaller
+	MinCommitmentAmount int64 `json:"min_commitment_amount"`
+	// LenderCommissionPercent of the interest distributed to the lenders is kept by the platform
+	LenderCommissionPercent float64 `json:"lender_commission_percent"`
+	// OfferValidDays is how long the borrower has to accept an offer before it is priced again
+	OfferValidDays int `json:"offer_valid_days"`
+}
+
+var lendingRules = LendingRules{
+	MinAmount:       1_000_000,
+	MaxAmount:       50_000_000,
+	MinInterestRate: 1,
+	MaxInterestRate: 30,
+	AllowedTenors:   []int{3, 6, 12, 24},
+	MinAge:          21,
+	MaxDebtToIncome: 0.3,
+	MaxOpenLoans:    1,
+	Products: map[string]LoanProduct{
+		"personal": {InterestMethod: loancalc.MethodFlat},
+	},
+	DefaultProduct:      "personal",
+	MinPaymentAmount:    100_000,
+	MinCommitmentAmount: 100_000,
+	OfferValidDays:      7,
+}
+
+// InitializeLendingRules overrides the default rules with the json file pointed by LENDING_RULES_FILE,
+// fields that are missing from the file keep their default value
+func InitializeLendingRules() error {
+	path := os.Getenv("LENDING_RULES_FILE")
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rules := lendingRules
+	//	the products of the file replace the default ones instead of being merged into them
+	rules.Products = nil
+	err = json.NewDecoder(file).Decode(&rules)
+	if err != nil {
+		return err
+	}
+	if rules.Products == nil {
+		rules.Products = lendingRules.Products
+	}
+	for _, product := range rules.Products {
+		if !loancalc.IsValidMethod(product.InterestMethod) || product.InterestMethod == loancalc.MethodFlatTotal {
+			return fmt.Errorf("invalid lending rules, unknown interest method %s", product.InterestMethod)
+		}
+	}
+	if _, ok := rules.Products[rules.DefaultProduct]; !ok {
+		return fmt.Errorf("invalid lending rules, default product %s is not one of the products", rules.DefaultProduct)
+	}
+	if rules.MinAmount <= 0 || rules.MaxAmount <= 0 || rules.MinAmount > rules.MaxAmount ||
+		rules.MinInterestRate <= 0 || rules.MinInterestRate > rules.MaxInterestRate || len(rules.AllowedTenors) == 0 ||
+		rules.MaxOpenLoans <= 0 || rules.MaxDebtToIncome <= 0 || rules.OfferValidDays <= 0 ||
+		rules.OriginationFeePercent < 0 || rules.OriginationFeePercent >= 100 || rules.MinPaymentAmount <= 0 ||
+		rules.LateFee < 0 || rules.SecuredFromAmount < 0 || rules.MaxLoanToValue < 0 ||
+		rules.MinCommitmentAmount <= 0 || rules.LenderCommissionPercent < 0 || rules.LenderCommissionPercent > 100 {
+		return fmt.Errorf("invalid lending rules")
+	}
+	lendingRules = rules
+	return nil
+}
+
+func GetLendingRules() LendingRules {
+	return lendingRules
+}
+
+// productCode is the product named by the proposal, or the default product
+func (l *LendingRequest) productCode() string {
+	if l.Product == "" {
+		return lendingRules.DefaultProduct
+	}
+	return l.Product
+}
+
+// interestMethod is the method stored on the lending, or the one of its product before it is stored. It is false when
+// the product does not exist
+func (l *LendingRequest) interestMethod() (string, bool) {
+	if l.InterestMethod != "" {
+		return l.InterestMethod, true
+	}
+	product, ok := lendingRules.Products[l.productCode()]
+	return product.InterestMethod, ok
+}
+
+// productNotFound lists the products a proposal may name
+func productNotFound() jsonutil.FieldError {
+	codes := make([]string, 0, len(lendingRules.Products))
+	for code := range lendingRules.Products {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return jsonutil.FieldError{
+		Field:   "product",
+		Code:    "not_found",
+		Message: fmt.Sprintf("product must be one of %v", codes),
+	}
+}
+
+// originationFee is rounded down to the rupiah
+func originationFee(amount int64) int64 {
+	return int64(float64(amount) * lendingRules.OriginationFeePercent / 100)
+}
+
+// monthlyInstallment is the largest installment of the loan schedule, lending that has not been priced yet assumes the
+// highest rate a pricing grid may offer. It is 0 for an invalid amount or tenor, which are rejected by Validate
+func monthlyInstallment(method string, amount float64, interestRate int, tenor int) float64 {
+	if interestRate == 0 {
+		interestRate = lendingRules.MaxInterestRate
+	}
+	schedule, err := loancalc.NewSchedule(method, loancalc.Rupiah(amount), interestRate, tenor, time.Now())
+	if err != nil {
+		return 0
+	}
+	return float64(schedule.MaxInstallment())
+}
+
+// validateAmountAndTenor is shared by Validate and the simulator
+func validateAmountAndTenor(amount float64, tenor int) jsonutil.FieldErrors {
+	rules := lendingRules
+	var fieldErrors jsonutil.FieldErrors
+	if amount < rules.MinAmount || amount > rules.MaxAmount {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "amount",
+				Code:    "out_of_range",
+				Message: fmt.Sprintf("amount must be between %.0f and %.0f", rules.MinAmount, rules.MaxAmount),
+			},
+		)
+	}
+	tenorAllowed := false
+	for _, allowed := range rules.AllowedTenors {
+		if tenor == allowed {
+			tenorAllowed = true
+			break
+		}
+	}
+	if !tenorAllowed {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "tenor",
+				Code:    "not_allowed",
+				Message: fmt.Sprintf("tenor must be one of %v", rules.AllowedTenors),
+			},
+		)
+	}
+	return fieldErrors
+}
+
+// queryer is satisfied by both the database and a transaction
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// lockBorrower serializes the proposals of the borrower, the open lending counted by validateIn cannot change until
+// the transaction ends so concurrent submissions cannot both pass the open loans limit
+func lockBorrower(tx *sql.Tx, uid string) error {
+	var exists int
+	err := tx.QueryRow(`SELECT 1 FROM users WHERE id = UUID_TO_BIN(?) FOR UPDATE`, uid).Scan(&exists)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user not found")
+		}
+		return err
+	}
+	return nil
+}
+
+// Validate checks the proposal against lendingRules and returns jsonutil.FieldErrors listing every violation
+func (l *LendingRequest) Validate() error {
+	return l.validateIn(database.MysqlInstance)
+}
+
+// validateIn is Validate reading the other lending of the borrower through q, a transaction holding lockBorrower
+func (l *LendingRequest) validateIn(q queryer) error {
+	rules := lendingRules
+	fieldErrors := validateAmountAndTenor(l.Amount, l.Tenor)
+
+	if l.Age < rules.MinAge {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "age",
+				Code:    "too_young",
+				Message: fmt.Sprintf("age must be at least %d", rules.MinAge),
+			},
+		)
+	}
+	method, productFound := l.interestMethod()
+	if !productFound {
+		fieldErrors = append(fieldErrors, productNotFound())
+	}
+	if l.Income <= 0 {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "income",
+				Code:    "out_of_range",
+				Message: "income must be greater than 0",
+			},
+		)
+	}
+	//	the attributes are nil when the proposal is loaded back from the database
+	enums := []struct {
+		field string
+		valid bool
+	}{
+		{"gender", l.Gender == nil || l.Gender.Valid()},
+		{"last_education", l.LastEducation == nil || l.LastEducation.Valid()},
+		{"marital_status", l.MaritalStatus == nil || l.MaritalStatus.Valid()},
+		{"has_house", l.HasHouse == nil || l.HasHouse.Valid()},
+	}
+	for _, enum := range enums {
+		if !enum.valid {
+			fieldErrors = append(
+				fieldErrors, jsonutil.FieldError{
+					Field:   enum.field,
+					Code:    "invalid_value",
+					Message: enum.field + " must be one of the codes listed by the lookup endpoint",
+				},
+			)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+
+	//	the rules below depends on the other lending of the borrower
+	query := `SELECT amount, interest_rate, tenor, interest_method FROM lending WHERE user_refer = UUID_TO_BIN(?) AND is_rejected = FALSE AND is_paid = FALSE AND status != 'cancelled'`
+	args := []interface{}{l.RequesterUid}
+	//	a stored proposal must not count against itself
+	if l.Id != "" {
+		query += " AND id != UUID_TO_BIN(?)"
+		args = append(args, l.Id)
+	}
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	openLoans := 0
+	installment := monthlyInstallment(method, l.Amount, l.InterestRate, l.Tenor)
+	for rows.Next() {
+		var amount float64
+		var interestRate, tenor int
+		var interestMethod string
+		err := rows.Scan(&amount, &interestRate, &tenor, &interestMethod)
+		if err != nil {
+			return err
+		}
+		openLoans++
+		if tenor > 0 {
+			installment += monthlyInstallment(interestMethod, amount, interestRate, tenor)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	if openLoans >= rules.MaxOpenLoans {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "amount",
+				Code:    "too_many_open_loans",
+				Message: fmt.Sprintf("borrower cannot have more than %d open lending", rules.MaxOpenLoans),
+			},
+		)
+	}
+	if installment/l.Income > rules.MaxDebtToIncome {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field: "income",
+				Code:  "debt_to_income_exceeded",
+				Message: fmt.Sprintf(
+					"monthly installment of %.0f exceeds %.0f%% of income", installment, rules.MaxDebtToIncome*100,
+				),
+			},
+		)
+	}
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+	return nil
+}
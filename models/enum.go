@@ -0,0 +1,204 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	LanguageIndonesian = "id"
+	LanguageEnglish    = "en"
+)
+
+// DefaultLanguage is used when the client accepts neither language, the labels used to be Indonesian only
+const DefaultLanguage = LanguageIndonesian
+
+type Gender int
+
+const (
+	GenderMale Gender = iota
+	GenderFemale
+)
+
+type Education int
+
+const (
+	EducationSMA Education = iota
+	EducationD3
+	EducationS1
+	EducationS2
+	EducationS3
+)
+
+type MaritalStatus int
+
+const (
+	MaritalStatusSingle MaritalStatus = iota
+	MaritalStatusMarried
+)
+
+type HomeOwnership int
+
+const (
+	HomeOwnershipRenting HomeOwnership = iota
+	HomeOwnershipOwning
+)
+
+// enumLabels holds the label of every code of an enum per language, the index is the code
+type enumLabels []map[string]string
+
+var (
+	genderLabels = enumLabels{
+		{LanguageIndonesian: "Laki-Laki", LanguageEnglish: "Male"},
+		{LanguageIndonesian: "Perempuan", LanguageEnglish: "Female"},
+	}
+	educationLabels = enumLabels{
+		{LanguageIndonesian: "SMA", LanguageEnglish: "High school"},
+		{LanguageIndonesian: "D3", LanguageEnglish: "Diploma"},
+		{LanguageIndonesian: "S1", LanguageEnglish: "Bachelor"},
+		{LanguageIndonesian: "S2", LanguageEnglish: "Master"},
+		{LanguageIndonesian: "S3", LanguageEnglish: "Doctorate"},
+	}
+	maritalStatusLabels = enumLabels{
+		{LanguageIndonesian: "Lajang", LanguageEnglish: "Single"},
+		{LanguageIndonesian: "Menikah", LanguageEnglish: "Married"},
+	}
+	homeOwnershipLabels = enumLabels{
+		{LanguageIndonesian: "Menyewa", LanguageEnglish: "Renting"},
+		{LanguageIndonesian: "Memiliki", LanguageEnglish: "Owning"},
+	}
+)
+
+func (e enumLabels) valid(code int) bool {
+	return code >= 0 && code < len(e)
+}
+
+// label falls back to the code itself for a code stored before it was validated
+func (e enumLabels) label(code int, language string) string {
+	if !e.valid(code) {
+		return fmt.Sprint(code)
+	}
+	if label, ok := e[code][language]; ok {
+		return label
+	}
+	return e[code][DefaultLanguage]
+}
+
+func (g Gender) Valid() bool {
+	return genderLabels.valid(int(g))
+}
+
+func (g Gender) Label(language string) string {
+	return genderLabels.label(int(g), language)
+}
+
+func (e Education) Valid() bool {
+	return educationLabels.valid(int(e))
+}
+
+func (e Education) Label(language string) string {
+	return educationLabels.label(int(e), language)
+}
+
+func (m MaritalStatus) Valid() bool {
+	return maritalStatusLabels.valid(int(m))
+}
+
+func (m MaritalStatus) Label(language string) string {
+	return maritalStatusLabels.label(int(m), language)
+}
+
+func (h HomeOwnership) Valid() bool {
+	return homeOwnershipLabels.valid(int(h))
+}
+
+func (h HomeOwnership) Label(language string) string {
+	return homeOwnershipLabels.label(int(h), language)
+}
+
+// unmarshalBinaryEnum also accepts true and false for the enums that used to be booleans in the request
+func unmarshalBinaryEnum(data []byte) (int, error) {
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		return 1, nil
+	case "false":
+		return 0, nil
+	}
+	var code int
+	err := json.Unmarshal(data, &code)
+	return code, err
+}
+
+func (g *Gender) UnmarshalJSON(data []byte) error {
+	code, err := unmarshalBinaryEnum(data)
+	*g = Gender(code)
+	return err
+}
+
+func (m *MaritalStatus) UnmarshalJSON(data []byte) error {
+	code, err := unmarshalBinaryEnum(data)
+	*m = MaritalStatus(code)
+	return err
+}
+
+func (h *HomeOwnership) UnmarshalJSON(data []byte) error {
+	code, err := unmarshalBinaryEnum(data)
+	*h = HomeOwnership(code)
+	return err
+}
+
+type EnumValue struct {
+	Code  int    `json:"code"`
+	Label string `json:"label"`
+}
+
+type LendingEnumsResponse struct {
+	Language      string      `json:"language"`
+	Gender        []EnumValue `json:"gender"`
+	LastEducation []EnumValue `json:"last_education"`
+	MaritalStatus []EnumValue `json:"marital_status"`
+	HasHouse      []EnumValue `json:"has_house"`
+}
+
+func (e enumLabels) values(language string) []EnumValue {
+	res := make([]EnumValue, len(e))
+	for code := range e {
+		res[code] = EnumValue{Code: code, Label: e.label(code, language)}
+	}
+	return res
+}
+
+// GetLendingEnums lists the allowed codes of the lending attributes with their label in language
+func GetLendingEnums(language string) LendingEnumsResponse {
+	return LendingEnumsResponse{
+		Language:      language,
+		Gender:        genderLabels.values(language),
+		LastEducation: educationLabels.values(language),
+		MaritalStatus: maritalStatusLabels.values(language),
+		HasHouse:      homeOwnershipLabels.values(language),
+	}
+}
+
+// ParseLanguage picks the supported language with the highest weight from an Accept-Language header
+func ParseLanguage(acceptLanguage string) string {
+	best, bestWeight := DefaultLanguage, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary != LanguageIndonesian && primary != LanguageEnglish {
+			continue
+		}
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if _, err := fmt.Sscanf(q, "%g", &weight); err != nil {
+				continue
+			}
+		}
+		if weight > bestWeight {
+			best, bestWeight = primary, weight
+		}
+	}
+	return best
+}
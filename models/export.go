@@ -0,0 +1,321 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/exportutil"
+	"github.com/google/uuid"
+)
+
+const (
+	ReportLoans         = "loans"
+	ReportBorrowers     = "borrowers"
+	ReportRepayments    = "repayments"
+	ReportDelinquencies = "delinquencies"
+)
+
+const (
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+// maxExportDays bounds the period of a single export
+const maxExportDays = 366
+
+type reportColumn struct {
+	name    string
+	numeric bool
+}
+
+// reportLayout is the fixed column layout of a report, its query selects the columns in the same order
+type reportLayout struct {
+	columns []reportColumn
+	query   string
+	// args maps the period, from start to the exclusive end, to the arguments of the query
+	args func(start time.Time, end time.Time) []interface{}
+}
+
+func periodArgs(start time.Time, end time.Time) []interface{} {
+	return []interface{}{start, end}
+}
+
+var reportLayouts = map[string]reportLayout{
+	ReportLoans: {
+		columns: []reportColumn{
+			{"loan_id", false}, {"borrower_id", false}, {"username", false}, {"created_at", false},
+			{"disbursed_at", false}, {"status", false}, {"amount", true}, {"interest_rate", true},
+			{"interest_method", false}, {"tenor_months", true}, {"origination_fee", true},
+			{"outstanding_principal", true},
+		},
+		query: `
+		SELECT BIN_TO_UUID(l.id), BIN_TO_UUID(l.user_refer), u.username,
+		       DATE_FORMAT(l.created_at, '%Y-%m-%d %H:%i:%s'), DATE_FORMAT(l.disbursed_at, '%Y-%m-%d %H:%i:%s'),
+		       l.status, ROUND(l.amount), l.interest_rate, l.interest_method, l.tenor, COALESCE(d.fee, 0),
+		       ROUND(l.amount) - COALESCE((
+		           SELECT SUM(b.allocated_principal) FROM bill b WHERE b.lending_refer = l.id AND b.paid_at < ?
+		       ), 0)
+		FROM lending l
+		INNER JOIN users u ON u.id = l.user_refer
+		LEFT JOIN disbursements d ON d.lending_refer = l.id AND d.status = 'completed'
+		WHERE l.disbursed_at >= ? AND l.disbursed_at < ?
+		ORDER BY l.disbursed_at, l.id
+	`,
+		args: func(start time.Time, end time.Time) []interface{} {
+			return []interface{}{end, start, end}
+		},
+	},
+	//	every borrower with a lending disbursed in the period, age and income are taken from the latest one
+	ReportBorrowers: {
+		columns: []reportColumn{
+			{"borrower_id", false}, {"username", false}, {"registered_at", false}, {"age", true},
+			{"income", true}, {"loans_disbursed", true}, {"amount_disbursed", true},
+		},
+		query: `
+		SELECT BIN_TO_UUID(u.id), u.username, DATE_FORMAT(u.created_at, '%Y-%m-%d %H:%i:%s'),
+		       (SELECT l2.age FROM lending l2 WHERE l2.user_refer = u.id AND l2.disbursed_at < ? ORDER BY l2.disbursed_at DESC LIMIT 1),
+		       (SELECT ROUND(l2.income) FROM lending l2 WHERE l2.user_refer = u.id AND l2.disbursed_at < ? ORDER BY l2.disbursed_at DESC LIMIT 1),
+		       COUNT(*), SUM(ROUND(l.amount))
+		FROM lending l
+		INNER JOIN users u ON u.id = l.user_refer
+		WHERE l.disbursed_at >= ? AND l.disbursed_at < ?
+		GROUP BY u.id
+		ORDER BY u.username
+	`,
+		args: func(start time.Time, end time.Time) []interface{} {
+			return []interface{}{end, end, start, end}
+		},
+	},
+	ReportRepayments: {
+		columns: []reportColumn{
+			{"payment_id", false}, {"loan_id", false}, {"borrower_id", false}, {"paid_at", false},
+			{"amount", true}, {"allocated_fee", true}, {"allocated_interest", true}, {"allocated_principal", true},
+			{"unallocated", true}, {"status", false},
+		},
+		query: `
+		SELECT BIN_TO_UUID(b.id), BIN_TO_UUID(b.lending_refer), BIN_TO_UUID(b.user_refer),
+		       DATE_FORMAT(b.paid_at, '%Y-%m-%d %H:%i:%s'), ROUND(b.amount), b.allocated_fee, b.allocated_interest,
+		       b.allocated_principal, b.unallocated, b.status
+		FROM bill b
+		WHERE b.lending_refer IS NOT NULL AND b.is_paid = TRUE AND b.paid_at >= ? AND b.paid_at < ?
+		ORDER BY b.paid_at, b.id
+	`,
+		args: periodArgs,
+	},
+	//	every installment past due at the end of the period, the amounts due are the current ones
+	ReportDelinquencies: {
+		columns: []reportColumn{
+			{"loan_id", false}, {"borrower_id", false}, {"username", false}, {"installment_number", true},
+			{"due_date", false}, {"days_past_due", true}, {"principal_due", true}, {"interest_due", true},
+			{"fee_due", true},
+		},
+		query: `
+		SELECT BIN_TO_UUID(i.lending_refer), BIN_TO_UUID(l.user_refer), u.username, i.number,
+		       DATE_FORMAT(i.due_date, '%Y-%m-%d'), DATEDIFF(?, i.due_date),
+		       i.principal - i.paid_principal, i.interest - i.paid_interest, i.fee - i.paid_fee
+		FROM lending_installments i
+		INNER JOIN lending l ON l.id = i.lending_refer
+		INNER JOIN users u ON u.id = l.user_refer
+		WHERE i.due_date < ? AND (i.is_paid = FALSE OR i.paid_at >= ?)
+		ORDER BY i.due_date, i.lending_refer, i.number
+	`,
+		args: func(start time.Time, end time.Time) []interface{} {
+			asOf := end.AddDate(0, 0, -1).Format("2006-01-02")
+			return []interface{}{asOf, asOf, end}
+		},
+	},
+}
+
+func IsValidReport(report string) bool {
+	_, ok := reportLayouts[report]
+	return ok
+}
+
+type ExportLogResponse struct {
+	Id          string `json:"id"`
+	Report      string `json:"report"`
+	Format      string `json:"format"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Status      string `json:"status"`
+	RowCount    int    `json:"row_count"`
+	Error       string `json:"error,omitempty"`
+	CreatedBy   string `json:"created_by"`
+	CreatedOn   string `json:"created_on"`
+	CompletedOn string `json:"completed_on,omitempty"`
+}
+
+// ReportExport is one export recorded in the export log, it is written by Stream
+type ReportExport struct {
+	Id     string
+	Report string
+	Format string
+	// From and To are inclusive dates
+	From time.Time
+	To   time.Time
+}
+
+// NewReportExport validates the export and records it as running in the export log, the format defaults to csv.
+// uid is the admin requesting it
+func NewReportExport(report string, format string, from time.Time, to time.Time, uid string) (*ReportExport, error) {
+	if format == "" {
+		format = exportutil.FormatCSV
+	}
+	if !IsValidReport(report) {
+		return nil, fmt.Errorf("invalid report")
+	}
+	if !exportutil.IsValidFormat(format) {
+		return nil, fmt.Errorf("invalid format")
+	}
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return nil, fmt.Errorf("invalid period")
+	}
+	if to.Sub(from) >= maxExportDays*24*time.Hour {
+		return nil, fmt.Errorf("invalid period, at most %d days", maxExportDays)
+	}
+
+	e := &ReportExport{Id: uuid.New().String(), Report: report, Format: format, From: from, To: to}
+	_, err := database.MysqlInstance.Exec(
+		`INSERT INTO export_logs (id, report, format, period_from, period_to, status, created_by) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?, ?, UUID_TO_BIN(?))`,
+		e.Id, report, format, from.Format("2006-01-02"), to.Format("2006-01-02"), ExportRunning, uid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *ReportExport) FileName() string {
+	return fmt.Sprintf("%s_%s_%s.%s", e.Report, e.From.Format("2006-01-02"), e.To.Format("2006-01-02"), e.Format)
+}
+
+func (e *ReportExport) ContentType() string {
+	return exportutil.ContentType(e.Format)
+}
+
+// Stream writes the header and then every row of the report as soon as it is read from the database, the export log
+// records the outcome and the number of rows written
+func (e *ReportExport) Stream(w io.Writer) error {
+	rowCount, err := e.stream(w)
+	status, message := ExportCompleted, ""
+	if err != nil {
+		status, message = ExportFailed, err.Error()
+		if len(message) > 255 {
+			message = message[:255]
+		}
+	}
+	_, logErr := database.MysqlInstance.Exec(
+		`UPDATE export_logs SET status = ?, row_count = ?, error = NULLIF(?, ''), completed_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)`,
+		status, rowCount, message, e.Id,
+	)
+	if err != nil {
+		return err
+	}
+	return logErr
+}
+
+func (e *ReportExport) stream(w io.Writer) (int, error) {
+	layout := reportLayouts[e.Report]
+	writer, err := exportutil.New(e.Format, w, e.Report)
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]any, len(layout.columns))
+	for i, column := range layout.columns {
+		header[i] = column.name
+	}
+	err = writer.Write(header)
+	if err != nil {
+		return 0, err
+	}
+
+	start := e.From
+	end := e.To.AddDate(0, 0, 1)
+	rows, err := database.MysqlInstance.Query(layout.query, layout.args(start, end)...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	rowCount := 0
+	values := make([]sql.NullString, len(layout.columns))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	row := make([]any, len(values))
+	for rows.Next() {
+		err := rows.Scan(dest...)
+		if err != nil {
+			return rowCount, err
+		}
+		for i, value := range values {
+			row[i] = reportCell(layout.columns[i], value)
+		}
+		err = writer.Write(row)
+		if err != nil {
+			return rowCount, err
+		}
+		rowCount++
+	}
+	err = rows.Err()
+	if err != nil {
+		return rowCount, err
+	}
+	return rowCount, writer.Close()
+}
+
+// reportCell keeps numeric columns as numbers so spreadsheets can sum them
+func reportCell(column reportColumn, value sql.NullString) any {
+	if !value.Valid {
+		return nil
+	}
+	if column.numeric {
+		if n, err := strconv.ParseInt(value.String, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(value.String, 64); err == nil {
+			return f
+		}
+	}
+	return value.String
+}
+
+// GetExportLogs returns the latest exports first
+func GetExportLogs() ([]ExportLogResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(e.id), e.report, e.format, DATE_FORMAT(e.period_from, '%Y-%m-%d'),
+		       DATE_FORMAT(e.period_to, '%Y-%m-%d'), e.status, e.row_count, COALESCE(e.error, ''), u.username,
+		       e.created_at, COALESCE(e.completed_at, '')
+		FROM export_logs e
+		INNER JOIN users u ON u.id = e.created_by
+		ORDER BY e.created_at DESC
+		LIMIT 100
+	`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []ExportLogResponse
+	for rows.Next() {
+		var temp ExportLogResponse
+		err := rows.Scan(
+			&temp.Id, &temp.Report, &temp.Format, &temp.From, &temp.To, &temp.Status, &temp.RowCount, &temp.Error,
+			&temp.CreatedBy, &temp.CreatedOn, &temp.CompletedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
@@ -0,0 +1,703 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/loancalc"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	TransferCollection = "collection"
+	TransferPayout     = "payout"
+)
+
+type RegisterAsLender struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type CommitmentRequest struct {
+	// Amount in rupiah is added to the existing commitment of the lender
+	Amount int64 `json:"amount" binding:"required"`
+}
+
+// MarketplaceResponse is an approved lending open for funding, the borrower is not disclosed to lenders
+type MarketplaceResponse struct {
+	LendingId      string `json:"lending_id"`
+	Amount         int64  `json:"amount"`
+	InterestRate   int    `json:"interest_rate"`
+	InterestMethod string `json:"interest_method"`
+	Tenor          int    `json:"tenor"`
+	// ScoreLabel is the prediction of the latest credit score
+	ScoreLabel      string  `json:"score_label"`
+	LoanToValue     float64 `json:"loan_to_value"`
+	CommittedAmount int64   `json:"committed_amount"`
+	RemainingAmount int64   `json:"remaining_amount"`
+	LenderCount     int     `json:"lender_count"`
+	CreatedOn       string  `json:"created_on"`
+}
+
+type CommitmentResponse struct {
+	LendingId string `json:"lending_id"`
+	// Amount is the total commitment of the lender
+	Amount          int64 `json:"amount"`
+	CommittedAmount int64 `json:"committed_amount"`
+	RemainingAmount int64 `json:"remaining_amount"`
+	Funded          bool  `json:"funded"`
+}
+
+type LenderCommitmentResponse struct {
+	LendingId     string `json:"lending_id"`
+	LendingStatus string `json:"lending_status"`
+	Amount        int64  `json:"amount"`
+	// Share is the percentage of the lending amount funded by the lender, the repayments are distributed by it
+	Share             float64 `json:"share"`
+	InterestRate      int     `json:"interest_rate"`
+	Tenor             int     `json:"tenor"`
+	ReceivedPrincipal int64   `json:"received_principal"`
+	ReceivedInterest  int64   `json:"received_interest"`
+	// PaidOut is the part of what was received that is already transferred to the lender
+	PaidOut     int64  `json:"paid_out"`
+	FundedOn    string `json:"funded_on,omitempty"`
+	CollectedOn string `json:"collected_on,omitempty"`
+	CreatedOn   string `json:"created_on"`
+}
+
+type LendingCommitmentResponse struct {
+	LenderId          string  `json:"lender_id"`
+	LenderUsername    string  `json:"lender_username"`
+	Amount            int64   `json:"amount"`
+	Share             float64 `json:"share"`
+	ReceivedPrincipal int64   `json:"received_principal"`
+	ReceivedInterest  int64   `json:"received_interest"`
+	PaidOut           int64   `json:"paid_out"`
+	CollectedOn       string  `json:"collected_on,omitempty"`
+	CreatedOn         string  `json:"created_on"`
+}
+
+// LenderTransferRequest records a bank transfer between the platform and a lender made outside of the api
+type LenderTransferRequest struct {
+	LenderId string `json:"lender_id" binding:"required"`
+	// ReferenceNo is the reference of the bank transfer
+	ReferenceNo string `json:"reference_no"`
+}
+
+type LenderTransferResponse struct {
+	Id        string `json:"id"`
+	LendingId string `json:"lending_id"`
+	LenderId  string `json:"lender_id"`
+	// Type is collection for the commitment transferred by the lender or payout for what is transferred back to them
+	Type        string `json:"type"`
+	Amount      int64  `json:"amount"`
+	ReferenceNo string `json:"reference_no,omitempty"`
+	CreatedBy   string `json:"created_by"`
+	CreatedOn   string `json:"created_on"`
+}
+
+type DistributionResponse struct {
+	Id        string `json:"id"`
+	LendingId string `json:"lending_id"`
+	BillId    string `json:"bill_id"`
+	Principal int64  `json:"principal"`
+	// Interest is what the lender receives after the Commission of the platform
+	Interest   int64  `json:"interest"`
+	Commission int64  `json:"commission"`
+	CreatedOn  string `json:"created_on"`
+}
+
+func (r *RegisterAsLender) Register() error {
+	passBytes, err := bcrypt.GenerateFromPassword([]byte(r.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = database.MysqlInstance.Exec(
+		`INSERT INTO users (username, hashed_password, is_lender) VALUES (?, ?, ?)`, r.Username, string(passBytes), true,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetMarketplace lists the approved lending that are not fully funded yet, oldest first
+func GetMarketplace() ([]MarketplaceResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(l.id), ROUND(l.amount), l.interest_rate, l.interest_method, l.tenor,
+		       COALESCE((
+		           SELECT cs.prediction FROM credit_scores cs WHERE cs.lending_refer = l.id
+		           ORDER BY cs.created_at DESC LIMIT 1
+		       ), ''),
+		       COALESCE((
+		           SELECT SUM(c.appraisal_value) FROM collaterals c WHERE c.lending_refer = l.id AND c.status = ?
+		       ), 0),
+		       COALESCE(SUM(lc.amount), 0), COUNT(lc.lender_refer), l.created_at
+		FROM lending l
+		LEFT JOIN lending_commitments lc ON lc.lending_refer = l.id
+		WHERE l.status = 'approved' AND l.is_approved = TRUE AND l.funded_at IS NULL AND l.disbursed_at IS NULL
+		GROUP BY l.id
+		ORDER BY l.created_at
+	`, CollateralPledged,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []MarketplaceResponse
+	for rows.Next() {
+		var temp MarketplaceResponse
+		var appraisedValue float64
+		err := rows.Scan(
+			&temp.LendingId, &temp.Amount, &temp.InterestRate, &temp.InterestMethod, &temp.Tenor, &temp.ScoreLabel,
+			&appraisedValue, &temp.CommittedAmount, &temp.LenderCount, &temp.CreatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		temp.LoanToValue = loanToValue(float64(temp.Amount), appraisedValue)
+		temp.RemainingAmount = temp.Amount - temp.CommittedAmount
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// lockFundableLending locks the lending and returns its amount with the sum of the commitments
+func lockFundableLending(tx *sql.Tx, id string, uid string) (int64, int64, error) {
+	var borrowerUid, status string
+	var amount float64
+	var isFunded bool
+	err := tx.QueryRow(
+		`SELECT BIN_TO_UUID(user_refer), amount, status, funded_at IS NOT NULL FROM lending WHERE id = UUID_TO_BIN(?) AND is_approved = TRUE FOR UPDATE`,
+		id,
+	).Scan(&borrowerUid, &amount, &status, &isFunded)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, fmt.Errorf("lending not found")
+		}
+		return 0, 0, err
+	}
+	if borrowerUid == uid {
+		return 0, 0, fmt.Errorf("cannot fund own lending")
+	}
+	if status != "approved" || isFunded {
+		return 0, 0, fmt.Errorf("lending is no longer open for funding")
+	}
+
+	var committed int64
+	err = tx.QueryRow(
+		`SELECT COALESCE(SUM(amount), 0) FROM lending_commitments WHERE lending_refer = UUID_TO_BIN(?)`, id,
+	).Scan(&committed)
+	if err != nil {
+		return 0, 0, err
+	}
+	return loancalc.Rupiah(amount), committed, nil
+}
+
+// Commit adds the commitment of the lender uid to the lending, which is funded once the commitments reach its amount
+func (c *CommitmentRequest) Commit(id string, uid string) (CommitmentResponse, error) {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return CommitmentResponse{}, err
+	}
+	defer tx.Rollback()
+
+	amount, committed, err := lockFundableLending(tx, id, uid)
+	if err != nil {
+		return CommitmentResponse{}, err
+	}
+	remaining := amount - committed
+	minimum := lendingRules.MinCommitmentAmount
+	if remaining < minimum {
+		minimum = remaining
+	}
+	if c.Amount < minimum || c.Amount > remaining {
+		return CommitmentResponse{}, jsonutil.FieldErrors{
+			{
+				Field:   "amount",
+				Code:    "out_of_range",
+				Message: fmt.Sprintf("amount must be between %d and %d", minimum, remaining),
+			},
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO lending_commitments (lending_refer, lender_refer, amount) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?) ON DUPLICATE KEY UPDATE amount = amount + VALUES(amount)`,
+		id, uid, c.Amount,
+	)
+	if err != nil {
+		return CommitmentResponse{}, err
+	}
+	res := CommitmentResponse{
+		LendingId:       id,
+		CommittedAmount: committed + c.Amount,
+		RemainingAmount: remaining - c.Amount,
+		Funded:          remaining == c.Amount,
+	}
+	err = tx.QueryRow(
+		`SELECT amount FROM lending_commitments WHERE lending_refer = UUID_TO_BIN(?) AND lender_refer = UUID_TO_BIN(?)`,
+		id, uid,
+	).Scan(&res.Amount)
+	if err != nil {
+		return CommitmentResponse{}, err
+	}
+	if res.Funded {
+		_, err = tx.Exec(`UPDATE lending SET funded_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)`, id)
+		if err != nil {
+			return CommitmentResponse{}, err
+		}
+		err = postFunding(tx, id)
+		if err != nil {
+			return CommitmentResponse{}, err
+		}
+	}
+	return res, tx.Commit()
+}
+
+// WithdrawCommitment removes the commitment of the lender uid while the lending is not fully funded
+func WithdrawCommitment(id string, uid string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, _, err = lockFundableLending(tx, id, uid)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		`DELETE FROM lending_commitments WHERE lending_refer = UUID_TO_BIN(?) AND lender_refer = UUID_TO_BIN(?)`, id, uid,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("commitment not found")
+	}
+	return tx.Commit()
+}
+
+// proRata splits total by the weights, the remainder of the rounding goes to the largest fractions so the parts always
+// sum up to total
+func proRata(total int64, weights []int64) []int64 {
+	parts := make([]int64, len(weights))
+	var sum int64
+	for _, weight := range weights {
+		sum += weight
+	}
+	if sum <= 0 || total <= 0 {
+		return parts
+	}
+	remainders := make([]int64, len(weights))
+	distributed := int64(0)
+	for i, weight := range weights {
+		parts[i] = total * weight / sum
+		remainders[i] = total * weight % sum
+		distributed += parts[i]
+	}
+	for ; distributed < total; distributed++ {
+		largest := 0
+		for i := range remainders {
+			if remainders[i] > remainders[largest] {
+				largest = i
+			}
+		}
+		parts[largest]++
+		remainders[largest] = -1
+	}
+	return parts
+}
+
+// lenderShares splits total between the commitments, the part of amount nobody committed to is funded by the platform
+// which keeps its share
+func lenderShares(total int64, commitments []int64, amount int64) []int64 {
+	var committed int64
+	for _, commitment := range commitments {
+		committed += commitment
+	}
+	weights := commitments[:len(commitments):len(commitments)]
+	if amount > committed {
+		weights = append(weights, amount-committed)
+	}
+	return proRata(total, weights)[:len(commitments)]
+}
+
+// distributeRepayment shares the principal and interest of a settled bill between the lenders of the lending by their
+// commitment, the fees are kept by the platform along with the commission on the interest. The platform funds what the
+// lenders did not commit to, so it keeps that share of a partially funded lending and all of a lending without
+// commitments
+func distributeRepayment(tx *sql.Tx, billId string, lendingId string, principal int64, interest int64) error {
+	var amount int64
+	err := tx.QueryRow(`SELECT ROUND(amount) FROM lending WHERE id = UUID_TO_BIN(?)`, lendingId).Scan(&amount)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query(
+		`SELECT BIN_TO_UUID(lender_refer), amount FROM lending_commitments WHERE lending_refer = UUID_TO_BIN(?) ORDER BY created_at, lender_refer`,
+		lendingId,
+	)
+	if err != nil {
+		return err
+	}
+	var lenders []string
+	var weights []int64
+	for rows.Next() {
+		var lender string
+		var amount int64
+		err := rows.Scan(&lender, &amount)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		lenders = append(lenders, lender)
+		weights = append(weights, amount)
+	}
+	rows.Close()
+	if len(lenders) == 0 || principal+interest == 0 {
+		return nil
+	}
+
+	principals := lenderShares(principal, weights, amount)
+	interests := lenderShares(interest, weights, amount)
+	for i, lender := range lenders {
+		commission := commissionOf(interests[i])
+		_, err = tx.Exec(
+			`INSERT INTO lender_distributions (id, bill_refer, lending_refer, lender_refer, principal, interest, commission) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?)`,
+			uuid.New().String(), billId, lendingId, lender, principals[i], interests[i]-commission, commission,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return postJournal(
+		tx, JournalDistribution, billId, lendingId, "interest distributed to lenders",
+		distributionLines(lenders, interests),
+	)
+}
+
+// distributionLines move the interest of the lenders out of the income, the principal is already owed to them since
+// the funding. The interest of the platform share stays in the income
+func distributionLines(lenders []string, interests []int64) []journalLine {
+	var lenderInterest, commissionTotal int64
+	var lines []journalLine
+	for i, lender := range lenders {
+		commission := commissionOf(interests[i])
+		lenderInterest += interests[i]
+		commissionTotal += commission
+		lines = append(
+			lines, journalLine{account: AccountLenderPayable, party: lender, credit: interests[i] - commission},
+		)
+	}
+	return append(
+		lines,
+		journalLine{account: AccountInterestIncome, debit: lenderInterest},
+		journalLine{account: AccountCommissionIncome, credit: commissionTotal},
+	)
+}
+
+// commissionOf is the part of the interest of a lender kept by the platform, rounded down to the rupiah
+func commissionOf(interest int64) int64 {
+	return int64(float64(interest) * lendingRules.LenderCommissionPercent / 100)
+}
+
+// lockCommitment locks the commitment of the lender to the lending and returns its amount and whether it is collected
+func lockCommitment(tx *sql.Tx, id string, lenderId string) (int64, bool, error) {
+	var amount int64
+	var collected bool
+	err := tx.QueryRow(
+		`SELECT amount, collected_at IS NOT NULL FROM lending_commitments WHERE lending_refer = UUID_TO_BIN(?) AND lender_refer = UUID_TO_BIN(?) FOR UPDATE`,
+		id, lenderId,
+	).Scan(&amount, &collected)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, fmt.Errorf("commitment not found")
+		}
+		return 0, false, err
+	}
+	return amount, collected, nil
+}
+
+func (t *LenderTransferRequest) validate() error {
+	if len(t.ReferenceNo) > 255 {
+		return jsonutil.FieldErrors{
+			{Field: "reference_no", Code: "too_long", Message: "reference_no must be at most 255 characters"},
+		}
+	}
+	return nil
+}
+
+func (t *LenderTransferRequest) createTransfer(
+	tx *sql.Tx, id string, uid string, transferType string, amount int64,
+) (LenderTransferResponse, error) {
+	res := LenderTransferResponse{
+		Id:          uuid.New().String(),
+		LendingId:   id,
+		LenderId:    t.LenderId,
+		Type:        transferType,
+		Amount:      amount,
+		ReferenceNo: t.ReferenceNo,
+		CreatedBy:   uid,
+	}
+	_, err := tx.Exec(
+		`INSERT INTO lender_transfers (id, lending_refer, lender_refer, type, amount, reference_no, created_by) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, NULLIF(?, ''), UUID_TO_BIN(?))`,
+		res.Id, id, t.LenderId, transferType, amount, t.ReferenceNo, uid,
+	)
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	err = tx.QueryRow(`SELECT created_at FROM lender_transfers WHERE id = UUID_TO_BIN(?)`, res.Id).Scan(&res.CreatedOn)
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	return res, nil
+}
+
+// CollectCommitment records that the lender transferred their whole commitment to the platform, a commitment is only
+// collected once the lending is closed for funding. uid is the admin recording it
+func (t *LenderTransferRequest) CollectCommitment(id string, uid string) (LenderTransferResponse, error) {
+	err := t.validate()
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	defer tx.Rollback()
+
+	//	the funding entry the collection clears is posted once the lending is funded or disbursed
+	var isClosed bool
+	err = tx.QueryRow(
+		`SELECT funded_at IS NOT NULL OR disbursed_at IS NOT NULL FROM lending WHERE id = UUID_TO_BIN(?) FOR UPDATE`, id,
+	).Scan(&isClosed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LenderTransferResponse{}, fmt.Errorf("lending not found")
+		}
+		return LenderTransferResponse{}, err
+	}
+	if !isClosed {
+		return LenderTransferResponse{}, fmt.Errorf("lending is still open for funding")
+	}
+	amount, collected, err := lockCommitment(tx, id, t.LenderId)
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	if collected {
+		return LenderTransferResponse{}, fmt.Errorf("commitment is already collected")
+	}
+
+	res, err := t.createTransfer(tx, id, uid, TransferCollection, amount)
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	_, err = tx.Exec(
+		`UPDATE lending_commitments SET collected_at = CURRENT_TIMESTAMP WHERE lending_refer = UUID_TO_BIN(?) AND lender_refer = UUID_TO_BIN(?)`,
+		id, t.LenderId,
+	)
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	err = postJournal(
+		tx, JournalCollection, res.Id, id, "commitment collected from lender", collectionLines(t.LenderId, amount),
+	)
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	return res, tx.Commit()
+}
+
+// PayLender records that everything distributed to the lender from the lending and not paid out yet was transferred
+// to them. A lender is only paid once their commitment is collected, uid is the admin recording it
+func (t *LenderTransferRequest) PayLender(id string, uid string) (LenderTransferResponse, error) {
+	err := t.validate()
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	defer tx.Rollback()
+
+	//	locking the commitment keeps two payouts of the same distributions apart
+	_, collected, err := lockCommitment(tx, id, t.LenderId)
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	if !collected {
+		return LenderTransferResponse{}, fmt.Errorf("commitment has not been collected yet")
+	}
+	var amount int64
+	err = tx.QueryRow(
+		`
+		SELECT (SELECT COALESCE(SUM(principal + interest), 0) FROM lender_distributions WHERE lending_refer = UUID_TO_BIN(?) AND lender_refer = UUID_TO_BIN(?))
+		     - (SELECT COALESCE(SUM(amount), 0) FROM lender_transfers WHERE lending_refer = UUID_TO_BIN(?) AND lender_refer = UUID_TO_BIN(?) AND type = ?)
+	`, id, t.LenderId, id, t.LenderId, TransferPayout,
+	).Scan(&amount)
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	if amount <= 0 {
+		return LenderTransferResponse{}, fmt.Errorf("nothing left to pay out to the lender")
+	}
+
+	res, err := t.createTransfer(tx, id, uid, TransferPayout, amount)
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	err = postJournal(tx, JournalLenderPayout, res.Id, id, "paid out to lender", lenderPayoutLines(t.LenderId, amount))
+	if err != nil {
+		return LenderTransferResponse{}, err
+	}
+	return res, tx.Commit()
+}
+
+// GetLendingTransfers returns every collection and payout of the lenders of the lending, oldest first
+func GetLendingTransfers(id string) ([]LenderTransferResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(id), BIN_TO_UUID(lending_refer), BIN_TO_UUID(lender_refer), type, amount,
+		       COALESCE(reference_no, ''), BIN_TO_UUID(created_by), created_at
+		FROM lender_transfers
+		WHERE lending_refer = UUID_TO_BIN(?)
+		ORDER BY created_at, id
+	`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []LenderTransferResponse
+	for rows.Next() {
+		var temp LenderTransferResponse
+		err := rows.Scan(
+			&temp.Id, &temp.LendingId, &temp.LenderId, &temp.Type, &temp.Amount, &temp.ReferenceNo, &temp.CreatedBy,
+			&temp.CreatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// paidOutQuery sums the payouts of the commitment lc
+const paidOutQuery = `SELECT COALESCE(SUM(t.amount), 0) FROM lender_transfers t WHERE t.lending_refer = lc.lending_refer AND t.lender_refer = lc.lender_refer AND t.type = '` + TransferPayout + `'`
+
+// GetLenderCommitments returns the commitments of the lender uid with what has been distributed to them so far
+func GetLenderCommitments(uid string) ([]LenderCommitmentResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(l.id), l.status, lc.amount, ROUND(l.amount), l.interest_rate, l.tenor,
+		       COALESCE(SUM(d.principal), 0), COALESCE(SUM(d.interest), 0), (`+paidOutQuery+`),
+		       COALESCE(l.funded_at, ''), COALESCE(lc.collected_at, ''), lc.created_at
+		FROM lending_commitments lc
+		INNER JOIN lending l ON l.id = lc.lending_refer
+		LEFT JOIN lender_distributions d ON d.lending_refer = lc.lending_refer AND d.lender_refer = lc.lender_refer
+		WHERE lc.lender_refer = UUID_TO_BIN(?)
+		GROUP BY lc.lending_refer, lc.lender_refer
+		ORDER BY lc.created_at DESC
+	`, uid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []LenderCommitmentResponse
+	for rows.Next() {
+		var temp LenderCommitmentResponse
+		var lendingAmount int64
+		err := rows.Scan(
+			&temp.LendingId, &temp.LendingStatus, &temp.Amount, &lendingAmount, &temp.InterestRate, &temp.Tenor,
+			&temp.ReceivedPrincipal, &temp.ReceivedInterest, &temp.PaidOut, &temp.FundedOn, &temp.CollectedOn,
+			&temp.CreatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		temp.Share = percentage(temp.Amount, lendingAmount)
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// GetLendingCommitments returns every commitment made on the lending, largest first
+func GetLendingCommitments(id string) ([]LendingCommitmentResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(lc.lender_refer), u.username, lc.amount, ROUND(l.amount), COALESCE(SUM(d.principal), 0),
+		       COALESCE(SUM(d.interest), 0), (`+paidOutQuery+`), COALESCE(lc.collected_at, ''), lc.created_at
+		FROM lending_commitments lc
+		INNER JOIN lending l ON l.id = lc.lending_refer
+		INNER JOIN users u ON u.id = lc.lender_refer
+		LEFT JOIN lender_distributions d ON d.lending_refer = lc.lending_refer AND d.lender_refer = lc.lender_refer
+		WHERE lc.lending_refer = UUID_TO_BIN(?)
+		GROUP BY lc.lending_refer, lc.lender_refer
+		ORDER BY lc.amount DESC
+	`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []LendingCommitmentResponse
+	for rows.Next() {
+		var temp LendingCommitmentResponse
+		var lendingAmount int64
+		err := rows.Scan(
+			&temp.LenderId, &temp.LenderUsername, &temp.Amount, &lendingAmount, &temp.ReceivedPrincipal,
+			&temp.ReceivedInterest, &temp.PaidOut, &temp.CollectedOn, &temp.CreatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		temp.Share = percentage(temp.Amount, lendingAmount)
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// GetLenderDistributions returns the repayments distributed to the lender uid, latest first
+func GetLenderDistributions(uid string) ([]DistributionResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(id), BIN_TO_UUID(lending_refer), BIN_TO_UUID(bill_refer), principal, interest, commission,
+		       created_at
+		FROM lender_distributions
+		WHERE lender_refer = UUID_TO_BIN(?)
+		ORDER BY created_at DESC
+		LIMIT 100
+	`, uid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []DistributionResponse
+	for rows.Next() {
+		var temp DistributionResponse
+		err := rows.Scan(
+			&temp.Id, &temp.LendingId, &temp.BillId, &temp.Principal, &temp.Interest, &temp.Commission, &temp.CreatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProRata(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int64
+		weights []int64
+		want    []int64
+	}{
+		{name: "exact split", total: 10, weights: []int64{3, 7}, want: []int64{3, 7}},
+		{name: "remainder goes to the largest fraction", total: 1000, weights: []int64{1, 2}, want: []int64{333, 667}},
+		{name: "equal fractions go to the first", total: 100, weights: []int64{1, 1, 1}, want: []int64{34, 33, 33}},
+		{name: "remainder over several parts", total: 2, weights: []int64{1, 1, 1}, want: []int64{1, 1, 0}},
+		{
+			name: "commitments in rupiah", total: 1_066_185, weights: []int64{5_000_000, 4_000_000, 3_000_000},
+			want: []int64{444_244, 355_395, 266_546},
+		},
+		{name: "zero weight gets nothing", total: 9, weights: []int64{0, 1, 2}, want: []int64{0, 3, 6}},
+		{name: "no weight", total: 100, weights: []int64{0, 0}, want: []int64{0, 0}},
+		{name: "nothing to split", total: 0, weights: []int64{1, 2}, want: []int64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got := proRata(tt.total, tt.weights)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestLenderShares(t *testing.T) {
+	tests := []struct {
+		name        string
+		total       int64
+		commitments []int64
+		amount      int64
+		want        []int64
+	}{
+		{
+			name: "fully funded", total: 1_000_000, commitments: []int64{6_000_000, 4_000_000}, amount: 10_000_000,
+			want: []int64{600_000, 400_000},
+		},
+		{
+			name: "platform keeps the uncommitted share", total: 1_000_000, commitments: []int64{3_000_000, 2_000_000},
+			amount: 10_000_000, want: []int64{300_000, 200_000},
+		},
+		{
+			name: "platform share takes part in the rounding", total: 100, commitments: []int64{1, 1}, amount: 3,
+			want: []int64{34, 33},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got := lenderShares(tt.total, tt.commitments, tt.amount)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
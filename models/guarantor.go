@@ -0,0 +1,476 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/authutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/scoring"
+	"github.com/Tus1688/kim-hackathon-2023-api/sms"
+	"github.com/google/uuid"
+)
+
+const (
+	GuarantorInvited   = "invited"
+	GuarantorConfirmed = "confirmed"
+	GuarantorDeclined  = "declined"
+)
+
+const (
+	maxGuarantors = 3
+	// guarantorInvitationTTL is how long the guarantor has to answer, the borrower can issue a new invitation after
+	guarantorInvitationTTL = 7 * 24 * time.Hour
+)
+
+type GuarantorRequest struct {
+	Name string `json:"name" binding:"required"`
+	// Nik is the 16 digits number of the ktp
+	Nik           string  `json:"nik" binding:"required"`
+	PhoneNumber   string  `json:"phone_number" binding:"required"`
+	Relationship  string  `json:"relationship" binding:"required"`
+	Age           int     `json:"age" binding:"required"`
+	Income        float64 `json:"income" binding:"required"`
+	KtpDocumentId string  `json:"ktp_document_id" binding:"required"`
+}
+
+type GuarantorResponse struct {
+	Id            string  `json:"id"`
+	LendingId     string  `json:"lending_id"`
+	Name          string  `json:"name"`
+	Nik           string  `json:"nik"`
+	PhoneNumber   string  `json:"phone_number"`
+	Relationship  string  `json:"relationship"`
+	Age           int     `json:"age"`
+	Income        float64 `json:"income"`
+	KtpDocumentId string  `json:"ktp_document_id"`
+	Status        string  `json:"status"`
+	ExpiresOn     string  `json:"expires_on"`
+	RespondedOn   string  `json:"responded_on,omitempty"`
+	CreatedOn     string  `json:"created_on"`
+}
+
+// GuarantorInvitationResponse is returned to the borrower, the token is only sent to the phone number of the guarantor
+// so the borrower can not answer in their place. A lost token needs a new invitation
+type GuarantorInvitationResponse struct {
+	GuarantorId string `json:"guarantor_id"`
+	ExpiresOn   string `json:"expires_on"`
+}
+
+// InvitationDetailResponse is what the guarantor sees before answering the invitation
+type InvitationDetailResponse struct {
+	GuarantorName    string  `json:"guarantor_name"`
+	BorrowerUsername string  `json:"borrower_username"`
+	Amount           float64 `json:"amount"`
+	Tenor            int     `json:"tenor"`
+	Status           string  `json:"status"`
+	ExpiresOn        string  `json:"expires_on"`
+}
+
+type InvitationAnswerRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return value != ""
+}
+
+func (g *GuarantorRequest) validate() error {
+	var fieldErrors jsonutil.FieldErrors
+	if len(g.Name) > 64 {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field: "name", Code: "too_long", Message: "name must be at most 64 characters",
+			},
+		)
+	}
+	if len(g.Nik) != 16 || !isDigits(g.Nik) {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field: "nik", Code: "invalid_format", Message: "nik must be 16 digits",
+			},
+		)
+	}
+	phoneNumber := strings.TrimPrefix(g.PhoneNumber, "+")
+	if len(phoneNumber) < 8 || len(phoneNumber) > 15 || !isDigits(phoneNumber) {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "phone_number",
+				Code:    "invalid_format",
+				Message: "phone_number must be between 8 and 15 digits",
+			},
+		)
+	}
+	if len(g.Relationship) > 32 {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field: "relationship", Code: "too_long", Message: "relationship must be at most 32 characters",
+			},
+		)
+	}
+	if g.Age < lendingRules.MinAge {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "age",
+				Code:    "too_young",
+				Message: fmt.Sprintf("age must be at least %d", lendingRules.MinAge),
+			},
+		)
+	}
+	if g.Income <= 0 {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field: "income", Code: "out_of_range", Message: "income must be greater than 0",
+			},
+		)
+	}
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+	return nil
+}
+
+// newInvitation returns the token and the columns to store, only the hash of the token is kept
+func newInvitation() (string, string, time.Time, error) {
+	token := authutil.GenerateRandomString(32)
+	if token == "" {
+		return "", "", time.Time{}, fmt.Errorf("unable to generate invitation token")
+	}
+	return token, hashInvitationToken(token), time.Now().UTC().Add(guarantorInvitationTTL), nil
+}
+
+// sendInvitation texts the token to the guarantor once the invitation is stored, a failure leaves the invitation
+// unusable until the borrower invites the guarantor again
+func sendInvitation(name string, phoneNumber string, token string, expiresAt time.Time) error {
+	message := fmt.Sprintf(
+		"Hi %s, you are invited to guarantee a loan proposal. Use the code %s to review and answer it before %s",
+		name, token, expiresAt.Format("2006-01-02 15:04 MST"),
+	)
+	err := sms.Send(context.Background(), phoneNumber, message)
+	if err != nil {
+		return fmt.Errorf("unable to send the invitation to the guarantor: %v", err)
+	}
+	return nil
+}
+
+// changeGuarantors records the change as a revision of the proposal of uid and withdraws any offer, the proposal is
+// scored and priced again with the new guarantors
+func changeGuarantors(tx *sql.Tx, id string, uid string, reason string) error {
+	err := lockEditableLending(tx, id, uid, RevisionGuarantor, reason)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE lending SET interest_rate = 0, pricing_grid_refer = NULL, offer_credit_score_refer = NULL, offered_at = NULL, offer_expires_at = NULL, offer_accepted_at = NULL, status = 'pending_offer' WHERE id = UUID_TO_BIN(?)`,
+		id,
+	)
+	return err
+}
+
+// Create adds the guarantor to the proposal of uid and invites them. The ktp is uploaded by the borrower on behalf
+// of the guarantor
+func (g *GuarantorRequest) Create(id string, uid string) (GuarantorInvitationResponse, error) {
+	err := g.validate()
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	_, err = ownedDocumentFileName(g.KtpDocumentId, uid, DocumentTypeKTP)
+	if err != nil {
+		var code, message string
+		switch {
+		case strings.Contains(err.Error(), "not found"):
+			code, message = "not_found", "ktp_document_id does not refer to a document you uploaded"
+		case strings.Contains(err.Error(), "mismatch"):
+			code, message = "type_mismatch", "ktp_document_id must refer to a ktp document"
+		default:
+			return GuarantorInvitationResponse{}, err
+		}
+		return GuarantorInvitationResponse{}, jsonutil.FieldErrors{
+			{Field: "ktp_document_id", Code: code, Message: message},
+		}
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	defer tx.Rollback()
+
+	err = changeGuarantors(tx, id, uid, "added guarantor "+g.Name)
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	var count int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM lending_guarantors WHERE lending_refer = UUID_TO_BIN(?)`, id,
+	).Scan(&count)
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	if count >= maxGuarantors {
+		return GuarantorInvitationResponse{}, jsonutil.FieldErrors{
+			{
+				Field:   "guarantors",
+				Code:    "too_many",
+				Message: fmt.Sprintf("a proposal cannot have more than %d guarantors", maxGuarantors),
+			},
+		}
+	}
+
+	token, tokenHash, expiresAt, err := newInvitation()
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	res := GuarantorInvitationResponse{
+		GuarantorId: uuid.New().String(),
+		ExpiresOn:   expiresAt.Format(time.RFC3339),
+	}
+	_, err = tx.Exec(
+		`INSERT INTO lending_guarantors (id, lending_refer, name, nik, phone_number, relationship, age, income, ktp_document_refer, status, invitation_hash, invitation_expires_at) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?, UUID_TO_BIN(?), ?, ?, ?)`,
+		res.GuarantorId, id, g.Name, g.Nik, g.PhoneNumber, g.Relationship, g.Age, g.Income, g.KtpDocumentId,
+		GuarantorInvited, tokenHash, expiresAt,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			return GuarantorInvitationResponse{}, jsonutil.FieldErrors{
+				{Field: "nik", Code: "duplicate", Message: "the guarantor is already on this proposal"},
+			}
+		}
+		return GuarantorInvitationResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	return res, sendInvitation(g.Name, g.PhoneNumber, token, expiresAt)
+}
+
+// ReinviteGuarantor replaces the invitation of a guarantor who has not confirmed, e.g. after it expired or the
+// guarantor declined
+func ReinviteGuarantor(id string, uid string) (GuarantorInvitationResponse, error) {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	defer tx.Rollback()
+
+	var lendingId, name, phoneNumber, status string
+	err = tx.QueryRow(
+		`SELECT BIN_TO_UUID(lending_refer), name, phone_number, status FROM lending_guarantors WHERE id = UUID_TO_BIN(?)`,
+		id,
+	).Scan(&lendingId, &name, &phoneNumber, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return GuarantorInvitationResponse{}, fmt.Errorf("guarantor not found")
+		}
+		return GuarantorInvitationResponse{}, err
+	}
+	if status == GuarantorConfirmed {
+		return GuarantorInvitationResponse{}, fmt.Errorf("guarantor is already confirmed")
+	}
+	err = changeGuarantors(tx, lendingId, uid, "invited guarantor "+name+" again")
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+
+	token, tokenHash, expiresAt, err := newInvitation()
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	_, err = tx.Exec(
+		`UPDATE lending_guarantors SET status = ?, invitation_hash = ?, invitation_expires_at = ?, responded_at = NULL, responded_ip_address = NULL, responded_user_agent = NULL WHERE id = UUID_TO_BIN(?)`,
+		GuarantorInvited, tokenHash, expiresAt, id,
+	)
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return GuarantorInvitationResponse{}, err
+	}
+	return GuarantorInvitationResponse{
+		GuarantorId: id,
+		ExpiresOn:   expiresAt.Format(time.RFC3339),
+	}, sendInvitation(name, phoneNumber, token, expiresAt)
+}
+
+// DeleteGuarantor removes the guarantor while the proposal is pending review
+func DeleteGuarantor(id string, uid string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lendingId, name string
+	err = tx.QueryRow(
+		`SELECT BIN_TO_UUID(lending_refer), name FROM lending_guarantors WHERE id = UUID_TO_BIN(?)`, id,
+	).Scan(&lendingId, &name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("guarantor not found")
+		}
+		return err
+	}
+	err = changeGuarantors(tx, lendingId, uid, "removed guarantor "+name)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM lending_guarantors WHERE id = UUID_TO_BIN(?)`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetGuarantors returns the guarantors of the lending, uid restricts it to the lending of the borrower and is empty
+// for admins
+func GetGuarantors(id string, uid string) ([]GuarantorResponse, error) {
+	query := `
+		SELECT BIN_TO_UUID(g.id), g.name, g.nik, g.phone_number, g.relationship, g.age, g.income,
+		       BIN_TO_UUID(g.ktp_document_refer), g.status, g.invitation_expires_at, COALESCE(g.responded_at, ''),
+		       g.created_at
+		FROM lending_guarantors g
+		INNER JOIN lending l ON l.id = g.lending_refer
+		WHERE g.lending_refer = UUID_TO_BIN(?)`
+	args := []interface{}{id}
+	if uid != "" {
+		query += " AND l.user_refer = UUID_TO_BIN(?)"
+		args = append(args, uid)
+	}
+	rows, err := database.MysqlInstance.Query(query+" ORDER BY g.created_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []GuarantorResponse
+	for rows.Next() {
+		temp := GuarantorResponse{LendingId: id}
+		err := rows.Scan(
+			&temp.Id, &temp.Name, &temp.Nik, &temp.PhoneNumber, &temp.Relationship, &temp.Age, &temp.Income,
+			&temp.KtpDocumentId, &temp.Status, &temp.ExpiresOn, &temp.RespondedOn, &temp.CreatedOn,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// GetInvitation shows the proposal to the guarantor holding the token
+func GetInvitation(token string) (InvitationDetailResponse, error) {
+	var res InvitationDetailResponse
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT g.name, u.username, l.amount, l.tenor, g.status, g.invitation_expires_at
+		FROM lending_guarantors g
+		INNER JOIN lending l ON l.id = g.lending_refer
+		INNER JOIN users u ON u.id = l.user_refer
+		WHERE g.invitation_hash = ?
+	`, hashInvitationToken(token),
+	).Scan(&res.GuarantorName, &res.BorrowerUsername, &res.Amount, &res.Tenor, &res.Status, &res.ExpiresOn)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return InvitationDetailResponse{}, fmt.Errorf("invitation not found")
+		}
+		return InvitationDetailResponse{}, err
+	}
+	return res, nil
+}
+
+// AnswerInvitation records whether the guarantor confirms their participation, an invitation is answered once
+func (i *InvitationAnswerRequest) AnswerInvitation(confirm bool, requester DocumentRequester) error {
+	status := GuarantorDeclined
+	if confirm {
+		status = GuarantorConfirmed
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id, current string
+	var expired, editable bool
+	err = tx.QueryRow(
+		`SELECT BIN_TO_UUID(g.id), g.status, g.invitation_expires_at < CURRENT_TIMESTAMP, l.status IN (`+editableLendingStatus+`) AND l.is_approved = FALSE AND l.is_rejected = FALSE FROM lending_guarantors g INNER JOIN lending l ON l.id = g.lending_refer WHERE g.invitation_hash = ? FOR UPDATE`,
+		hashInvitationToken(i.Token),
+	).Scan(&id, &current, &expired, &editable)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("invitation not found")
+		}
+		return err
+	}
+	if current != GuarantorInvited {
+		return fmt.Errorf("invitation is already answered")
+	}
+	if expired {
+		return fmt.Errorf("invitation has expired")
+	}
+	if !editable {
+		return fmt.Errorf("lending is no longer pending review")
+	}
+
+	_, err = tx.Exec(
+		`UPDATE lending_guarantors SET status = ?, responded_at = CURRENT_TIMESTAMP, responded_ip_address = ?, responded_user_agent = ? WHERE id = UUID_TO_BIN(?)`,
+		status, requester.IpAddress, requester.truncatedUserAgent(), id,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// hasPendingGuarantor is true while a guarantor has not answered, the proposal is only scored once they all did
+func hasPendingGuarantor(id string) (bool, error) {
+	var pending bool
+	err := database.MysqlInstance.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM lending_guarantors WHERE lending_refer = UUID_TO_BIN(?) AND status = ?)`,
+		id, GuarantorInvited,
+	).Scan(&pending)
+	return pending, err
+}
+
+// getGuarantorFeatures returns the scoring features of the guarantors who confirmed
+func getGuarantorFeatures(id string) ([]scoring.GuarantorFeatures, error) {
+	rows, err := database.MysqlInstance.Query(
+		`SELECT age, FLOOR(income) FROM lending_guarantors WHERE lending_refer = UUID_TO_BIN(?) AND status = ? ORDER BY created_at`,
+		id, GuarantorConfirmed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []scoring.GuarantorFeatures
+	for rows.Next() {
+		var temp scoring.GuarantorFeatures
+		err := rows.Scan(&temp.Age, &temp.Income)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
@@ -0,0 +1,389 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/google/uuid"
+)
+
+const (
+	AccountPlatformCash       = "platform_cash"
+	AccountBorrowerReceivable = "borrower_receivable"
+	// AccountCommitmentReceivable is what the lenders committed to a lending and the platform has not collected yet
+	AccountCommitmentReceivable = "commitment_receivable"
+	AccountLenderPayable        = "lender_payable"
+	AccountInterestIncome       = "interest_income"
+	AccountFeeIncome            = "fee_income"
+	AccountCommissionIncome     = "commission_income"
+)
+
+const (
+	AccountTypeAsset     = "asset"
+	AccountTypeLiability = "liability"
+	AccountTypeIncome    = "income"
+)
+
+// ledgerAccounts is the chart of accounts in the order of the trial balance, assets have a debit balance while
+// liabilities and income have a credit balance
+var ledgerAccounts = []struct {
+	name        string
+	accountType string
+}{
+	{AccountPlatformCash, AccountTypeAsset},
+	{AccountBorrowerReceivable, AccountTypeAsset},
+	{AccountCommitmentReceivable, AccountTypeAsset},
+	{AccountLenderPayable, AccountTypeLiability},
+	{AccountInterestIncome, AccountTypeIncome},
+	{AccountFeeIncome, AccountTypeIncome},
+	{AccountCommissionIncome, AccountTypeIncome},
+}
+
+// the money movement a journal entry records, an entry is posted once per movement
+const (
+	JournalFunding      = "funding"
+	JournalDisbursement = "disbursement"
+	JournalRepayment    = "repayment"
+	JournalDistribution = "distribution"
+	JournalCollection   = "collection"
+	JournalLenderPayout = "lender_payout"
+)
+
+// journalLine is one side of a journal entry, exactly one of debit and credit is set. party is the borrower or the
+// lender the line is about
+type journalLine struct {
+	account string
+	party   string
+	debit   int64
+	credit  int64
+}
+
+type JournalLineResponse struct {
+	Account string `json:"account"`
+	PartyId string `json:"party_id,omitempty"`
+	Debit   int64  `json:"debit"`
+	Credit  int64  `json:"credit"`
+}
+
+type JournalEntryResponse struct {
+	Id            string                `json:"id"`
+	ReferenceType string                `json:"reference_type"`
+	ReferenceId   string                `json:"reference_id"`
+	Description   string                `json:"description"`
+	Lines         []JournalLineResponse `json:"lines"`
+	CreatedOn     string                `json:"created_on"`
+}
+
+type TrialBalanceAccount struct {
+	Account string `json:"account"`
+	Type    string `json:"type"`
+	Debit   int64  `json:"debit"`
+	Credit  int64  `json:"credit"`
+	// Balance is on the normal side of the account, negative when the account is on the other side
+	Balance int64 `json:"balance"`
+}
+
+type TrialBalanceResponse struct {
+	// AsOf is an inclusive date, every entry posted until the end of it is counted
+	AsOf        string                `json:"as_of"`
+	Accounts    []TrialBalanceAccount `json:"accounts"`
+	TotalDebit  int64                 `json:"total_debit"`
+	TotalCredit int64                 `json:"total_credit"`
+	Balanced    bool                  `json:"balanced"`
+}
+
+// journalTotal is the total debit of the lines, it fails when a line is invalid or the lines do not balance
+func journalTotal(lines []journalLine) (int64, error) {
+	var debit, credit int64
+	for _, line := range lines {
+		if line.debit < 0 || line.credit < 0 || (line.debit > 0 && line.credit > 0) {
+			return 0, fmt.Errorf("invalid journal line on %s", line.account)
+		}
+		debit += line.debit
+		credit += line.credit
+	}
+	if debit != credit {
+		return 0, fmt.Errorf("unbalanced journal entry, debit %d and credit %d", debit, credit)
+	}
+	return debit, nil
+}
+
+// postJournal appends a balanced entry for the money movement, a movement that is already posted is skipped so a
+// replayed webhook does not post it twice. Entries are never updated nor deleted, a correction is a new entry
+func postJournal(
+	tx *sql.Tx, referenceType string, referenceId string, lendingId string, description string, lines []journalLine,
+) error {
+	total, err := journalTotal(lines)
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return nil
+	}
+
+	var posted bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM journal_entries WHERE reference_type = ? AND reference_id = UUID_TO_BIN(?))`,
+		referenceType, referenceId,
+	).Scan(&posted)
+	if err != nil {
+		return err
+	}
+	if posted {
+		return nil
+	}
+
+	entryId := uuid.New().String()
+	_, err = tx.Exec(
+		`INSERT INTO journal_entries (id, reference_type, reference_id, lending_refer, description) VALUES (UUID_TO_BIN(?), ?, UUID_TO_BIN(?), UUID_TO_BIN(?), ?)`,
+		entryId, referenceType, referenceId, lendingId, description,
+	)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if line.debit == 0 && line.credit == 0 {
+			continue
+		}
+		_, err = tx.Exec(
+			`INSERT INTO journal_lines (entry_refer, account, party_refer, debit, credit) VALUES (UUID_TO_BIN(?), ?, IF(? = '', NULL, UUID_TO_BIN(?)), ?, ?)`,
+			entryId, line.account, line.party, line.party, line.debit, line.credit,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postFunding records the commitments of the lenders as owed by them and owed back to them, the lender money is
+// collected afterwards by CollectCommitment. It is posted when the lending is fully funded or, when it is disbursed
+// partially funded, at disbursement
+func postFunding(tx *sql.Tx, lendingId string) error {
+	rows, err := tx.Query(
+		`SELECT BIN_TO_UUID(lender_refer), amount FROM lending_commitments WHERE lending_refer = UUID_TO_BIN(?) ORDER BY created_at, lender_refer`,
+		lendingId,
+	)
+	if err != nil {
+		return err
+	}
+	var lenders []string
+	var amounts []int64
+	for rows.Next() {
+		var lender string
+		var amount int64
+		err := rows.Scan(&lender, &amount)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		lenders = append(lenders, lender)
+		amounts = append(amounts, amount)
+	}
+	rows.Close()
+
+	return postJournal(
+		tx, JournalFunding, lendingId, lendingId, "funds committed by lenders", fundingLines(lenders, amounts),
+	)
+}
+
+func fundingLines(lenders []string, amounts []int64) []journalLine {
+	var lines []journalLine
+	for i, lender := range lenders {
+		lines = append(
+			lines,
+			journalLine{account: AccountCommitmentReceivable, party: lender, debit: amounts[i]},
+			journalLine{account: AccountLenderPayable, party: lender, credit: amounts[i]},
+		)
+	}
+	return lines
+}
+
+// collectionLines clear the commitment of the lender once their money reaches the platform
+func collectionLines(lender string, amount int64) []journalLine {
+	return []journalLine{
+		{account: AccountPlatformCash, debit: amount},
+		{account: AccountCommitmentReceivable, party: lender, credit: amount},
+	}
+}
+
+// lenderPayoutLines settle what the platform owes the lender as it is transferred to them
+func lenderPayoutLines(lender string, amount int64) []journalLine {
+	return []journalLine{
+		{account: AccountLenderPayable, party: lender, debit: amount},
+		{account: AccountPlatformCash, credit: amount},
+	}
+}
+
+// postDisbursement records the amount owed by the borrower, the origination fee is earned as it is deducted from the
+// payout
+func postDisbursement(tx *sql.Tx, disbursementId string) error {
+	var lendingId, borrowerUid string
+	var amount, fee int64
+	err := tx.QueryRow(
+		`SELECT BIN_TO_UUID(d.lending_refer), BIN_TO_UUID(l.user_refer), d.amount, d.fee FROM disbursements d INNER JOIN lending l ON l.id = d.lending_refer WHERE d.id = UUID_TO_BIN(?)`,
+		disbursementId,
+	).Scan(&lendingId, &borrowerUid, &amount, &fee)
+	if err != nil {
+		return err
+	}
+	err = postFunding(tx, lendingId)
+	if err != nil {
+		return err
+	}
+	return postJournal(
+		tx, JournalDisbursement, disbursementId, lendingId, "lending disbursed",
+		disbursementLines(borrowerUid, amount, fee),
+	)
+}
+
+func disbursementLines(borrowerUid string, amount int64, fee int64) []journalLine {
+	return []journalLine{
+		{account: AccountBorrowerReceivable, party: borrowerUid, debit: amount + fee},
+		{account: AccountPlatformCash, credit: amount},
+		{account: AccountFeeIncome, credit: fee},
+	}
+}
+
+// postRepayment records a settled bill by its allocation, the unallocated part stays on the receivable as a credit
+// of the borrower
+func postRepayment(
+	tx *sql.Tx, billId string, lendingId string, fee int64, interest int64, principal int64, unallocated int64,
+) error {
+	var borrowerUid string
+	err := tx.QueryRow(
+		`SELECT BIN_TO_UUID(user_refer) FROM lending WHERE id = UUID_TO_BIN(?)`, lendingId,
+	).Scan(&borrowerUid)
+	if err != nil {
+		return err
+	}
+	return postJournal(
+		tx, JournalRepayment, billId, lendingId, "repayment received",
+		repaymentLines(borrowerUid, fee, interest, principal, unallocated),
+	)
+}
+
+func repaymentLines(borrowerUid string, fee int64, interest int64, principal int64, unallocated int64) []journalLine {
+	return []journalLine{
+		{account: AccountPlatformCash, debit: fee + interest + principal + unallocated},
+		{account: AccountBorrowerReceivable, party: borrowerUid, credit: principal + unallocated},
+		{account: AccountInterestIncome, credit: interest},
+		{account: AccountFeeIncome, credit: fee},
+	}
+}
+
+// GetJournalEntries returns every entry posted for the lending, oldest first
+func GetJournalEntries(lendingId string) ([]JournalEntryResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(e.id), e.reference_type, BIN_TO_UUID(e.reference_id), e.description, e.created_at,
+		       jl.account, COALESCE(BIN_TO_UUID(jl.party_refer), ''), jl.debit, jl.credit
+		FROM journal_entries e
+		INNER JOIN journal_lines jl ON jl.entry_refer = e.id
+		WHERE e.lending_refer = UUID_TO_BIN(?)
+		ORDER BY e.created_at, e.id, jl.id
+	`, lendingId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []JournalEntryResponse
+	for rows.Next() {
+		var entry JournalEntryResponse
+		var line JournalLineResponse
+		err := rows.Scan(
+			&entry.Id, &entry.ReferenceType, &entry.ReferenceId, &entry.Description, &entry.CreatedOn, &line.Account,
+			&line.PartyId, &line.Debit, &line.Credit,
+		)
+		if err != nil {
+			return nil, err
+		}
+		//	the lines of an entry are consecutive
+		if len(res) == 0 || res[len(res)-1].Id != entry.Id {
+			res = append(res, entry)
+		}
+		last := &res[len(res)-1]
+		last.Lines = append(last.Lines, line)
+	}
+	return res, nil
+}
+
+// GetTrialBalance sums the debit and credit of every account until the end of asOf, today when it is zero. The books
+// balance when the total debit equals the total credit
+func GetTrialBalance(asOf time.Time) (TrialBalanceResponse, error) {
+	if asOf.IsZero() {
+		asOf = today()
+	}
+	res := TrialBalanceResponse{AsOf: asOf.Format("2006-01-02")}
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT jl.account, COALESCE(SUM(jl.debit), 0), COALESCE(SUM(jl.credit), 0)
+		FROM journal_lines jl
+		INNER JOIN journal_entries e ON e.id = jl.entry_refer
+		WHERE e.created_at < ?
+		GROUP BY jl.account
+	`, asOf.AddDate(0, 0, 1),
+	)
+	if err != nil {
+		return TrialBalanceResponse{}, err
+	}
+	defer rows.Close()
+
+	totals := map[string][2]int64{}
+	for rows.Next() {
+		var account string
+		var debit, credit int64
+		err := rows.Scan(&account, &debit, &credit)
+		if err != nil {
+			return TrialBalanceResponse{}, err
+		}
+		totals[account] = [2]int64{debit, credit}
+	}
+	err = rows.Err()
+	if err != nil {
+		return TrialBalanceResponse{}, err
+	}
+
+	for _, account := range ledgerAccounts {
+		total := totals[account.name]
+		balance := total[1] - total[0]
+		if account.accountType == AccountTypeAsset {
+			balance = total[0] - total[1]
+		}
+		res.Accounts = append(
+			res.Accounts, TrialBalanceAccount{
+				Account: account.name,
+				Type:    account.accountType,
+				Debit:   total[0],
+				Credit:  total[1],
+				Balance: balance,
+			},
+		)
+		res.TotalDebit += total[0]
+		res.TotalCredit += total[1]
+		delete(totals, account.name)
+	}
+	//	an account outside the chart would mean a line was posted by mistake, it still has to show up
+	unknown := make([]string, 0, len(totals))
+	for account := range totals {
+		unknown = append(unknown, account)
+	}
+	sort.Strings(unknown)
+	for _, account := range unknown {
+		total := totals[account]
+		res.Accounts = append(
+			res.Accounts, TrialBalanceAccount{
+				Account: account, Debit: total[0], Credit: total[1], Balance: total[0] - total[1],
+			},
+		)
+		res.TotalDebit += total[0]
+		res.TotalCredit += total[1]
+	}
+	res.Balanced = res.TotalDebit == res.TotalCredit
+	return res, nil
+}
@@ -0,0 +1,256 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// journalStore is an in memory database/sql driver that only understands the statements of postJournal, an entry is
+// keyed by its reference type and id like the unique key of journal_entries
+type journalStore struct {
+	mu      sync.Mutex
+	entries map[string]bool
+	lines   int
+}
+
+func newJournalStore() *journalStore {
+	return &journalStore{entries: map[string]bool{}}
+}
+
+func (s *journalStore) Connect(context.Context) (driver.Conn, error) { return &journalConn{s}, nil }
+func (s *journalStore) Driver() driver.Driver                        { return nil }
+
+type journalConn struct{ store *journalStore }
+
+func (c *journalConn) Prepare(query string) (driver.Stmt, error) {
+	return &journalStmt{c.store, query}, nil
+}
+func (c *journalConn) Close() error              { return nil }
+func (c *journalConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *journalConn) Commit() error             { return nil }
+func (c *journalConn) Rollback() error           { return nil }
+
+type journalStmt struct {
+	store *journalStore
+	query string
+}
+
+func (s *journalStmt) Close() error  { return nil }
+func (s *journalStmt) NumInput() int { return -1 }
+
+func (s *journalStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO journal_entries"):
+		key := fmt.Sprint(args[1], "/", args[2])
+		if s.store.entries[key] {
+			return nil, fmt.Errorf("Duplicate entry %s", key)
+		}
+		s.store.entries[key] = true
+	case strings.HasPrefix(s.query, "INSERT INTO journal_lines"):
+		s.store.lines++
+	default:
+		return nil, fmt.Errorf("unexpected statement %s", s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *journalStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.Contains(s.query, "FROM journal_entries") {
+		return nil, fmt.Errorf("unexpected query %s", s.query)
+	}
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	return &postedRows{posted: s.store.entries[fmt.Sprint(args[0], "/", args[1])]}, nil
+}
+
+type postedRows struct {
+	posted bool
+	done   bool
+}
+
+func (r *postedRows) Columns() []string { return []string{"posted"} }
+func (r *postedRows) Close() error      { return nil }
+
+func (r *postedRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.posted
+	return nil
+}
+
+func TestJournalLinesBalance(t *testing.T) {
+	commissionPercent := lendingRules.LenderCommissionPercent
+	lendingRules.LenderCommissionPercent = 10
+	defer func() { lendingRules.LenderCommissionPercent = commissionPercent }()
+
+	tests := []struct {
+		name      string
+		lines     []journalLine
+		wantTotal int64
+	}{
+		{
+			name:      "funding",
+			lines:     fundingLines([]string{"lender-1", "lender-2"}, []int64{3_000_000, 2_000_000}),
+			wantTotal: 5_000_000,
+		},
+		{name: "disbursement", lines: disbursementLines("borrower-1", 9_800_000, 200_000), wantTotal: 10_000_000},
+		{
+			name:      "repayment with an unallocated part",
+			lines:     repaymentLines("borrower-1", 50_000, 120_000, 833_333, 1_000),
+			wantTotal: 1_004_333,
+		},
+		{
+			name:      "distribution with commission",
+			lines:     distributionLines([]string{"lender-1", "lender-2"}, []int64{3_001, 2_000}),
+			wantTotal: 5_001,
+		},
+		{name: "distribution without lenders", lines: distributionLines(nil, nil), wantTotal: 0},
+		{name: "collection", lines: collectionLines("lender-1", 3_000_000), wantTotal: 3_000_000},
+		{name: "lender payout", lines: lenderPayoutLines("lender-1", 3_002_701), wantTotal: 3_002_701},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				total, err := journalTotal(tt.lines)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if total != tt.wantTotal {
+					t.Fatalf("got total %d, want %d", total, tt.wantTotal)
+				}
+			},
+		)
+	}
+}
+
+// TestLenderAccountsClear follows a lender from the funding to the last payout, once the borrower repaid everything the
+// platform neither owes the lender nor is owed by them
+func TestLenderAccountsClear(t *testing.T) {
+	commissionPercent := lendingRules.LenderCommissionPercent
+	lendingRules.LenderCommissionPercent = 10
+	defer func() { lendingRules.LenderCommissionPercent = commissionPercent }()
+
+	entries := [][]journalLine{
+		fundingLines([]string{"lender-1"}, []int64{1_000_000}),
+		collectionLines("lender-1", 1_000_000),
+		disbursementLines("borrower-1", 980_000, 20_000),
+		repaymentLines("borrower-1", 0, 30_000, 500_000, 0),
+		distributionLines([]string{"lender-1"}, []int64{30_000}),
+		lenderPayoutLines("lender-1", 500_000+30_000-3_000),
+		repaymentLines("borrower-1", 0, 15_000, 500_000, 0),
+		distributionLines([]string{"lender-1"}, []int64{15_000}),
+		lenderPayoutLines("lender-1", 500_000+15_000-1_500),
+	}
+	balances := map[string]int64{}
+	for _, lines := range entries {
+		_, err := journalTotal(lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			balances[line.account] += line.debit - line.credit
+		}
+	}
+	for _, account := range []string{AccountCommitmentReceivable, AccountLenderPayable, AccountBorrowerReceivable} {
+		if balances[account] != 0 {
+			t.Fatalf("%s has a balance of %d, want 0", account, balances[account])
+		}
+	}
+	//	the platform keeps the fee and the commission
+	if balances[AccountPlatformCash] != 20_000+3_000+1_500 {
+		t.Fatalf("platform_cash has a balance of %d, want %d", balances[AccountPlatformCash], 20_000+3_000+1_500)
+	}
+}
+
+func TestJournalTotalInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []journalLine
+	}{
+		{
+			name: "unbalanced",
+			lines: []journalLine{
+				{account: AccountPlatformCash, debit: 100}, {account: AccountFeeIncome, credit: 99},
+			},
+		},
+		{
+			name: "negative amount",
+			lines: []journalLine{
+				{account: AccountPlatformCash, debit: -100}, {account: AccountFeeIncome, credit: -100},
+			},
+		},
+		{
+			name: "both sides on a line",
+			lines: []journalLine{
+				{account: AccountPlatformCash, debit: 100, credit: 100},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := journalTotal(tt.lines)
+				if err == nil {
+					t.Fatal("got no error")
+				}
+			},
+		)
+	}
+}
+
+func TestPostJournalReplay(t *testing.T) {
+	store := newJournalStore()
+	db := sql.OpenDB(store)
+	defer db.Close()
+
+	post := func(referenceType string, lines []journalLine) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		err = postJournal(tx, referenceType, "bill-1", "lending-1", "test", lines)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	repayment := repaymentLines("borrower-1", 1_000, 2_000, 3_000, 0)
+	for i := 0; i < 2; i++ {
+		err := post(JournalRepayment, repayment)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.entries) != 1 || store.lines != 4 {
+		t.Fatalf("replay posted %d entries and %d lines, want 1 and 4", len(store.entries), store.lines)
+	}
+
+	//	another movement of the same bill is posted on its own
+	err := post(JournalDistribution, distributionLines([]string{"lender-1"}, []int64{2_000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.entries) != 2 || store.lines != 6 {
+		t.Fatalf("got %d entries and %d lines, want 2 and 6", len(store.entries), store.lines)
+	}
+
+	err = post(JournalFunding, []journalLine{{account: AccountPlatformCash, debit: 1}})
+	if err == nil {
+		t.Fatal("unbalanced entry was posted")
+	}
+	if len(store.entries) != 2 {
+		t.Fatalf("unbalanced entry was stored")
+	}
+}
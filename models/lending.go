@@ -1,19 +1,17 @@
 package models
 
 import (
-	"bytes"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
-	"mime/multipart"
-	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Tus1688/kim-hackathon-2023-api/database"
-	"github.com/Tus1688/kim-hackathon-2023-api/midtrans"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,76 +21,103 @@ type RegisterAsBorrower struct {
 }
 
 type LendingRequest struct {
-	RequesterUid     string  // we get this from the context
-	Amount           float64 `json:"amount" binding:"required"`
-	InterestRate     int     `json:"interest_rate" binding:"required"`
-	Tenor            int     `json:"tenor" binding:"required"`
-	Age              int     `json:"age" binding:"required"`
-	Gender           bool    `json:"gender" binding:"required"`
-	Income           float64 `json:"income" binding:"required"`
-	LastEducation    int     `json:"last_education" binding:"required"`
-	MaritalStatus    bool    `json:"marital_status" binding:"required"`
-	NumberOfChildren int     `json:"number_of_children" binding:"required"`
-	HasHouse         bool    `json:"has_house" binding:"required"`
-	KkUrl            string  `json:"kk_url" binding:"required"`
-	KtpUrl           string  `json:"ktp_url" binding:"required"`
+	Id               string         `json:"-"` // only set when re-validating a stored proposal
+	RequesterUid     string         // we get this from the context
+	Amount           float64        `json:"amount" binding:"required"`
+	InterestRate     int            `json:"-"`       // priced by the pricing grid once the proposal is scored
+	InterestMethod   string         `json:"-"`       // taken from the product when the proposal is created
+	Product          string         `json:"product"` // one of the products of the lending rules, the default when empty
+	Tenor            int            `json:"tenor" binding:"required"`
+	Age              int            `json:"age" binding:"required"`
+	Gender           *Gender        `json:"gender" binding:"required"`
+	Income           float64        `json:"income" binding:"required"`
+	LastEducation    *Education     `json:"last_education" binding:"required"`
+	MaritalStatus    *MaritalStatus `json:"marital_status" binding:"required"`
+	NumberOfChildren int            `json:"number_of_children" binding:"required"`
+	HasHouse         *HomeOwnership `json:"has_house" binding:"required"`
+	KkDocumentId     string         `json:"kk_document_id" binding:"required"`
+	KtpDocumentId    string         `json:"ktp_document_id" binding:"required"`
+}
+
+type CreateLendingResponse struct {
+	Id string `json:"id"`
 }
 
 type LendingResponse struct {
-	Id               string  `json:"id"`
-	Amount           float64 `json:"amount"`
-	InterestRate     int     `json:"interest_rate"`
-	Tenor            int     `json:"tenor"`
-	Age              int     `json:"age"`
-	Gender           string  `json:"gender"`
-	Income           float64 `json:"income"`
-	LastEducation    string  `json:"last_education"`
-	MaritalStatus    string  `json:"marital_status"`
-	NumberOfChildren int     `json:"number_of_children"`
-	HasHouse         string  `json:"has_house"`
-	KkUrl            string  `json:"kk_url"`
-	KtpUrl           string  `json:"ktp_url"`
-	Status           string  `json:"status"`
-	PaymentToken     string  `json:"payment_token,omitempty"`
-	PaymentUrl       string  `json:"payment_url,omitempty"`
-	IsPaid           bool    `json:"is_paid"`
+	Id                string        `json:"id"`
+	Amount            float64       `json:"amount"`
+	InterestRate      int           `json:"interest_rate"`
+	Tenor             int           `json:"tenor"`
+	Age               int           `json:"age"`
+	Gender            string        `json:"gender"`
+	GenderCode        Gender        `json:"gender_code"`
+	Income            float64       `json:"income"`
+	LastEducation     string        `json:"last_education"`
+	LastEducationCode Education     `json:"last_education_code"`
+	MaritalStatus     string        `json:"marital_status"`
+	MaritalStatusCode MaritalStatus `json:"marital_status_code"`
+	NumberOfChildren  int           `json:"number_of_children"`
+	HasHouse          string        `json:"has_house"`
+	HasHouseCode      HomeOwnership `json:"has_house_code"`
+	KkUrl             string        `json:"kk_url"`
+	KtpUrl            string        `json:"ktp_url"`
+	Status            string        `json:"status"`
+	PaymentToken      string        `json:"payment_token,omitempty"`
+	PaymentUrl        string        `json:"payment_url,omitempty"`
+	IsPaid            bool          `json:"is_paid"`
 }
 
 type LendingAdminResponse struct {
-	Id               string  `json:"id"`
-	UserId           string  `json:"user_id"`
-	Username         string  `json:"username"`
-	Amount           float64 `json:"amount"`
-	InterestRate     int     `json:"interest_rate"`
-	Tenor            int     `json:"tenor"`
-	Age              int     `json:"age"`
-	Gender           string  `json:"gender"`
-	Income           float64 `json:"income"`
-	LastEducation    string  `json:"last_education"`
-	MaritalStatus    string  `json:"marital_status"`
-	NumberOfChildren int     `json:"number_of_children"`
-	HasHouse         string  `json:"has_house"`
-	KkUrl            string  `json:"kk_url"`
-	KtpUrl           string  `json:"ktp_url"`
-	Status           string  `json:"status"`
-	PaymentToken     string  `json:"payment_token,omitempty"`
-	PaymentUrl       string  `json:"payment_url,omitempty"`
-	IsApproved       bool    `json:"is_approved"`
-	IsRejected       bool    `json:"is_rejected"`
+	Id                string        `json:"id"`
+	UserId            string        `json:"user_id"`
+	Username          string        `json:"username"`
+	Amount            float64       `json:"amount"`
+	InterestRate      int           `json:"interest_rate"`
+	Tenor             int           `json:"tenor"`
+	Age               int           `json:"age"`
+	Gender            string        `json:"gender"`
+	GenderCode        Gender        `json:"gender_code"`
+	Income            float64       `json:"income"`
+	LastEducation     string        `json:"last_education"`
+	LastEducationCode Education     `json:"last_education_code"`
+	MaritalStatus     string        `json:"marital_status"`
+	MaritalStatusCode MaritalStatus `json:"marital_status_code"`
+	NumberOfChildren  int           `json:"number_of_children"`
+	HasHouse          string        `json:"has_house"`
+	HasHouseCode      HomeOwnership `json:"has_house_code"`
+	KkUrl             string        `json:"kk_url"`
+	KtpUrl            string        `json:"ktp_url"`
+	Status            string        `json:"status"`
+	PaymentToken      string        `json:"payment_token,omitempty"`
+	PaymentUrl        string        `json:"payment_url,omitempty"`
+	IsApproved        bool          `json:"is_approved"`
+	IsRejected        bool          `json:"is_rejected"`
+	// PricingGridVersion is empty until the proposal is offered
+	PricingGridVersion string `json:"pricing_grid_version,omitempty"`
+	// LatestScore is nil when the proposal has never been scored
+	LatestScore *CreditScoreSummary `json:"latest_score"`
+	Guarantors  GuarantorSummary    `json:"guarantors"`
 }
 
-type LendingPredictRequest struct {
-	Age              int `json:"Age"`
-	Gender           int `json:"Gender"`
-	Income           int `json:"Income"`
-	Education        int `json:"Education"`
-	MaritalStatus    int `json:"Marital_Status"`
-	NumberOfChildren int `json:"Number_of_Children"`
-	HomeOwnership    int `json:"Home_Ownership"`
+// GuarantorSummary counts the guarantors of a proposal by status
+type GuarantorSummary struct {
+	Invited   int `json:"invited"`
+	Confirmed int `json:"confirmed"`
+	Declined  int `json:"declined"`
 }
 
-type LendingPredictResponse struct {
-	Predictions []string `json:"predictions"`
+type LendingApprovalQueueResponse struct {
+	Id                    string  `json:"id"`
+	UserId                string  `json:"user_id"`
+	Username              string  `json:"username"`
+	Amount                float64 `json:"amount"`
+	InterestRate          int     `json:"interest_rate"`
+	Tenor                 int     `json:"tenor"`
+	FirstApproverId       string  `json:"first_approver_id"`
+	FirstApproverUsername string  `json:"first_approver_username"`
+	FirstApprovedOn       string  `json:"first_approved_on"`
+	// CanConfirm is false when the requester is the first approver, as they cannot confirm their own approval
+	CanConfirm bool `json:"can_confirm"`
 }
 
 func (r *RegisterAsBorrower) Register() error {
@@ -109,70 +134,46 @@ func (r *RegisterAsBorrower) Register() error {
 	return nil
 }
 
-func UploadDocument(image multipart.File, header *multipart.FileHeader) (GoBlobResponse, error) {
-	//	Create a POST request to the image server
-	url := goBlobBaseUrl + "/file"
-	req, err := http.NewRequest("POST", url, nil)
+// Create stores the proposal waiting for its offer, the interest rate is set by the pricing grid
+func (l *LendingRequest) Create() (CreateLendingResponse, error) {
+	tx, err := database.MysqlInstance.Begin()
 	if err != nil {
-		return GoBlobResponse{}, err
+		return CreateLendingResponse{}, err
 	}
+	defer tx.Rollback()
 
-	// Set the content type to multipart/form-data
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", header.Filename)
+	err = lockBorrower(tx, l.RequesterUid)
 	if err != nil {
-		return GoBlobResponse{}, err
+		return CreateLendingResponse{}, err
 	}
-	_, err = io.Copy(part, image)
+	err = l.validateIn(tx)
 	if err != nil {
-		return GoBlobResponse{}, err
+		return CreateLendingResponse{}, err
 	}
-	err = writer.Close()
-	if err != nil {
-		return GoBlobResponse{}, err
-	}
-
-	//	pass file name
-	req.Header.Set("File-Name", header.Filename)
-	req.Header.Set("Authorization", goBlobAuthorization)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Body = io.NopCloser(body)
-
-	//	Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	kkFileName, ktpFileName, err := l.resolveDocuments()
 	if err != nil {
-		return GoBlobResponse{}, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return GoBlobResponse{}, fmt.Errorf("image server error")
+		return CreateLendingResponse{}, err
 	}
 
-	//	Extract filename
-	//  {filename: target.jpg}
-	var res GoBlobResponse
-	err = json.NewDecoder(resp.Body).Decode(&res)
+	l.InterestMethod, _ = l.interestMethod()
+	id := uuid.New().String()
+	_, err = tx.Exec(
+		`INSERT INTO lending(id, user_refer, amount, product, interest_method, tenor, age, gender, income, last_education, marital_status, number_of_children, home_ownership, kk_url, ktp_url, kk_document_refer, ktp_document_refer, status) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UUID_TO_BIN(?), UUID_TO_BIN(?), ?)`,
+		id, l.RequesterUid, l.Amount, l.productCode(), l.InterestMethod, l.Tenor, l.Age, *l.Gender, l.Income, *l.LastEducation,
+		*l.MaritalStatus, l.NumberOfChildren, *l.HasHouse, kkFileName, ktpFileName, l.KkDocumentId, l.KtpDocumentId,
+		"pending_offer",
+	)
 	if err != nil {
-		return GoBlobResponse{}, err
+		return CreateLendingResponse{}, err
 	}
-	return res, nil
-}
-
-func (l *LendingRequest) Create() error {
-	_, err := database.MysqlInstance.Exec(
-		`INSERT INTO lending(user_refer, amount, interest_rate, tenor, age, income, last_education, number_of_children, kk_url, ktp_url, status) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		l.RequesterUid, l.Amount, l.InterestRate, l.Tenor, l.Age, l.Income, l.LastEducation, l.NumberOfChildren,
-		l.KkUrl, l.KtpUrl, "pending",
-	)
+	err = tx.Commit()
 	if err != nil {
-		return err
+		return CreateLendingResponse{}, err
 	}
-	return nil
+	return CreateLendingResponse{Id: id}, nil
 }
 
-func GetLendingAsUser(uid string) ([]LendingResponse, error) {
+func GetLendingAsUser(uid string, language string) ([]LendingResponse, error) {
 	rows, err := database.MysqlInstance.Query(
 		`
 		SELECT BIN_TO_UUID(l.id),
@@ -180,27 +181,12 @@ func GetLendingAsUser(uid string) ([]LendingResponse, error) {
 			   l.interest_rate,
 			   l.tenor,
 			   l.age,
-			   CASE l.gender
-				   WHEN 0 THEN 'Laki-Laki'
-				   WHEN 1 THEN 'Perempuan'
-				   END AS GENDER,
+			   COALESCE(l.gender, 0),
 			   l.income,
-			   CASE l.last_education
-				   WHEN 0 THEN 'SMA'
-				   WHEN 1 THEN 'D3'
-				   WHEN 2 THEN 'S1'
-				   WHEN 3 THEN 'S2'
-				   WHEN 4 THEN 'S3'
-				   END AS last_education,
-			   CASE l.marital_status
-				   WHEN 0 THEN 'Lajang'
-				   WHEN 1 THEN 'Menikah'
-				   END AS marital_status,
+			   COALESCE(l.last_education, 0),
+			   COALESCE(l.marital_status, 0),
 			   l.number_of_children,
-			   CASE l.home_ownership
-				   WHEN 0 THEN 'Menyewa'
-				   WHEN 1 THEN 'Memiliki'
-				   END AS home_ownership,
+			   COALESCE(l.home_ownership, 0),
 				l.kk_url, l.ktp_url,
 		        l.status, COALESCE(l.payment_token, ''), COALESCE(l.payment_url, ''), l.is_paid
 		FROM lending l
@@ -217,20 +203,247 @@ func GetLendingAsUser(uid string) ([]LendingResponse, error) {
 	for rows.Next() {
 		var temp LendingResponse
 		err := rows.Scan(
-			&temp.Id, &temp.Amount, &temp.InterestRate, &temp.Tenor, &temp.Age, &temp.Gender, &temp.Income,
-			&temp.LastEducation, &temp.MaritalStatus, &temp.NumberOfChildren, &temp.HasHouse, &temp.KkUrl, &temp.KtpUrl,
+			&temp.Id, &temp.Amount, &temp.InterestRate, &temp.Tenor, &temp.Age, &temp.GenderCode, &temp.Income,
+			&temp.LastEducationCode, &temp.MaritalStatusCode, &temp.NumberOfChildren, &temp.HasHouseCode, &temp.KkUrl,
+			&temp.KtpUrl,
 			&temp.Status,
 			&temp.PaymentToken, &temp.PaymentUrl, &temp.IsPaid,
 		)
 		if err != nil {
 			return nil, err
 		}
+		temp.Gender = temp.GenderCode.Label(language)
+		temp.LastEducation = temp.LastEducationCode.Label(language)
+		temp.MaritalStatus = temp.MaritalStatusCode.Label(language)
+		temp.HasHouse = temp.HasHouseCode.Label(language)
 		res = append(res, temp)
 	}
 	return res, nil
 }
 
-func GetLendingAsAdmin() ([]LendingAdminResponse, error) {
+// LendingAdminFilter narrows GetLendingAsAdmin, zero values are not applied
+type LendingAdminFilter struct {
+	Statuses  []string
+	From      time.Time // inclusive
+	To        time.Time // inclusive, the whole day is included
+	MinAmount float64
+	MaxAmount float64
+	// ScoreBand is the prediction of the latest credit score, or "unscored"
+	ScoreBand string
+	// Search matches part of the username of the borrower
+	Search string
+	// Sort is newest, oldest, amount_desc, amount_asc or risk
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+type LendingAdminPage struct {
+	Data []LendingAdminResponse `json:"data"`
+	// NextCursor is empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// StatusCounts ignores the status filter so every tab can show its count
+	StatusCounts map[string]int `json:"status_counts"`
+	Total        int            `json:"total"`
+}
+
+const (
+	defaultAdminPageSize = 20
+	maxAdminPageSize     = 100
+)
+
+// sortKey is one column of the keyset used by the cursor, its values are compared as returned by the query
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+var idSortKey = sortKey{expr: "BIN_TO_UUID(l.id)", desc: true}
+
+var createdSortKey = sortKey{expr: "DATE_FORMAT(l.created_at, '%Y-%m-%d %H:%i:%s')", desc: true}
+
+// adminLendingSorts always end with the id so the cursor never skips lending sharing the same values
+var adminLendingSorts = map[string][]sortKey{
+	"newest":      {createdSortKey, idSortKey},
+	"oldest":      {{createdSortKey.expr, false}, {idSortKey.expr, false}},
+	"amount_desc": {{"l.amount", true}, idSortKey},
+	"amount_asc":  {{"l.amount", false}, {idSortKey.expr, false}},
+	//	the riskiest latest score first and the unscored lending last
+	"risk": {
+		{
+			"CASE WHEN cs.id IS NULL THEN 4 WHEN cs.prediction = 'Low' THEN 0 WHEN cs.prediction = 'Average' THEN 1 WHEN cs.prediction = 'High' THEN 2 ELSE 3 END",
+			false,
+		},
+		{"COALESCE(cs.score, 0)", false},
+		createdSortKey,
+		idSortKey,
+	},
+}
+
+type adminCursor struct {
+	Sort string   `json:"sort"`
+	Keys []string `json:"keys"`
+}
+
+// keysetCondition returns the condition selecting the rows after the cursor, e.g. (a < ?) OR (a = ? AND b < ?)
+func keysetCondition(keys []sortKey, values []string) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for i, key := range keys {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, keys[j].expr+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > "
+		if key.desc {
+			op = " < "
+		}
+		ands = append(ands, key.expr+op+"?")
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// likeEscaper escapes the wildcards of LIKE with its default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike makes the search term match literally inside a LIKE pattern
+func escapeLike(term string) string {
+	return likeEscaper.Replace(term)
+}
+
+// where builds the conditions of the filter, the status filter is returned apart as the status counts ignore it
+func (f *LendingAdminFilter) where() (string, []interface{}, string, []interface{}) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "l.created_at >= ?")
+		args = append(args, f.From.Format("2006-01-02"))
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "l.created_at < ?")
+		args = append(args, f.To.AddDate(0, 0, 1).Format("2006-01-02"))
+	}
+	if f.MinAmount > 0 {
+		conditions = append(conditions, "l.amount >= ?")
+		args = append(args, f.MinAmount)
+	}
+	if f.MaxAmount > 0 {
+		conditions = append(conditions, "l.amount <= ?")
+		args = append(args, f.MaxAmount)
+	}
+	switch f.ScoreBand {
+	case "":
+	case "unscored":
+		conditions = append(conditions, "cs.id IS NULL")
+	default:
+		conditions = append(conditions, "cs.prediction = ?")
+		args = append(args, f.ScoreBand)
+	}
+	if f.Search != "" {
+		conditions = append(conditions, "u.username LIKE ?")
+		args = append(args, "%"+escapeLike(f.Search)+"%")
+	}
+
+	statusCondition := "TRUE"
+	var statusArgs []interface{}
+	if len(f.Statuses) > 0 {
+		statusCondition = "l.status IN (?" + strings.Repeat(", ?", len(f.Statuses)-1) + ")"
+		for _, status := range f.Statuses {
+			statusArgs = append(statusArgs, status)
+		}
+	}
+	return strings.Join(conditions, " AND "), args, statusCondition, statusArgs
+}
+
+const adminLendingFrom = `
+	FROM lending l
+	INNER JOIN users u ON l.user_refer = u.id
+	LEFT JOIN pricing_grids g ON g.id = l.pricing_grid_refer
+	LEFT JOIN credit_scores cs ON cs.id = (
+	    SELECT cs2.id FROM credit_scores cs2 WHERE cs2.lending_refer = l.id ORDER BY cs2.created_at DESC LIMIT 1
+	)
+`
+
+// GetLendingAsAdmin returns one page of the lending matching the filter with the count of every status, the next page
+// is requested with the returned cursor. The attributes are labelled in language
+func GetLendingAsAdmin(f LendingAdminFilter, language string) (LendingAdminPage, error) {
+	if f.Sort == "" {
+		f.Sort = "newest"
+	}
+	keys, ok := adminLendingSorts[f.Sort]
+	if !ok {
+		return LendingAdminPage{}, fmt.Errorf("invalid sort")
+	}
+	if f.Limit <= 0 {
+		f.Limit = defaultAdminPageSize
+	}
+	if f.Limit > maxAdminPageSize {
+		f.Limit = maxAdminPageSize
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return LendingAdminPage{}, fmt.Errorf("invalid date range")
+	}
+	if f.MaxAmount > 0 && f.MaxAmount < f.MinAmount {
+		return LendingAdminPage{}, fmt.Errorf("invalid amount range")
+	}
+
+	where, args, statusWhere, statusArgs := f.where()
+	res := LendingAdminPage{Data: []LendingAdminResponse{}, StatusCounts: map[string]int{}}
+	countRows, err := database.MysqlInstance.Query(
+		`SELECT l.status, COUNT(*) `+adminLendingFrom+` WHERE `+where+` GROUP BY l.status`, args...,
+	)
+	if err != nil {
+		return LendingAdminPage{}, err
+	}
+	defer countRows.Close()
+	for countRows.Next() {
+		var status string
+		var count int
+		err := countRows.Scan(&status, &count)
+		if err != nil {
+			return LendingAdminPage{}, err
+		}
+		res.StatusCounts[status] = count
+	}
+	if len(f.Statuses) == 0 {
+		for _, count := range res.StatusCounts {
+			res.Total += count
+		}
+	}
+	for _, status := range f.Statuses {
+		res.Total += res.StatusCounts[status]
+	}
+
+	where += " AND " + statusWhere
+	args = append(args, statusArgs...)
+	if f.Cursor != "" {
+		var cursor adminCursor
+		raw, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+		if err == nil {
+			err = json.Unmarshal(raw, &cursor)
+		}
+		if err != nil || cursor.Sort != f.Sort || len(cursor.Keys) != len(keys) {
+			return LendingAdminPage{}, fmt.Errorf("invalid cursor")
+		}
+		condition, cursorArgs := keysetCondition(keys, cursor.Keys)
+		where += " AND " + condition
+		args = append(args, cursorArgs...)
+	}
+	var selectKeys, orderBy []string
+	for _, key := range keys {
+		selectKeys = append(selectKeys, key.expr)
+		if key.desc {
+			orderBy = append(orderBy, key.expr+" DESC")
+		} else {
+			orderBy = append(orderBy, key.expr)
+		}
+	}
+	//	one more row than the page tells whether there is a next page
+	args = append(args, f.Limit+1)
+
 	rows, err := database.MysqlInstance.Query(
 		`
 		SELECT BIN_TO_UUID(l.id),
@@ -240,171 +453,224 @@ func GetLendingAsAdmin() ([]LendingAdminResponse, error) {
 			   l.interest_rate,
 			   l.tenor,
 			   l.age,
-			   CASE l.gender
-				   WHEN 0 THEN 'Laki-Laki'
-				   WHEN 1 THEN 'Perempuan'
-				   END AS GENDER,
+			   COALESCE(l.gender, 0),
 			   l.income,
-			   CASE l.last_education
-				   WHEN 0 THEN 'SMA'
-				   WHEN 1 THEN 'D3'
-				   WHEN 2 THEN 'S1'
-				   WHEN 3 THEN 'S2'
-				   WHEN 4 THEN 'S3'
-				   END AS last_education,
-			   CASE l.marital_status
-				   WHEN 0 THEN 'Lajang'
-				   WHEN 1 THEN 'Menikah'
-				   END AS marital_status,
+			   COALESCE(l.last_education, 0),
+			   COALESCE(l.marital_status, 0),
 			   l.number_of_children,
-			   CASE l.home_ownership
-				   WHEN 0 THEN 'Menyewa'
-				   WHEN 1 THEN 'Memiliki'
-				   END AS home_ownership,
+			   COALESCE(l.home_ownership, 0),
 				l.kk_url, l.ktp_url,
-		        l.status, COALESCE(l.payment_token, ''), COALESCE(l.payment_url, ''), is_approved, is_rejected
-		FROM lending l
-		INNER JOIN users u ON l.user_refer = u.id
-		ORDER BY l.created_at DESC
-	`,
+		        l.status, COALESCE(l.payment_token, ''), COALESCE(l.payment_url, ''), is_approved, is_rejected,
+		       COALESCE(g.version, ''),
+		       BIN_TO_UUID(cs.id), cs.prediction, cs.model_version, cs.engine, cs.score, cs.created_at,
+		       (SELECT COUNT(*) FROM lending_guarantors lg WHERE lg.lending_refer = l.id AND lg.status = 'invited'),
+		       (SELECT COUNT(*) FROM lending_guarantors lg WHERE lg.lending_refer = l.id AND lg.status = 'confirmed'),
+		       (SELECT COUNT(*) FROM lending_guarantors lg WHERE lg.lending_refer = l.id AND lg.status = 'declined'),
+		       `+strings.Join(selectKeys, ", ")+adminLendingFrom+`
+		WHERE `+where+`
+		ORDER BY `+strings.Join(orderBy, ", ")+`
+		LIMIT ?`, args...,
 	)
 	if err != nil {
-		return nil, err
+		return LendingAdminPage{}, err
 	}
 
 	defer rows.Close()
-	var res []LendingAdminResponse
+	var lastKeys []string
 	for rows.Next() {
+		if len(res.Data) == f.Limit {
+			cursor, err := json.Marshal(adminCursor{Sort: f.Sort, Keys: lastKeys})
+			if err != nil {
+				return LendingAdminPage{}, err
+			}
+			res.NextCursor = base64.RawURLEncoding.EncodeToString(cursor)
+			break
+		}
 		var temp LendingAdminResponse
-		err := rows.Scan(
+		var scoreId, prediction, modelVersion, engine, scoredOn sql.NullString
+		var score sql.NullFloat64
+		keyValues := make([]string, len(keys))
+		dest := []interface{}{
 			&temp.Id, &temp.UserId, &temp.Username, &temp.Amount, &temp.InterestRate, &temp.Tenor, &temp.Age,
-			&temp.Gender, &temp.Income, &temp.LastEducation, &temp.MaritalStatus, &temp.NumberOfChildren,
-			&temp.HasHouse, &temp.KkUrl, &temp.KtpUrl, &temp.Status, &temp.PaymentToken, &temp.PaymentUrl,
-			&temp.IsApproved, &temp.IsRejected,
-		)
+			&temp.GenderCode, &temp.Income, &temp.LastEducationCode, &temp.MaritalStatusCode, &temp.NumberOfChildren,
+			&temp.HasHouseCode, &temp.KkUrl, &temp.KtpUrl, &temp.Status, &temp.PaymentToken, &temp.PaymentUrl,
+			&temp.IsApproved, &temp.IsRejected, &temp.PricingGridVersion, &scoreId, &prediction, &modelVersion, &engine,
+			&score, &scoredOn, &temp.Guarantors.Invited, &temp.Guarantors.Confirmed, &temp.Guarantors.Declined,
+		}
+		for i := range keyValues {
+			dest = append(dest, &keyValues[i])
+		}
+		err := rows.Scan(dest...)
 		if err != nil {
-			return nil, err
+			return LendingAdminPage{}, err
 		}
-		res = append(res, temp)
+		if scoreId.Valid {
+			temp.LatestScore = &CreditScoreSummary{
+				Id:           scoreId.String,
+				Prediction:   prediction.String,
+				ModelVersion: modelVersion.String,
+				Engine:       engine.String,
+				CreatedOn:    scoredOn.String,
+			}
+			if score.Valid {
+				temp.LatestScore.Score = &score.Float64
+			}
+		}
+		temp.Gender = temp.GenderCode.Label(language)
+		temp.LastEducation = temp.LastEducationCode.Label(language)
+		temp.MaritalStatus = temp.MaritalStatusCode.Label(language)
+		temp.HasHouse = temp.HasHouseCode.Label(language)
+		res.Data = append(res.Data, temp)
+		lastKeys = keyValues
 	}
 	return res, nil
 }
 
-func PredictCreditScore(id string) (LendingPredictResponse, error) {
-	var req LendingPredictRequest
-	err := database.MysqlInstance.QueryRow(
-		`
-	SELECT l.age, l.gender, FLOOR(l.income), l.last_education, l.marital_status, l.number_of_children, l.home_ownership FROM lending l
-	WHERE l.id = UUID_TO_BIN(?)
-	`, id,
-	).Scan(
-		&req.Age, &req.Gender, &req.Income, &req.Education, &req.MaritalStatus, &req.NumberOfChildren,
-		&req.HomeOwnership,
-	)
+type ApprovalRequest struct {
+	// CreditScoreId is the score the approver reviewed, it must belong to the lending
+	CreditScoreId string `json:"credit_score_id" binding:"required"`
+}
+
+// Approve records the approval of approverUid, lending above dualApprovalThreshold is only approved once a second and
+// different approver confirms it
+func (a *ApprovalRequest) Approve(id string, approverUid string) error {
+	tx, err := database.MysqlInstance.Begin()
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return LendingPredictResponse{}, fmt.Errorf("order id not found")
-		}
-		return LendingPredictResponse{}, err
+		return err
 	}
+	defer tx.Rollback()
 
-	url := flaskMLBaseUrl + "/predict"
-	body, err := json.Marshal(req)
+	var amount float64
+	err = tx.QueryRow(
+		`SELECT amount FROM lending WHERE id = UUID_TO_BIN(?) AND status IN ('pending', 'awaiting_second_approval') AND is_approved = FALSE AND is_rejected = FALSE FOR UPDATE`,
+		id,
+	).Scan(&amount)
 	if err != nil {
-		return LendingPredictResponse{}, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("lending not found")
+		}
+		return err
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	var approvals int
+	var selfApproved bool
+	err = tx.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(approver_refer = UUID_TO_BIN(?)), 0) FROM lending_approvals WHERE lending_refer = UUID_TO_BIN(?)`,
+		approverUid, id,
+	).Scan(&approvals, &selfApproved)
 	if err != nil {
-		return LendingPredictResponse{}, err
+		return err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return LendingPredictResponse{}, fmt.Errorf("ml server error")
+	if selfApproved {
+		return fmt.Errorf("cannot confirm own approval")
 	}
-
-	var res LendingPredictResponse
-	err = json.NewDecoder(resp.Body).Decode(&res)
+	var scoreExists bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM credit_scores WHERE id = UUID_TO_BIN(?) AND lending_refer = UUID_TO_BIN(?))`,
+		a.CreditScoreId, id,
+	).Scan(&scoreExists)
 	if err != nil {
-		return LendingPredictResponse{}, err
+		return err
 	}
-	return res, nil
-}
-
-func ApproveLending(id string) error {
-	res, err := database.MysqlInstance.Exec(
-		`UPDATE lending SET status = 'approved', is_approved = TRUE WHERE id = UUID_TO_BIN(?) AND is_rejected = FALSE`,
-		id,
-	)
+	fieldErrors, err := validateLoanToValue(id, amount)
 	if err != nil {
 		return err
 	}
-	if affected, _ := res.RowsAffected(); affected == 0 {
-		return fmt.Errorf("not found")
+	if !scoreExists {
+		fieldErrors = append(
+			fieldErrors, jsonutil.FieldError{
+				Field:   "credit_score_id",
+				Code:    "not_found",
+				Message: "credit_score_id does not refer to a credit score of the lending",
+			},
+		)
+	}
+	if len(fieldErrors) > 0 {
+		return fieldErrors
 	}
-	return nil
-}
 
-func RejectLending(id string) error {
-	res, err := database.MysqlInstance.Exec(
-		`UPDATE lending SET status = 'rejected', is_rejected = TRUE WHERE id = UUID_TO_BIN(?) AND is_approved = FALSE`,
-		id,
+	//	link the approval to the score the approver was looking at
+	_, err = tx.Exec(
+		`INSERT INTO lending_approvals (lending_refer, approver_refer, credit_score_refer) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), UUID_TO_BIN(?))`,
+		id, approverUid, a.CreditScoreId,
 	)
 	if err != nil {
 		return err
 	}
-	if affected, _ := res.RowsAffected(); affected == 0 {
-		return fmt.Errorf("not found")
+
+	if dualApprovalThreshold > 0 && amount > dualApprovalThreshold && approvals == 0 {
+		_, err = tx.Exec(`UPDATE lending SET status = 'awaiting_second_approval' WHERE id = UUID_TO_BIN(?)`, id)
+	} else {
+		_, err = tx.Exec(`UPDATE lending SET status = 'approved', is_approved = TRUE WHERE id = UUID_TO_BIN(?)`, id)
 	}
-	return nil
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func MakePayment(id string) (midtrans.ResponseSnap, error) {
-	var exits int
-	err := database.MysqlInstance.QueryRow(
-		`SELECT 1 FROM lending WHERE id = UUID_TO_BIN(?) AND is_approved = TRUE AND payment_token IS NULL`, id,
-	).Scan(&exits)
+// GetLendingApprovalQueue returns the lending that has been approved once and is waiting for a second approver
+func GetLendingApprovalQueue(uid string) ([]LendingApprovalQueueResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(l.id),
+		       BIN_TO_UUID(l.user_refer),
+		       u.username,
+		       l.amount,
+		       l.interest_rate,
+		       l.tenor,
+		       BIN_TO_UUID(la.approver_refer),
+		       a.username,
+		       la.created_at,
+		       la.approver_refer != UUID_TO_BIN(?)
+		FROM lending l
+		INNER JOIN users u ON l.user_refer = u.id
+		INNER JOIN lending_approvals la ON la.lending_refer = l.id
+		INNER JOIN users a ON la.approver_refer = a.id
+		WHERE l.status = 'awaiting_second_approval' AND l.is_approved = FALSE AND l.is_rejected = FALSE
+		ORDER BY la.created_at
+	`, uid,
+	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return midtrans.ResponseSnap{}, fmt.Errorf("lending not found")
-		}
-		return midtrans.ResponseSnap{}, err
-	}
-	if exits == 0 {
-		return midtrans.ResponseSnap{}, fmt.Errorf("lending not found")
+		return nil, err
 	}
 
-	var transDetails midtrans.TransactionDetails
-	transDetails.OrderId = midtrans.BaseOrderId + "-" + id
-	err = database.MysqlInstance.QueryRow("SELECT FLOOR(amount * (100 + interest_rate) / 100) FROM lending").Scan(&transDetails.GrossAmount)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return midtrans.ResponseSnap{}, fmt.Errorf("lending not found")
+	defer rows.Close()
+	var res []LendingApprovalQueueResponse
+	for rows.Next() {
+		var temp LendingApprovalQueueResponse
+		err := rows.Scan(
+			&temp.Id, &temp.UserId, &temp.Username, &temp.Amount, &temp.InterestRate, &temp.Tenor,
+			&temp.FirstApproverId, &temp.FirstApproverUsername, &temp.FirstApprovedOn, &temp.CanConfirm,
+		)
+		if err != nil {
+			return nil, err
 		}
-		return midtrans.ResponseSnap{}, err
+		res = append(res, temp)
 	}
+	return res, nil
+}
 
-	var snapReq midtrans.RequestSnap
-	snapReq.TransactionDetails = transDetails
-	snapReq.Expiry = midtrans.Expiry{
-		//	StartTime: will be time.Now() utc to string with format "2020-06-30 15:07:00 -0700"
-		StartTime: time.Now().Format("2006-01-02 15:04:05 -0700"),
-		Unit:      "day",
-		Duration:  30,
+func RejectLending(id string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	res, err := snapReq.CreatePayment()
+	res, err := tx.Exec(
+		`UPDATE lending SET status = 'rejected', is_rejected = TRUE WHERE id = UUID_TO_BIN(?) AND is_approved = FALSE AND status != 'cancelled'`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("not found")
+	}
+	err = releaseCollateral(tx, id)
 	if err != nil {
-		return midtrans.ResponseSnap{}, err
+		return err
 	}
-	go func() {
-		_, err := database.MysqlInstance.Exec(
-			"UPDATE lending SET payment_token = ?, payment_url = ? WHERE id = UUID_TO_BIN(?)", res.Token,
-			res.RedirectUrl, id,
-		)
-		if err != nil {
-			log.Print(err)
-		}
-	}()
-	return res, nil
+	return tx.Commit()
 }
@@ -0,0 +1,21 @@
+package models
+
+import "testing"
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		term string
+		want string
+	}{
+		{term: "budi", want: "budi"},
+		{term: "100%", want: `100\%`},
+		{term: "budi_santoso", want: `budi\_santoso`},
+		{term: `a\b`, want: `a\\b`},
+		{term: `%_\`, want: `\%\_\\`},
+	}
+	for _, tt := range tests {
+		if got := escapeLike(tt.term); got != tt.want {
+			t.Fatalf("escapeLike(%q) = %q, want %q", tt.term, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,17 @@
 package models
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
 
 var goBlobBaseUrl string
 var goBlobAuthorization string
 
-var flaskMLBaseUrl string
+// dualApprovalThreshold is the lending amount above which an approval has to be confirmed by a second approver,
+// 0 means every lending only need a single approval
+var dualApprovalThreshold float64
 
 func InitializeGoBlobBaseUrl() {
 	goBlobBaseUrl = os.Getenv("GO_BLOB_BASE_URL")
@@ -14,6 +20,15 @@ func InitializeGoBlobAuthorization() {
 	goBlobAuthorization = os.Getenv("GO_BLOB_AUTHORIZATION")
 }
 
-func InitializeFlaskMLBaseUrl() {
-	flaskMLBaseUrl = os.Getenv("FLASK_ML_BASE_URL")
+func InitializeDualApprovalThreshold() error {
+	value := os.Getenv("LENDING_DUAL_APPROVAL_THRESHOLD")
+	if value == "" {
+		return nil
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold < 0 {
+		return fmt.Errorf("invalid LENDING_DUAL_APPROVAL_THRESHOLD")
+	}
+	dualApprovalThreshold = threshold
+	return nil
 }
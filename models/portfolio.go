@@ -0,0 +1,303 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+)
+
+// maxPortfolioMonths bounds the number of month ends the portfolio-at-risk query is evaluated on
+const maxPortfolioMonths = 60
+
+type PortfolioMetrics struct {
+	// Month is only set when grouped by month, From and To are clipped to the requested range
+	Month             string `json:"month,omitempty"`
+	From              string `json:"from"`
+	To                string `json:"to"`
+	DisbursedCount    int    `json:"disbursed_count"`
+	DisbursedVolume   int64  `json:"disbursed_volume"`
+	AverageTicketSize int64  `json:"average_ticket_size"`
+	CollectedInterest int64  `json:"collected_interest"`
+	// the metrics below are measured at the end of To
+	OutstandingPrincipal int64 `json:"outstanding_principal"`
+	// ParX is the percentage of the outstanding principal of lending with an installment X or more days past due
+	Par1  float64 `json:"par_1"`
+	Par30 float64 `json:"par_30"`
+	Par60 float64 `json:"par_60"`
+	Par90 float64 `json:"par_90"`
+	// DefaultRate is the percentage of the lending disbursed so far that is 90 or more days past due
+	DefaultRate float64 `json:"default_rate"`
+}
+
+// portfolioFlow is what happened during one month
+type portfolioFlow struct {
+	disbursedCount     int
+	disbursedVolume    int64
+	collectedInterest  int64
+	collectedPrincipal int64
+}
+
+// portfolioRisk is the principal at risk at the end of a day
+type portfolioRisk struct {
+	par1, par30, par60, par90 int64
+	defaulted                 int
+}
+
+func percentage(part int64, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return math.Round(float64(part)*10000/float64(total)) / 100
+}
+
+// portfolioRange defaults to the last 12 months up to today, to is inclusive
+func portfolioRange(from time.Time, to time.Time) (time.Time, time.Time, error) {
+	if to.IsZero() {
+		to = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	if from.IsZero() {
+		from = time.Date(to.Year(), to.Month()-11, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date range")
+	}
+	if monthIndex(to)-monthIndex(from) >= maxPortfolioMonths {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date range, at most %d months", maxPortfolioMonths)
+	}
+	return from, to, nil
+}
+
+func monthIndex(t time.Time) int {
+	return t.Year()*12 + int(t.Month()) - 1
+}
+
+// getPortfolioFlows returns the flows of every month of the range, keyed by YYYY-MM, and the disbursed count, volume
+// and collected principal before from
+func getPortfolioFlows(from time.Time, end time.Time) (map[string]portfolioFlow, portfolioFlow, error) {
+	flows := map[string]portfolioFlow{}
+	var before portfolioFlow
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT (SELECT COUNT(*) FROM lending WHERE disbursed_at < ?),
+		       (SELECT COALESCE(SUM(ROUND(amount)), 0) FROM lending WHERE disbursed_at < ?),
+		       (SELECT COALESCE(SUM(allocated_principal), 0) FROM bill WHERE lending_refer IS NOT NULL AND paid_at < ?)
+	`, from, from, from,
+	).Scan(&before.disbursedCount, &before.disbursedVolume, &before.collectedPrincipal)
+	if err != nil {
+		return nil, portfolioFlow{}, err
+	}
+
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT DATE_FORMAT(disbursed_at, '%Y-%m') AS month, COUNT(*), COALESCE(SUM(ROUND(amount)), 0)
+		FROM lending
+		WHERE disbursed_at >= ? AND disbursed_at < ?
+		GROUP BY month
+	`, from, end,
+	)
+	if err != nil {
+		return nil, portfolioFlow{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var month string
+		var flow portfolioFlow
+		err := rows.Scan(&month, &flow.disbursedCount, &flow.disbursedVolume)
+		if err != nil {
+			return nil, portfolioFlow{}, err
+		}
+		flows[month] = flow
+	}
+
+	rows, err = database.MysqlInstance.Query(
+		`
+		SELECT DATE_FORMAT(paid_at, '%Y-%m') AS month, COALESCE(SUM(allocated_interest), 0),
+		       COALESCE(SUM(allocated_principal), 0)
+		FROM bill
+		WHERE lending_refer IS NOT NULL AND paid_at >= ? AND paid_at < ?
+		GROUP BY month
+	`, from, end,
+	)
+	if err != nil {
+		return nil, portfolioFlow{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var month string
+		var interest, principal int64
+		err := rows.Scan(&month, &interest, &principal)
+		if err != nil {
+			return nil, portfolioFlow{}, err
+		}
+		flow := flows[month]
+		flow.collectedInterest = interest
+		flow.collectedPrincipal = principal
+		flows[month] = flow
+	}
+	return flows, before, nil
+}
+
+// getPortfolioRisk measures the principal at risk at the end of every day in asOf. Only installments due before the
+// day and not settled by its end are past due, the principal of a lending is what has not been collected by then
+func getPortfolioRisk(asOf []time.Time) (map[string]portfolioRisk, error) {
+	days := make([]string, len(asOf))
+	args := make([]interface{}, len(asOf))
+	for i, day := range asOf {
+		days[i] = "SELECT CAST(? AS DATE) AS as_of"
+		args[i] = day.Format("2006-01-02")
+	}
+
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT DATE_FORMAT(o.as_of, '%Y-%m-%d'),
+		       COALESCE(SUM(IF(o.dpd >= 1, o.outstanding, 0)), 0),
+		       COALESCE(SUM(IF(o.dpd >= 30, o.outstanding, 0)), 0),
+		       COALESCE(SUM(IF(o.dpd >= 60, o.outstanding, 0)), 0),
+		       COALESCE(SUM(IF(o.dpd >= 90, o.outstanding, 0)), 0),
+		       COUNT(IF(o.dpd >= 90, 1, NULL))
+		FROM (
+		    SELECT d.as_of,
+		           DATEDIFF(d.as_of, MIN(i.due_date)) AS dpd,
+		           ROUND(MAX(l.amount)) - COALESCE((
+		               SELECT SUM(b.allocated_principal)
+		               FROM bill b
+		               WHERE b.lending_refer = i.lending_refer AND b.paid_at < d.as_of + INTERVAL 1 DAY
+		           ), 0) AS outstanding
+		    FROM (`+strings.Join(days, " UNION ALL ")+`) d
+		    INNER JOIN lending_installments i ON i.due_date < d.as_of
+		        AND (i.is_paid = FALSE OR i.paid_at >= d.as_of + INTERVAL 1 DAY)
+		    INNER JOIN lending l ON l.id = i.lending_refer
+		    GROUP BY d.as_of, i.lending_refer
+		) o
+		GROUP BY o.as_of
+	`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := map[string]portfolioRisk{}
+	for rows.Next() {
+		var day string
+		var risk portfolioRisk
+		err := rows.Scan(&day, &risk.par1, &risk.par30, &risk.par60, &risk.par90, &risk.defaulted)
+		if err != nil {
+			return nil, err
+		}
+		res[day] = risk
+	}
+	return res, nil
+}
+
+// getPortfolioMetrics computes the metrics of every bucket, a bucket is a calendar month clipped to the range or the
+// whole range when monthly is false
+func getPortfolioMetrics(from time.Time, to time.Time, monthly bool) ([]PortfolioMetrics, error) {
+	from, to, err := portfolioRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	flows, before, err := getPortfolioFlows(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := portfolioBuckets(from, to, monthly)
+	asOf := make([]time.Time, len(buckets))
+	for i, bucket := range buckets {
+		asOf[i] = bucket[1]
+	}
+	risks, err := getPortfolioRisk(asOf)
+	if err != nil {
+		return nil, err
+	}
+	return portfolioMetrics(buckets, monthly, flows, before, risks), nil
+}
+
+// portfolioBuckets splits the range into calendar months clipped to it, or returns the whole range when monthly is
+// false
+func portfolioBuckets(from time.Time, to time.Time, monthly bool) [][2]time.Time {
+	if !monthly {
+		return [][2]time.Time{{from, to}}
+	}
+	var buckets [][2]time.Time
+	for start := from; !start.After(to); {
+		next := nextMonth(start)
+		end := next.AddDate(0, 0, -1)
+		if end.After(to) {
+			end = to
+		}
+		buckets = append(buckets, [2]time.Time{start, end})
+		start = next
+	}
+	return buckets
+}
+
+// nextMonth returns the first day of the month after t
+func nextMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// portfolioMetrics sums the monthly flows into every bucket and measures the risk at its end, before holds the flows
+// that happened before the first bucket
+func portfolioMetrics(
+	buckets [][2]time.Time, monthly bool, flows map[string]portfolioFlow, before portfolioFlow,
+	risks map[string]portfolioRisk,
+) []PortfolioMetrics {
+	res := make([]PortfolioMetrics, len(buckets))
+	if len(buckets) == 0 {
+		return res
+	}
+	cumulative := before
+	month := buckets[0][0]
+	for i, bucket := range buckets {
+		metrics := PortfolioMetrics{From: bucket[0].Format("2006-01-02"), To: bucket[1].Format("2006-01-02")}
+		if monthly {
+			metrics.Month = bucket[0].Format("2006-01")
+		}
+		//	the flows are grouped by month in sql, a bucket sums every month it covers
+		for ; monthIndex(month) <= monthIndex(bucket[1]); month = nextMonth(month) {
+			flow := flows[month.Format("2006-01")]
+			metrics.DisbursedCount += flow.disbursedCount
+			metrics.DisbursedVolume += flow.disbursedVolume
+			metrics.CollectedInterest += flow.collectedInterest
+			cumulative.disbursedCount += flow.disbursedCount
+			cumulative.disbursedVolume += flow.disbursedVolume
+			cumulative.collectedPrincipal += flow.collectedPrincipal
+		}
+		if metrics.DisbursedCount > 0 {
+			metrics.AverageTicketSize = metrics.DisbursedVolume / int64(metrics.DisbursedCount)
+		}
+		metrics.setRisk(cumulative, risks[metrics.To])
+		res[i] = metrics
+	}
+	return res
+}
+
+// setRisk sets the outstanding principal from the cumulative flows up to the end of the bucket and the ratios at risk
+// of it
+func (m *PortfolioMetrics) setRisk(cumulative portfolioFlow, risk portfolioRisk) {
+	m.OutstandingPrincipal = cumulative.disbursedVolume - cumulative.collectedPrincipal
+	m.Par1 = percentage(risk.par1, m.OutstandingPrincipal)
+	m.Par30 = percentage(risk.par30, m.OutstandingPrincipal)
+	m.Par60 = percentage(risk.par60, m.OutstandingPrincipal)
+	m.Par90 = percentage(risk.par90, m.OutstandingPrincipal)
+	m.DefaultRate = percentage(int64(risk.defaulted), int64(cumulative.disbursedCount))
+}
+
+// GetPortfolioSummary returns the portfolio metrics of the whole range, zero dates default to the last 12 months
+func GetPortfolioSummary(from time.Time, to time.Time) (PortfolioMetrics, error) {
+	res, err := getPortfolioMetrics(from, to, false)
+	if err != nil {
+		return PortfolioMetrics{}, err
+	}
+	return res[0], nil
+}
+
+// GetPortfolioMonthly returns the portfolio metrics of every month of the range
+func GetPortfolioMonthly(from time.Time, to time.Time) ([]PortfolioMetrics, error) {
+	return getPortfolioMetrics(from, to, true)
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestNextMonth(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{name: "first of the month", t: date(2023, 1, 1), want: date(2023, 2, 1)},
+		{name: "end of a long month", t: date(2023, 1, 31), want: date(2023, 2, 1)},
+		{name: "end of the year", t: date(2023, 12, 15), want: date(2024, 1, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got := nextMonth(tt.t)
+				if !got.Equal(tt.want) {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestPortfolioBuckets(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    time.Time
+		to      time.Time
+		monthly bool
+		want    [][2]time.Time
+	}{
+		{
+			name: "whole range", from: date(2023, 1, 15), to: date(2023, 3, 10),
+			want: [][2]time.Time{{date(2023, 1, 15), date(2023, 3, 10)}},
+		},
+		{
+			name: "months clipped to the range", from: date(2023, 1, 15), to: date(2023, 3, 10), monthly: true,
+			want: [][2]time.Time{
+				{date(2023, 1, 15), date(2023, 1, 31)},
+				{date(2023, 2, 1), date(2023, 2, 28)},
+				{date(2023, 3, 1), date(2023, 3, 10)},
+			},
+		},
+		{
+			name: "across the year", from: date(2023, 12, 1), to: date(2024, 1, 31), monthly: true,
+			want: [][2]time.Time{
+				{date(2023, 12, 1), date(2023, 12, 31)},
+				{date(2024, 1, 1), date(2024, 1, 31)},
+			},
+		},
+		{
+			name: "single day", from: date(2024, 2, 29), to: date(2024, 2, 29), monthly: true,
+			want: [][2]time.Time{{date(2024, 2, 29), date(2024, 2, 29)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got := portfolioBuckets(tt.from, tt.to, tt.monthly)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestPortfolioMetrics(t *testing.T) {
+	flows := map[string]portfolioFlow{
+		"2023-01": {
+			disbursedCount: 2, disbursedVolume: 10_000_000, collectedInterest: 100_000, collectedPrincipal: 1_000_000,
+		},
+		"2023-03": {
+			disbursedCount: 1, disbursedVolume: 5_000_000, collectedInterest: 200_000, collectedPrincipal: 2_000_000,
+		},
+		//	outside of the range
+		"2023-04": {disbursedCount: 9, disbursedVolume: 90_000_000},
+	}
+	before := portfolioFlow{disbursedCount: 1, disbursedVolume: 4_000_000, collectedPrincipal: 1_000_000}
+	risks := map[string]portfolioRisk{
+		"2023-01-31": {par1: 2_600_000, par30: 1_300_000},
+		"2023-03-10": {par1: 1_500_000, par30: 1_500_000, par60: 1_500_000, par90: 1_500_000, defaulted: 1},
+	}
+
+	tests := []struct {
+		name    string
+		monthly bool
+		before  portfolioFlow
+		want    []PortfolioMetrics
+	}{
+		{
+			name: "summary", before: before,
+			want: []PortfolioMetrics{
+				{
+					From: "2023-01-15", To: "2023-03-10", DisbursedCount: 3, DisbursedVolume: 15_000_000,
+					AverageTicketSize: 5_000_000, CollectedInterest: 300_000, OutstandingPrincipal: 15_000_000,
+					Par1: 10, Par30: 10, Par60: 10, Par90: 10, DefaultRate: 25,
+				},
+			},
+		},
+		{
+			name: "monthly", monthly: true, before: before,
+			want: []PortfolioMetrics{
+				{
+					Month: "2023-01", From: "2023-01-15", To: "2023-01-31", DisbursedCount: 2,
+					DisbursedVolume: 10_000_000, AverageTicketSize: 5_000_000, CollectedInterest: 100_000,
+					OutstandingPrincipal: 12_000_000, Par1: 21.67, Par30: 10.83,
+				},
+				{Month: "2023-02", From: "2023-02-01", To: "2023-02-28", OutstandingPrincipal: 12_000_000},
+				{
+					Month: "2023-03", From: "2023-03-01", To: "2023-03-10", DisbursedCount: 1,
+					DisbursedVolume: 5_000_000, AverageTicketSize: 5_000_000, CollectedInterest: 200_000,
+					OutstandingPrincipal: 15_000_000, Par1: 10, Par30: 10, Par60: 10, Par90: 10, DefaultRate: 25,
+				},
+			},
+		},
+		{
+			name:   "nothing outstanding has nothing at risk",
+			before: portfolioFlow{disbursedCount: 1, disbursedVolume: 4_000_000, collectedPrincipal: 16_000_000},
+			want: []PortfolioMetrics{
+				{
+					From: "2023-01-15", To: "2023-03-10", DisbursedCount: 3, DisbursedVolume: 15_000_000,
+					AverageTicketSize: 5_000_000, CollectedInterest: 300_000, OutstandingPrincipal: 0,
+					DefaultRate: 25,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				buckets := portfolioBuckets(date(2023, 1, 15), date(2023, 3, 10), tt.monthly)
+				got := portfolioMetrics(buckets, tt.monthly, flows, tt.before, risks)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %+v, want %+v", got, tt.want)
+				}
+			},
+		)
+	}
+}
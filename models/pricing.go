@@ -0,0 +1,407 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/loancalc"
+	"github.com/google/uuid"
+)
+
+// PricingGridRow prices the proposals whose latest score prediction is ScoreLabel and whose tenor and amount fall in
+// the inclusive ranges
+type PricingGridRow struct {
+	ScoreLabel   string  `json:"score_label"`
+	TenorMin     int     `json:"tenor_min"`
+	TenorMax     int     `json:"tenor_max"`
+	AmountMin    float64 `json:"amount_min"`
+	AmountMax    float64 `json:"amount_max"`
+	InterestRate int     `json:"interest_rate"`
+}
+
+type PricingGridRequest struct {
+	Version string           `json:"version" binding:"required"`
+	Rows    []PricingGridRow `json:"rows" binding:"required"`
+}
+
+type CreatePricingGridResponse struct {
+	Id string `json:"id"`
+}
+
+type PricingGridResponse struct {
+	Id        string           `json:"id"`
+	Version   string           `json:"version"`
+	IsActive  bool             `json:"is_active"`
+	Rows      []PricingGridRow `json:"rows"`
+	CreatedBy string           `json:"created_by"`
+	CreatedOn string           `json:"created_on"`
+}
+
+type LendingOfferResponse struct {
+	LendingId          string  `json:"lending_id"`
+	Amount             float64 `json:"amount"`
+	Tenor              int     `json:"tenor"`
+	InterestRate       int     `json:"interest_rate"`
+	InterestMethod     string  `json:"interest_method"`
+	MonthlyInstallment int64   `json:"monthly_installment"`
+	TotalRepayment     int64   `json:"total_repayment"`
+	// ScoreLabel is the prediction of the credit score the offer was priced with
+	ScoreLabel         string `json:"score_label"`
+	CreditScoreId      string `json:"credit_score_id,omitempty"`
+	PricingGridVersion string `json:"pricing_grid_version"`
+	Status             string `json:"status"`
+	OfferedOn          string `json:"offered_on"`
+	// ExpiresOn is empty for the offers made before offers expired
+	ExpiresOn string `json:"expires_on,omitempty"`
+	// Expired is true once an offer that has not been accepted is past ExpiresOn, a new offer has to be requested
+	Expired bool `json:"expired"`
+}
+
+// validate checks every row against lendingRules so a grid can never offer a rate outside of the allowed range
+func (p *PricingGridRequest) validate() error {
+	rules := lendingRules
+	var fieldErrors jsonutil.FieldErrors
+	for i, row := range p.Rows {
+		field := fmt.Sprintf("rows[%d]", i)
+		if row.ScoreLabel == "" {
+			fieldErrors = append(
+				fieldErrors, jsonutil.FieldError{
+					Field:   field + ".score_label",
+					Code:    "required",
+					Message: fmt.Sprintf("%s.score_label is required", field),
+				},
+			)
+		}
+		if row.TenorMin > row.TenorMax {
+			fieldErrors = append(
+				fieldErrors, jsonutil.FieldError{
+					Field:   field + ".tenor_min",
+					Code:    "invalid_range",
+					Message: fmt.Sprintf("%s.tenor_min must not be above tenor_max", field),
+				},
+			)
+		}
+		if row.AmountMin > row.AmountMax {
+			fieldErrors = append(
+				fieldErrors, jsonutil.FieldError{
+					Field:   field + ".amount_min",
+					Code:    "invalid_range",
+					Message: fmt.Sprintf("%s.amount_min must not be above amount_max", field),
+				},
+			)
+		}
+		if row.InterestRate < rules.MinInterestRate || row.InterestRate > rules.MaxInterestRate {
+			fieldErrors = append(
+				fieldErrors, jsonutil.FieldError{
+					Field: field + ".interest_rate",
+					Code:  "out_of_range",
+					Message: fmt.Sprintf(
+						"%s.interest_rate must be between %d and %d", field, rules.MinInterestRate,
+						rules.MaxInterestRate,
+					),
+				},
+			)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+	return nil
+}
+
+// Create stores the grid inactive, it only prices new offers once activated
+func (p *PricingGridRequest) Create(uid string) (CreatePricingGridResponse, error) {
+	err := p.validate()
+	if err != nil {
+		return CreatePricingGridResponse{}, err
+	}
+
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return CreatePricingGridResponse{}, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.New().String()
+	_, err = tx.Exec(
+		`INSERT INTO pricing_grids (id, version, created_by) VALUES (UUID_TO_BIN(?), ?, UUID_TO_BIN(?))`, id, p.Version,
+		uid,
+	)
+	if err != nil {
+		return CreatePricingGridResponse{}, err
+	}
+	for _, row := range p.Rows {
+		_, err = tx.Exec(
+			`INSERT INTO pricing_grid_rows (grid_refer, score_label, tenor_min, tenor_max, amount_min, amount_max, interest_rate) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?)`,
+			id, row.ScoreLabel, row.TenorMin, row.TenorMax, row.AmountMin, row.AmountMax, row.InterestRate,
+		)
+		if err != nil {
+			return CreatePricingGridResponse{}, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return CreatePricingGridResponse{}, err
+	}
+	return CreatePricingGridResponse{Id: id}, nil
+}
+
+func getPricingGridRows(id string) ([]PricingGridRow, error) {
+	rows, err := database.MysqlInstance.Query(
+		`SELECT score_label, tenor_min, tenor_max, amount_min, amount_max, interest_rate FROM pricing_grid_rows WHERE grid_refer = UUID_TO_BIN(?) ORDER BY score_label, tenor_min, amount_min`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []PricingGridRow{}
+	for rows.Next() {
+		var temp PricingGridRow
+		err := rows.Scan(
+			&temp.ScoreLabel, &temp.TenorMin, &temp.TenorMax, &temp.AmountMin, &temp.AmountMax, &temp.InterestRate,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// GetPricingGrids returns every grid version with its rows, newest first
+func GetPricingGrids() ([]PricingGridResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT BIN_TO_UUID(g.id), g.version, g.is_active, COALESCE(u.username, ''), g.created_at
+		FROM pricing_grids g
+		LEFT JOIN users u ON u.id = g.created_by
+		ORDER BY g.created_at DESC
+	`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var res []PricingGridResponse
+	for rows.Next() {
+		var temp PricingGridResponse
+		err := rows.Scan(&temp.Id, &temp.Version, &temp.IsActive, &temp.CreatedBy, &temp.CreatedOn)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	rows.Close()
+
+	for i := range res {
+		res[i].Rows, err = getPricingGridRows(res[i].Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// ActivatePricingGrid makes the grid the only one used for new offers, offers already made keep their grid
+func ActivatePricingGrid(id string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow(`SELECT 1 FROM pricing_grids WHERE id = UUID_TO_BIN(?) FOR UPDATE`, id).Scan(&exists)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("pricing grid not found")
+		}
+		return err
+	}
+	_, err = tx.Exec(`UPDATE pricing_grids SET is_active = (id = UUID_TO_BIN(?))`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// priceLending returns the first row matching the label, tenor and amount
+func priceLending(rows []PricingGridRow, label string, tenor int, amount float64) (PricingGridRow, bool) {
+	for _, row := range rows {
+		if row.ScoreLabel == label && tenor >= row.TenorMin && tenor <= row.TenorMax && amount >= row.AmountMin &&
+			amount <= row.AmountMax {
+			return row, true
+		}
+	}
+	return PricingGridRow{}, false
+}
+
+// CreateLendingOffer prices the proposal of uid with the active grid, scoring it first when it has never been scored
+// or changed since its last score. An offer that is still valid is returned as is while an expired one is priced again
+func CreateLendingOffer(id string, uid string) (LendingOfferResponse, error) {
+	var amount float64
+	var tenor int
+	var status string
+	var expired bool
+	err := database.MysqlInstance.QueryRow(
+		`SELECT amount, tenor, status, COALESCE(offer_expires_at <= CURRENT_TIMESTAMP, FALSE) FROM lending WHERE id = UUID_TO_BIN(?) AND user_refer = UUID_TO_BIN(?)`,
+		id, uid,
+	).Scan(&amount, &tenor, &status, &expired)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LendingOfferResponse{}, fmt.Errorf("lending not found")
+		}
+		return LendingOfferResponse{}, err
+	}
+	if status == "offered" && !expired {
+		return GetLendingOffer(id, uid)
+	}
+	if status != "pending_offer" && status != "offered" {
+		return LendingOfferResponse{}, fmt.Errorf("lending is no longer waiting for an offer")
+	}
+	//	the guarantors feed the score, so it waits until every invited guarantor answered
+	pending, err := hasPendingGuarantor(id)
+	if err != nil {
+		return LendingOfferResponse{}, err
+	}
+	if pending {
+		return LendingOfferResponse{}, fmt.Errorf("lending has no offer until every guarantor answered")
+	}
+
+	score, err := getLatestCreditScore(id)
+	if err != nil {
+		return LendingOfferResponse{}, err
+	}
+	stale := score == nil
+	if !stale {
+		//	an edited proposal or a change of guarantors has to be scored again before it is priced
+		stale, err = isRevisedSince(id, score.id)
+		if err != nil {
+			return LendingOfferResponse{}, err
+		}
+	}
+	if stale {
+		predicted, err := PredictCreditScore(id, "")
+		if err != nil {
+			return LendingOfferResponse{}, err
+		}
+		score = &latestCreditScore{id: predicted.Id, engine: predicted.Engine, score: predicted.Score}
+		if len(predicted.Predictions) > 0 {
+			score.prediction = predicted.Predictions[0]
+		}
+	}
+
+	var gridId string
+	err = database.MysqlInstance.QueryRow(`SELECT BIN_TO_UUID(id) FROM pricing_grids WHERE is_active = TRUE`).Scan(&gridId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LendingOfferResponse{}, fmt.Errorf("no pricing grid is active")
+		}
+		return LendingOfferResponse{}, err
+	}
+	rows, err := getPricingGridRows(gridId)
+	if err != nil {
+		return LendingOfferResponse{}, err
+	}
+	row, ok := priceLending(rows, score.prediction, tenor, amount)
+	if !ok {
+		return LendingOfferResponse{}, fmt.Errorf("no pricing for this proposal")
+	}
+
+	//	priced concurrently when no row is affected, the stored offer is returned either way
+	_, err = database.MysqlInstance.Exec(
+		`UPDATE lending SET interest_rate = ?, pricing_grid_refer = UUID_TO_BIN(?), offer_credit_score_refer = UUID_TO_BIN(?), offered_at = CURRENT_TIMESTAMP, offer_expires_at = CURRENT_TIMESTAMP + INTERVAL ? DAY, status = 'offered' WHERE id = UUID_TO_BIN(?) AND (status = 'pending_offer' OR (status = 'offered' AND offer_expires_at <= CURRENT_TIMESTAMP))`,
+		row.InterestRate, gridId, score.id, lendingRules.OfferValidDays, id,
+	)
+	if err != nil {
+		return LendingOfferResponse{}, err
+	}
+	return GetLendingOffer(id, uid)
+}
+
+// GetLendingOffer returns the offer made on the proposal of uid with the score it was priced with
+func GetLendingOffer(id string, uid string) (LendingOfferResponse, error) {
+	res := LendingOfferResponse{LendingId: id}
+	var gridVersion, offeredOn, expiresOn sql.NullString
+	var expired bool
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT l.amount, l.tenor, l.interest_rate, l.interest_method, l.status, g.version, l.offered_at,
+		       l.offer_expires_at, COALESCE(l.offer_expires_at <= CURRENT_TIMESTAMP, FALSE),
+		       COALESCE(BIN_TO_UUID(cs.id), ''), COALESCE(cs.prediction, '')
+		FROM lending l
+		LEFT JOIN pricing_grids g ON g.id = l.pricing_grid_refer
+		LEFT JOIN credit_scores cs ON cs.id = l.offer_credit_score_refer
+		WHERE l.id = UUID_TO_BIN(?) AND l.user_refer = UUID_TO_BIN(?)
+	`, id, uid,
+	).Scan(
+		&res.Amount, &res.Tenor, &res.InterestRate, &res.InterestMethod, &res.Status, &gridVersion, &offeredOn,
+		&expiresOn, &expired, &res.CreditScoreId, &res.ScoreLabel,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LendingOfferResponse{}, fmt.Errorf("lending not found")
+		}
+		return LendingOfferResponse{}, err
+	}
+	if !gridVersion.Valid {
+		return LendingOfferResponse{}, fmt.Errorf("lending has no offer")
+	}
+	res.PricingGridVersion = gridVersion.String
+	res.OfferedOn = offeredOn.String
+	res.ExpiresOn = expiresOn.String
+	res.Expired = res.Status == "offered" && expired
+
+	schedule, err := loancalc.NewSchedule(
+		res.InterestMethod, loancalc.Rupiah(res.Amount), res.InterestRate, res.Tenor, time.Now(),
+	)
+	if err != nil {
+		return LendingOfferResponse{}, err
+	}
+	res.MonthlyInstallment = schedule.MaxInstallment()
+	res.TotalRepayment = schedule.TotalRepayment
+	return res, nil
+}
+
+// AcceptLendingOffer sends the offered proposal of uid to approval at the offered rate
+func AcceptLendingOffer(id string, uid string) error {
+	result, err := database.MysqlInstance.Exec(
+		`UPDATE lending SET status = 'pending', offer_accepted_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?) AND user_refer = UUID_TO_BIN(?) AND status = 'offered' AND (offer_expires_at IS NULL OR offer_expires_at > CURRENT_TIMESTAMP)`,
+		id, uid,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		var expired bool
+		err = database.MysqlInstance.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM lending WHERE id = UUID_TO_BIN(?) AND user_refer = UUID_TO_BIN(?) AND status = 'offered')`,
+			id, uid,
+		).Scan(&expired)
+		if err != nil {
+			return err
+		}
+		if expired {
+			return fmt.Errorf("offer has expired")
+		}
+		return fmt.Errorf("offer not found")
+	}
+
+	//	the decision policy scores and decides clear-cut proposals without waiting for an admin
+	if decisionPolicy.Enabled {
+		go func() {
+			_, err := DecideLending(id, "")
+			if err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/uploadutil"
 	"github.com/google/uuid"
 )
 
@@ -138,15 +139,8 @@ func DeleteProduct(id string) error {
 	return nil
 }
 
-func CreateProductImage(productId string, image multipart.File, header *multipart.FileHeader) (GoBlobResponse, error) {
-	// check if product exists
-	_, err := database.MysqlInstance.Exec(
-		"SELECT id FROM products WHERE id = UUID_TO_BIN(?)", productId,
-	)
-	if err != nil {
-		return GoBlobResponse{}, err
-	}
-
+// uploadToGoBlob sends an already validated upload to the image server and returns the stored file name
+func uploadToGoBlob(file uploadutil.File) (GoBlobResponse, error) {
 	//	Create a POST request to the image server
 	url := goBlobBaseUrl + "/file"
 	req, err := http.NewRequest("POST", url, nil)
@@ -157,11 +151,11 @@ func CreateProductImage(productId string, image multipart.File, header *multipar
 	// Set the content type to multipart/form-data
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", header.Filename)
+	part, err := writer.CreateFormFile("file", file.Filename)
 	if err != nil {
 		return GoBlobResponse{}, err
 	}
-	_, err = io.Copy(part, image)
+	_, err = part.Write(file.Content)
 	if err != nil {
 		return GoBlobResponse{}, err
 	}
@@ -171,7 +165,7 @@ func CreateProductImage(productId string, image multipart.File, header *multipar
 	}
 
 	//	pass file name
-	req.Header.Set("File-Name", header.Filename)
+	req.Header.Set("File-Name", file.Filename)
 	req.Header.Set("Authorization", goBlobAuthorization)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Body = io.NopCloser(body)
@@ -182,6 +176,7 @@ func CreateProductImage(productId string, image multipart.File, header *multipar
 	if err != nil {
 		return GoBlobResponse{}, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return GoBlobResponse{}, fmt.Errorf("image server error")
@@ -194,6 +189,22 @@ func CreateProductImage(productId string, image multipart.File, header *multipar
 	if err != nil {
 		return GoBlobResponse{}, err
 	}
+	return res, nil
+}
+
+func CreateProductImage(productId string, image uploadutil.File) (GoBlobResponse, error) {
+	// check if product exists
+	_, err := database.MysqlInstance.Exec(
+		"SELECT id FROM products WHERE id = UUID_TO_BIN(?)", productId,
+	)
+	if err != nil {
+		return GoBlobResponse{}, err
+	}
+
+	res, err := uploadToGoBlob(image)
+	if err != nil {
+		return GoBlobResponse{}, err
+	}
 
 	//	Insert into database
 	_, err = database.MysqlInstance.Exec(
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/loancalc"
+)
+
+type LendingQuoteResponse struct {
+	loancalc.Schedule
+	// MinInterestRate is the best rate the active pricing grid offers for the amount and tenor, the schedule uses the
+	// highest one so the real offer is never above the quote
+	MinInterestRate    int     `json:"min_interest_rate"`
+	PricingGridVersion string  `json:"pricing_grid_version,omitempty"`
+	Fee                int64   `json:"fee"`
+	Disbursed          int64   `json:"disbursed"`
+	APR                float64 `json:"apr"`
+}
+
+// QuoteLending returns the installment table a borrower would pay for the product before the proposal is scored,
+// priced with the highest rate of the active pricing grid or the highest allowed rate when no grid matches
+func QuoteLending(amount float64, tenor int, product string) (LendingQuoteResponse, error) {
+	l := LendingRequest{Product: product}
+	method, productFound := l.interestMethod()
+	fieldErrors := validateAmountAndTenor(amount, tenor)
+	if !productFound {
+		fieldErrors = append(fieldErrors, productNotFound())
+	}
+	if len(fieldErrors) > 0 {
+		return LendingQuoteResponse{}, fieldErrors
+	}
+
+	var minRate, maxRate sql.NullInt64
+	var version sql.NullString
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT MIN(r.interest_rate), MAX(r.interest_rate), MAX(g.version)
+		FROM pricing_grid_rows r
+		INNER JOIN pricing_grids g ON g.id = r.grid_refer
+		WHERE g.is_active = TRUE AND ? BETWEEN r.tenor_min AND r.tenor_max AND ? BETWEEN r.amount_min AND r.amount_max
+	`, tenor, amount,
+	).Scan(&minRate, &maxRate, &version)
+	if err != nil {
+		return LendingQuoteResponse{}, err
+	}
+	res := LendingQuoteResponse{
+		MinInterestRate: lendingRules.MinInterestRate,
+	}
+	interestRate := lendingRules.MaxInterestRate
+	if maxRate.Valid {
+		interestRate = int(maxRate.Int64)
+		res.MinInterestRate = int(minRate.Int64)
+		res.PricingGridVersion = version.String
+	}
+
+	res.Schedule, err = loancalc.NewSchedule(
+		method, loancalc.Rupiah(amount), interestRate, tenor, time.Now(),
+	)
+	if err != nil {
+		return LendingQuoteResponse{}, err
+	}
+	res.Fee = originationFee(res.Amount)
+	res.Disbursed = res.Amount - res.Fee
+	res.APR = res.Schedule.APR(res.Fee)
+	return res, nil
+}
@@ -0,0 +1,523 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
+	"github.com/Tus1688/kim-hackathon-2023-api/loancalc"
+	"github.com/Tus1688/kim-hackathon-2023-api/midtrans"
+	"github.com/google/uuid"
+)
+
+// billValidHours is how long a repayment bill can be paid before another one can be created
+const billValidHours = 24
+
+type InstallmentResponse struct {
+	Number        int    `json:"number"`
+	DueDate       string `json:"due_date"`
+	Principal     int64  `json:"principal"`
+	Interest      int64  `json:"interest"`
+	Fee           int64  `json:"fee"`
+	PaidPrincipal int64  `json:"paid_principal"`
+	PaidInterest  int64  `json:"paid_interest"`
+	PaidFee       int64  `json:"paid_fee"`
+	IsPaid        bool   `json:"is_paid"`
+}
+
+// PayoffQuoteResponse is what settles the lending today, the interest of the installments after the current one is
+// waived
+type PayoffQuoteResponse struct {
+	LendingId string `json:"lending_id"`
+	Fee       int64  `json:"fee"`
+	Interest  int64  `json:"interest"`
+	Principal int64  `json:"principal"`
+	Total     int64  `json:"total"`
+	// MinPayment is the smallest partial payment accepted
+	MinPayment int64  `json:"min_payment"`
+	QuotedOn   string `json:"quoted_on"`
+}
+
+type LendingPaymentRequest struct {
+	Amount int64 `json:"amount" binding:"required"`
+}
+
+type LendingPaymentResponse struct {
+	Id          string `json:"id"`
+	Amount      int64  `json:"amount"`
+	Token       string `json:"token"`
+	RedirectUrl string `json:"redirect_url"`
+}
+
+// installment is an unpaid row of lending_installments
+type installment struct {
+	id            string
+	number        int
+	dueDate       time.Time
+	principal     int64
+	interest      int64
+	fee           int64
+	paidPrincipal int64
+	paidInterest  int64
+	paidFee       int64
+}
+
+func (i *installment) isPaid() bool {
+	return i.paidPrincipal >= i.principal && i.paidInterest >= i.interest && i.paidFee >= i.fee
+}
+
+// createInstallments stores the schedule of a disbursed lending from the terms of its agreement, the first installment
+// is due a month after start
+func createInstallments(tx *sql.Tx, id string, start time.Time) error {
+	terms, err := getAcceptedAgreementTerms(tx, id)
+	if err != nil {
+		return err
+	}
+	schedule, err := loancalc.NewSchedule(terms.interestMethod, terms.amount, terms.interestRate, terms.tenor, start)
+	if err != nil {
+		return err
+	}
+	for _, i := range schedule.Installments {
+		_, err = tx.Exec(
+			`INSERT INTO lending_installments (lending_refer, number, due_date, principal, interest) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?)`,
+			id, i.Number, i.DueDate, i.Principal, i.Interest,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accrueLateFees charges lendingRules.LateFee once on every overdue installment
+func accrueLateFees(tx *sql.Tx, id string) error {
+	if lendingRules.LateFee <= 0 {
+		return nil
+	}
+	_, err := tx.Exec(
+		`UPDATE lending_installments SET fee = ? WHERE lending_refer = UUID_TO_BIN(?) AND is_paid = FALSE AND fee = 0 AND due_date < UTC_DATE()`,
+		lendingRules.LateFee, id,
+	)
+	return err
+}
+
+// getUnpaidInstallments locks the unpaid installments of the lending in order
+func getUnpaidInstallments(tx *sql.Tx, id string) ([]installment, error) {
+	rows, err := tx.Query(
+		`
+		SELECT BIN_TO_UUID(id), number, due_date, principal, interest, fee, paid_principal, paid_interest, paid_fee
+		FROM lending_installments
+		WHERE lending_refer = UUID_TO_BIN(?) AND is_paid = FALSE
+		ORDER BY number
+		FOR UPDATE
+	`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []installment
+	for rows.Next() {
+		var temp installment
+		err := rows.Scan(
+			&temp.id, &temp.number, &temp.dueDate, &temp.principal, &temp.interest, &temp.fee, &temp.paidPrincipal,
+			&temp.paidInterest, &temp.paidFee,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// dueCount is how many of the unpaid installments are due, the overdue ones and the current period
+func dueCount(installments []installment, today time.Time) int {
+	for i, temp := range installments {
+		if temp.dueDate.After(today) {
+			return i + 1
+		}
+	}
+	return len(installments)
+}
+
+// payoff returns the fees, the interest of the due installments and every unpaid principal
+func payoff(installments []installment, today time.Time) (int64, int64, int64) {
+	var fee, interest, principal int64
+	due := dueCount(installments, today)
+	for i, temp := range installments {
+		fee += temp.fee - temp.paidFee
+		principal += temp.principal - temp.paidPrincipal
+		if i < due {
+			interest += temp.interest - temp.paidInterest
+		}
+	}
+	return fee, interest, principal
+}
+
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// quotePayoff has to run in a transaction as the late fees are accrued first
+func quotePayoff(tx *sql.Tx, id string) (PayoffQuoteResponse, error) {
+	err := accrueLateFees(tx, id)
+	if err != nil {
+		return PayoffQuoteResponse{}, err
+	}
+	installments, err := getUnpaidInstallments(tx, id)
+	if err != nil {
+		return PayoffQuoteResponse{}, err
+	}
+	if len(installments) == 0 {
+		return PayoffQuoteResponse{}, fmt.Errorf("lending has no outstanding installment")
+	}
+
+	now := today()
+	res := PayoffQuoteResponse{LendingId: id, QuotedOn: now.Format("2006-01-02")}
+	res.Fee, res.Interest, res.Principal = payoff(installments, now)
+	res.Total = res.Fee + res.Interest + res.Principal
+	res.MinPayment = lendingRules.MinPaymentAmount
+	if res.MinPayment > res.Total {
+		res.MinPayment = res.Total
+	}
+	return res, nil
+}
+
+// checkLendingOwner makes sure the lending belongs to uid and has been disbursed, billing starts with the disbursement
+func checkLendingOwner(id string, uid string) error {
+	var exists int
+	err := database.MysqlInstance.QueryRow(
+		`SELECT 1 FROM lending WHERE id = UUID_TO_BIN(?) AND user_refer = UUID_TO_BIN(?) AND disbursed_at IS NOT NULL`,
+		id, uid,
+	).Scan(&exists)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("lending not found")
+		}
+		return err
+	}
+	return nil
+}
+
+// GetPayoffQuote returns the amount that settles the lending of uid today
+func GetPayoffQuote(id string, uid string) (PayoffQuoteResponse, error) {
+	err := checkLendingOwner(id, uid)
+	if err != nil {
+		return PayoffQuoteResponse{}, err
+	}
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return PayoffQuoteResponse{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := quotePayoff(tx, id)
+	if err != nil {
+		return PayoffQuoteResponse{}, err
+	}
+	return res, tx.Commit()
+}
+
+// GetLendingInstallments returns the current schedule of the lending of uid
+func GetLendingInstallments(id string, uid string) ([]InstallmentResponse, error) {
+	err := checkLendingOwner(id, uid)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT number, due_date, principal, interest, fee, paid_principal, paid_interest, paid_fee, is_paid
+		FROM lending_installments
+		WHERE lending_refer = UUID_TO_BIN(?)
+		ORDER BY number
+	`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []InstallmentResponse
+	for rows.Next() {
+		var temp InstallmentResponse
+		var dueDate time.Time
+		err := rows.Scan(
+			&temp.Number, &dueDate, &temp.Principal, &temp.Interest, &temp.Fee, &temp.PaidPrincipal,
+			&temp.PaidInterest, &temp.PaidFee, &temp.IsPaid,
+		)
+		if err != nil {
+			return nil, err
+		}
+		temp.DueDate = dueDate.Format("2006-01-02")
+		res = append(res, temp)
+	}
+	return res, nil
+}
+
+// CreateLendingPayment creates a snap payment of amount for the lending of uid, any amount from the minimum payment
+// up to the payoff amount is accepted. A lending has at most one pending bill so two payments can not both be
+// allocated against the same quote
+func (p *LendingPaymentRequest) CreateLendingPayment(id string, uid string) (LendingPaymentResponse, error) {
+	err := checkLendingOwner(id, uid)
+	if err != nil {
+		return LendingPaymentResponse{}, err
+	}
+	res, err := p.createBill(id, uid)
+	if err != nil {
+		return LendingPaymentResponse{}, err
+	}
+
+	//	midtrans is called once the bill is committed so the installments are not locked during the request
+	var snapReq midtrans.RequestSnap
+	snapReq.TransactionDetails = midtrans.TransactionDetails{
+		OrderId:     midtrans.BaseOrderId + "-" + res.Id,
+		GrossAmount: int(res.Amount),
+	}
+	//	the amount is only valid for the day it was quoted as late fees and interest change afterwards
+	snapReq.Expiry = midtrans.Expiry{
+		StartTime: time.Now().Format("2006-01-02 15:04:05 -0700"),
+		Unit:      "hour",
+		Duration:  billValidHours,
+	}
+	snap, err := snapReq.CreatePayment()
+	if err != nil {
+		//	release the bill so the borrower can try again
+		_, updateErr := database.MysqlInstance.Exec(
+			`UPDATE bill SET status = 'failed' WHERE id = UUID_TO_BIN(?) AND is_paid = FALSE`, res.Id,
+		)
+		if updateErr != nil {
+			log.Print(updateErr)
+		}
+		return LendingPaymentResponse{}, err
+	}
+	res.Token = snap.Token
+	res.RedirectUrl = snap.RedirectUrl
+	_, err = database.MysqlInstance.Exec(
+		`UPDATE bill SET payment_token = ?, payment_url = ? WHERE id = UUID_TO_BIN(?)`, res.Token, res.RedirectUrl,
+		res.Id,
+	)
+	if err != nil {
+		return LendingPaymentResponse{}, err
+	}
+	return res, nil
+}
+
+// createBill validates the amount against the payoff quote and inserts a pending bill, the unpaid installments stay
+// locked until the bill is committed so concurrent requests see each other's bill
+func (p *LendingPaymentRequest) createBill(id string, uid string) (LendingPaymentResponse, error) {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return LendingPaymentResponse{}, err
+	}
+	defer tx.Rollback()
+
+	quote, err := quotePayoff(tx, id)
+	if err != nil {
+		return LendingPaymentResponse{}, err
+	}
+	var pending int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM bill WHERE lending_refer = UUID_TO_BIN(?) AND is_paid = FALSE AND status = 'pending' AND created_at > CURRENT_TIMESTAMP - INTERVAL ? HOUR`,
+		id, billValidHours,
+	).Scan(&pending)
+	if err != nil {
+		return LendingPaymentResponse{}, err
+	}
+	if pending > 0 {
+		return LendingPaymentResponse{}, fmt.Errorf("lending already has a pending payment")
+	}
+	if p.Amount < quote.MinPayment || p.Amount > quote.Total {
+		return LendingPaymentResponse{}, jsonutil.FieldErrors{
+			{
+				Field:   "amount",
+				Code:    "out_of_range",
+				Message: fmt.Sprintf("amount must be between %d and %d", quote.MinPayment, quote.Total),
+			},
+		}
+	}
+
+	res := LendingPaymentResponse{Id: uuid.New().String(), Amount: p.Amount}
+	_, err = tx.Exec(
+		`INSERT INTO bill (id, user_refer, lending_refer, amount, status) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?)`,
+		res.Id, uid, id, res.Amount, "pending",
+	)
+	if err != nil {
+		return LendingPaymentResponse{}, err
+	}
+	return res, tx.Commit()
+}
+
+// SettleBill allocates a settled bill to its lending, fees first, then the interest of the due installments, then
+// their principal. Anything left prepays the principal of the following installments which are recalculated over the
+// same remaining tenor. It does nothing for a bill that is already paid and only marks a bill without a lending as paid
+func SettleBill(id string, status string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lendingRefer sql.NullString
+	var amount float64
+	err = tx.QueryRow(
+		`SELECT BIN_TO_UUID(lending_refer), amount FROM bill WHERE id = UUID_TO_BIN(?) AND is_paid = FALSE FOR UPDATE`, id,
+	).Scan(&lendingRefer, &amount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	//	a bill without a lending has nothing to allocate
+	if !lendingRefer.Valid {
+		_, err = tx.Exec(
+			`UPDATE bill SET is_paid = TRUE, status = ?, paid_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)`, status, id,
+		)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	lendingId := lendingRefer.String
+
+	err = accrueLateFees(tx, lendingId)
+	if err != nil {
+		return err
+	}
+	installments, err := getUnpaidInstallments(tx, lendingId)
+	if err != nil {
+		return err
+	}
+
+	remaining := loancalc.Rupiah(amount)
+	var allocatedFee, allocatedInterest, allocatedPrincipal int64
+	pay := func(due int64, paid *int64, allocated *int64) {
+		part := due - *paid
+		if part > remaining {
+			part = remaining
+		}
+		if part <= 0 {
+			return
+		}
+		*paid += part
+		*allocated += part
+		remaining -= part
+	}
+
+	due := dueCount(installments, today())
+	for i := range installments {
+		pay(installments[i].fee, &installments[i].paidFee, &allocatedFee)
+	}
+	for i := 0; i < due; i++ {
+		pay(installments[i].interest, &installments[i].paidInterest, &allocatedInterest)
+	}
+	for i := 0; i < due; i++ {
+		pay(installments[i].principal, &installments[i].paidPrincipal, &allocatedPrincipal)
+	}
+
+	//	the following installments have not been paid at all so they can be rebuilt from their remaining principal
+	future := installments[due:]
+	if remaining > 0 && len(future) > 0 {
+		var futurePrincipal int64
+		for _, temp := range future {
+			futurePrincipal += temp.principal
+		}
+		prepaid := remaining
+		if prepaid > futurePrincipal {
+			prepaid = futurePrincipal
+		}
+		allocatedPrincipal += prepaid
+		remaining -= prepaid
+		err = recalculateInstallments(tx, lendingId, future, futurePrincipal-prepaid)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, temp := range installments[:due] {
+		_, err = tx.Exec(
+			`UPDATE lending_installments SET paid_principal = ?, paid_interest = ?, paid_fee = ?, is_paid = ?, paid_at = IF(is_paid, CURRENT_TIMESTAMP, NULL) WHERE id = UUID_TO_BIN(?)`,
+			temp.paidPrincipal, temp.paidInterest, temp.paidFee, temp.isPaid(), temp.id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`UPDATE bill SET is_paid = TRUE, status = ?, allocated_fee = ?, allocated_interest = ?, allocated_principal = ?, unallocated = ?, paid_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)`,
+		status, allocatedFee, allocatedInterest, allocatedPrincipal, remaining, id,
+	)
+	if err != nil {
+		return err
+	}
+	err = postRepayment(tx, id, lendingId, allocatedFee, allocatedInterest, allocatedPrincipal, remaining)
+	if err != nil {
+		return err
+	}
+	err = distributeRepayment(tx, id, lendingId, allocatedPrincipal, allocatedInterest)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		`UPDATE lending SET is_paid = TRUE, status = 'paid' WHERE id = UUID_TO_BIN(?) AND NOT EXISTS (SELECT 1 FROM lending_installments WHERE lending_refer = UUID_TO_BIN(?) AND is_paid = FALSE)`,
+		lendingId, lendingId,
+	)
+	if err != nil {
+		return err
+	}
+	//	the collateral goes back to the borrower once the lending is repaid
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		err = releaseCollateral(tx, lendingId)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// recalculateInstallments spreads principal over the future installments with the method of the lending, keeping
+// their due dates. A zero principal settles them
+func recalculateInstallments(tx *sql.Tx, id string, future []installment, principal int64) error {
+	if principal == 0 {
+		for _, temp := range future {
+			_, err := tx.Exec(
+				`UPDATE lending_installments SET principal = 0, interest = 0, is_paid = TRUE, paid_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?)`,
+				temp.id,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var interestRate, tenor int
+	var interestMethod string
+	err := tx.QueryRow(
+		`SELECT interest_rate, tenor, interest_method FROM lending WHERE id = UUID_TO_BIN(?)`, id,
+	).Scan(&interestRate, &tenor, &interestMethod)
+	if err != nil {
+		return err
+	}
+	schedule, err := loancalc.Reschedule(interestMethod, principal, interestRate, tenor, len(future), today())
+	if err != nil {
+		return err
+	}
+	for i, temp := range future {
+		_, err = tx.Exec(
+			`UPDATE lending_installments SET principal = ?, interest = ? WHERE id = UUID_TO_BIN(?)`,
+			schedule.Installments[i].Principal, schedule.Installments[i].Interest, temp.id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/database"
+)
+
+const (
+	RevisionEdit   = "edit"
+	RevisionCancel = "cancel"
+	// RevisionGuarantor is a guarantor added, removed or invited again, the snapshot is still the proposal itself
+	RevisionGuarantor = "guarantor"
+	// RevisionCollateral is a collateral item added or removed
+	RevisionCollateral = "collateral"
+)
+
+// editableLendingStatus are the statuses a proposal can still be edited or cancelled by the borrower, before any
+// admin has reviewed it
+const editableLendingStatus = `'pending_offer', 'offered', 'pending'`
+
+type CancelLendingRequest struct {
+	Reason string `json:"reason"`
+}
+
+// lendingSnapshot is the version of the proposal stored before every edit or cancellation
+type lendingSnapshot struct {
+	Amount           float64       `json:"amount"`
+	InterestRate     int           `json:"interest_rate"`
+	Product          string        `json:"product"`
+	InterestMethod   string        `json:"interest_method"`
+	Tenor            int           `json:"tenor"`
+	Age              int           `json:"age"`
+	Gender           Gender        `json:"gender"`
+	Income           float64       `json:"income"`
+	LastEducation    Education     `json:"last_education"`
+	MaritalStatus    MaritalStatus `json:"marital_status"`
+	NumberOfChildren int           `json:"number_of_children"`
+	HasHouse         HomeOwnership `json:"has_house"`
+	KkDocumentId     string        `json:"kk_document_id"`
+	KtpDocumentId    string        `json:"ktp_document_id"`
+	Status           string        `json:"status"`
+}
+
+type LendingRevisionResponse struct {
+	Revision int    `json:"revision"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason,omitempty"`
+	// Previous is the proposal as it was before this revision
+	Previous  json.RawMessage `json:"previous"`
+	CreatedBy string          `json:"created_by"`
+	CreatedOn string          `json:"created_on"`
+}
+
+// lockEditableLending locks the proposal of uid and stores its current version as a new revision, it fails when
+// the proposal is no longer pending review
+func lockEditableLending(tx *sql.Tx, id string, uid string, action string, reason string) error {
+	var s lendingSnapshot
+	var editable bool
+	err := tx.QueryRow(
+		`
+		SELECT amount, interest_rate, COALESCE(product, ''), interest_method, tenor, age, COALESCE(gender, 0), income,
+		       COALESCE(last_education, 0), COALESCE(marital_status, 0), number_of_children,
+		       COALESCE(home_ownership, 0), COALESCE(BIN_TO_UUID(kk_document_refer), ''),
+		       COALESCE(BIN_TO_UUID(ktp_document_refer), ''), status,
+		       status IN (`+editableLendingStatus+`) AND is_approved = FALSE AND is_rejected = FALSE
+		FROM lending
+		WHERE id = UUID_TO_BIN(?) AND user_refer = UUID_TO_BIN(?)
+		FOR UPDATE
+	`, id, uid,
+	).Scan(
+		&s.Amount, &s.InterestRate, &s.Product, &s.InterestMethod, &s.Tenor, &s.Age, &s.Gender, &s.Income, &s.LastEducation,
+		&s.MaritalStatus, &s.NumberOfChildren, &s.HasHouse, &s.KkDocumentId, &s.KtpDocumentId, &s.Status, &editable,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("lending not found")
+		}
+		return err
+	}
+	if !editable {
+		return fmt.Errorf("lending is no longer pending review")
+	}
+
+	snapshot, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO lending_revisions (lending_refer, revision, action, reason, previous, created_by) SELECT UUID_TO_BIN(?), COALESCE(MAX(revision), 0) + 1, ?, NULLIF(?, ''), ?, UUID_TO_BIN(?) FROM lending_revisions WHERE lending_refer = UUID_TO_BIN(?)`,
+		id, action, reason, string(snapshot), uid, id,
+	)
+	return err
+}
+
+// Modify replaces the proposal of the borrower after validating it again, any offer made on the previous version is
+// withdrawn so the proposal is scored and priced again
+func (l *LendingRequest) Modify() error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockBorrower(tx, l.RequesterUid)
+	if err != nil {
+		return err
+	}
+	err = l.validateIn(tx)
+	if err != nil {
+		return err
+	}
+	kkFileName, ktpFileName, err := l.resolveDocuments()
+	if err != nil {
+		return err
+	}
+	l.InterestMethod, _ = l.interestMethod()
+	err = lockEditableLending(tx, l.Id, l.RequesterUid, RevisionEdit, "")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE lending SET amount = ?, product = ?, interest_method = ?, tenor = ?, age = ?, gender = ?, income = ?, last_education = ?, marital_status = ?, number_of_children = ?, home_ownership = ?, kk_url = ?, ktp_url = ?, kk_document_refer = UUID_TO_BIN(?), ktp_document_refer = UUID_TO_BIN(?), interest_rate = 0, pricing_grid_refer = NULL, offer_credit_score_refer = NULL, offered_at = NULL, offer_expires_at = NULL, offer_accepted_at = NULL, status = 'pending_offer' WHERE id = UUID_TO_BIN(?)`,
+		l.Amount, l.productCode(), l.InterestMethod, l.Tenor, l.Age, *l.Gender, l.Income, *l.LastEducation, *l.MaritalStatus, l.NumberOfChildren,
+		*l.HasHouse, kkFileName, ktpFileName, l.KkDocumentId, l.KtpDocumentId, l.Id,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CancelLending withdraws the proposal of uid while it is still pending review
+func (c *CancelLendingRequest) CancelLending(id string, uid string) error {
+	tx, err := database.MysqlInstance.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockEditableLending(tx, id, uid, RevisionCancel, c.Reason)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE lending SET status = 'cancelled' WHERE id = UUID_TO_BIN(?)`, id)
+	if err != nil {
+		return err
+	}
+	err = releaseCollateral(tx, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isRevisedSince reports whether the proposal or its guarantors changed after the credit score was made, the score
+// is then stale
+func isRevisedSince(id string, creditScoreId string) (bool, error) {
+	var revised bool
+	err := database.MysqlInstance.QueryRow(
+		`
+		SELECT EXISTS (
+		    SELECT 1 FROM lending_revisions r INNER JOIN credit_scores cs ON cs.id = UUID_TO_BIN(?)
+		    WHERE r.lending_refer = UUID_TO_BIN(?) AND r.action IN (?, ?) AND r.created_at >= cs.created_at
+		) OR EXISTS (
+		    SELECT 1 FROM lending_guarantors g INNER JOIN credit_scores cs ON cs.id = UUID_TO_BIN(?)
+		    WHERE g.lending_refer = UUID_TO_BIN(?) AND g.responded_at >= cs.created_at
+		)
+	`, creditScoreId, id, RevisionEdit, RevisionGuarantor, creditScoreId, id,
+	).Scan(&revised)
+	return revised, err
+}
+
+// GetLendingRevisions returns every previous version of the proposal, oldest first
+func GetLendingRevisions(id string) ([]LendingRevisionResponse, error) {
+	rows, err := database.MysqlInstance.Query(
+		`
+		SELECT r.revision, r.action, COALESCE(r.reason, ''), r.previous, u.username, r.created_at
+		FROM lending_revisions r
+		INNER JOIN users u ON u.id = r.created_by
+		WHERE r.lending_refer = UUID_TO_BIN(?)
+		ORDER BY r.revision
+	`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []LendingRevisionResponse
+	for rows.Next() {
+		var temp LendingRevisionResponse
+		var previous []byte
+		err := rows.Scan(&temp.Revision, &temp.Action, &temp.Reason, &previous, &temp.CreatedBy, &temp.CreatedOn)
+		if err != nil {
+			return nil, err
+		}
+		temp.Previous = previous
+		res = append(res, temp)
+	}
+	return res, nil
+}
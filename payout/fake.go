@@ -0,0 +1,66 @@
+package payout
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// FakeClient keeps the payouts in memory, every payout gets Status right away. Creating a payout with an Id that was
+// already used returns the existing payout like the idempotency key of Iris
+type FakeClient struct {
+	// Status is StatusCompleted by default, StatusProcessing lets Complete and Fail drive the payout instead
+	Status string
+	// Err is returned for every create when set, to simulate the provider being unreachable
+	Err error
+
+	mu      sync.Mutex
+	payouts map[string]Result
+	// created maps the Id of the payouts to their reference no
+	created map[string]string
+}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{Status: StatusCompleted, payouts: map[string]Result{}, created: map[string]string{}}
+}
+
+func (f *FakeClient) Create(_ context.Context, payout Payout) (Result, error) {
+	if f.Err != nil {
+		return Result{}, f.Err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if referenceNo, ok := f.created[payout.Id]; ok && payout.Id != "" {
+		return f.payouts[referenceNo], nil
+	}
+	res := Result{ReferenceNo: "fake-" + uuid.New().String(), Status: f.Status}
+	f.payouts[res.ReferenceNo] = res
+	f.created[payout.Id] = res.ReferenceNo
+	return res, nil
+}
+
+func (f *FakeClient) Get(_ context.Context, referenceNo string) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res, ok := f.payouts[referenceNo]
+	if !ok {
+		return Result{}, fmt.Errorf("payout not found")
+	}
+	return res, nil
+}
+
+func (f *FakeClient) Complete(referenceNo string) {
+	f.set(referenceNo, Result{ReferenceNo: referenceNo, Status: StatusCompleted})
+}
+
+func (f *FakeClient) Fail(referenceNo string, reason string) {
+	f.set(referenceNo, Result{ReferenceNo: referenceNo, Status: StatusFailed, FailureReason: reason})
+}
+
+func (f *FakeClient) set(referenceNo string, res Result) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.payouts[referenceNo] = res
+}
@@ -0,0 +1,161 @@
+package payout
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// irisHttpClient bounds every call to Iris, the request context may carry a shorter deadline
+var irisHttpClient = &http.Client{Timeout: 30 * time.Second}
+
+// IrisClient speaks the Midtrans Iris payout api. Payouts are created with the creator key, when ApproverKey is set
+// they are approved right away, otherwise they wait for an approval from the Iris dashboard
+type IrisClient struct {
+	BaseUrl     string
+	CreatorKey  string
+	ApproverKey string
+}
+
+type irisPayout struct {
+	BeneficiaryName    string `json:"beneficiary_name"`
+	BeneficiaryAccount string `json:"beneficiary_account"`
+	BeneficiaryBank    string `json:"beneficiary_bank"`
+	Amount             string `json:"amount"`
+	Notes              string `json:"notes"`
+}
+
+type irisCreateResponse struct {
+	Payouts []struct {
+		Status      string `json:"status"`
+		ReferenceNo string `json:"reference_no"`
+	} `json:"payouts"`
+}
+
+type irisPayoutResponse struct {
+	ReferenceNo string `json:"reference_no"`
+	Status      string `json:"status"`
+	ErrorCode   string `json:"error_code"`
+	ErrorMsg    string `json:"error_message"`
+}
+
+type irisErrorResponse struct {
+	ErrorMessage string   `json:"error_message"`
+	Errors       []string `json:"errors"`
+}
+
+// do returns an error wrapping ErrRejected when Iris refuses the request, transport errors, timeouts and server errors
+// are returned as is since Iris may have processed the request anyway
+func (i *IrisClient) do(
+	ctx context.Context, method string, path string, key string, idempotencyKey string, body any, result any,
+) error {
+	var reader *bytes.Buffer
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewBuffer(payload)
+	} else {
+		reader = &bytes.Buffer{}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, i.BaseUrl+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(key+":")))
+	if idempotencyKey != "" {
+		req.Header.Set("X-Idempotency-Key", idempotencyKey)
+	}
+
+	res, err := irisHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var irisErr irisErrorResponse
+		_ = json.NewDecoder(res.Body).Decode(&irisErr)
+		err = fmt.Errorf("iris error %d: %s %v", res.StatusCode, irisErr.ErrorMessage, irisErr.Errors)
+		if res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusRequestTimeout &&
+			res.StatusCode != http.StatusConflict && res.StatusCode != http.StatusTooManyRequests {
+			return fmt.Errorf("%w: %v", ErrRejected, err)
+		}
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// irisStatus maps queued, approved, processed, completed, failed and rejected to our statuses
+func irisStatus(status string) string {
+	switch status {
+	case "completed":
+		return StatusCompleted
+	case "failed", "rejected":
+		return StatusFailed
+	default:
+		return StatusProcessing
+	}
+}
+
+// Create sends the payout with its Id as the idempotency key, so retrying a payout whose outcome is unknown does not
+// send the money twice
+func (i *IrisClient) Create(ctx context.Context, payout Payout) (Result, error) {
+	body := map[string][]irisPayout{
+		"payouts": {
+			{
+				BeneficiaryName:    payout.BeneficiaryName,
+				BeneficiaryAccount: payout.BeneficiaryAccount,
+				BeneficiaryBank:    payout.BeneficiaryBank,
+				Amount:             strconv.FormatInt(payout.Amount, 10),
+				Notes:              payout.Notes,
+			},
+		},
+	}
+	var created irisCreateResponse
+	err := i.do(ctx, "POST", "/api/v1/payouts", i.CreatorKey, payout.Id, body, &created)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(created.Payouts) == 0 {
+		return Result{}, fmt.Errorf("iris returned no payout")
+	}
+	res := Result{ReferenceNo: created.Payouts[0].ReferenceNo, Status: irisStatus(created.Payouts[0].Status)}
+
+	if i.ApproverKey != "" {
+		approve := map[string][]string{"reference_nos": {res.ReferenceNo}}
+		err = i.do(ctx, "POST", "/api/v1/payouts/approve", i.ApproverKey, "", approve, nil)
+		if err != nil {
+			//	the payout exists and waits for an approval, it must not be taken as rejected
+			return res, fmt.Errorf("unable to approve payout %s: %v", res.ReferenceNo, err)
+		}
+	}
+	return res, nil
+}
+
+func (i *IrisClient) Get(ctx context.Context, referenceNo string) (Result, error) {
+	var payout irisPayoutResponse
+	err := i.do(ctx, "GET", "/api/v1/payouts/"+url.PathEscape(referenceNo), i.CreatorKey, "", nil, &payout)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{ReferenceNo: payout.ReferenceNo, Status: irisStatus(payout.Status)}
+	if res.Status == StatusFailed {
+		res.FailureReason = payout.ErrorMsg
+		if res.FailureReason == "" {
+			res.FailureReason = payout.Status
+		}
+	}
+	return res, nil
+}
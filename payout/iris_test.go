@@ -0,0 +1,68 @@
+package payout
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIrisCreate(t *testing.T) {
+	tests := []struct {
+		name          string
+		createStatus  int
+		approveStatus int
+		wantReference string
+		wantRejected  bool
+		wantErr       bool
+	}{
+		{name: "created and approved", createStatus: 201, approveStatus: 202, wantReference: "ref-1"},
+		{name: "invalid payout is rejected", createStatus: 400, wantRejected: true, wantErr: true},
+		{name: "server error is not a rejection", createStatus: 503, wantErr: true},
+		{name: "rate limit is not a rejection", createStatus: 429, wantErr: true},
+		{
+			name: "failed approval keeps the created payout", createStatus: 201, approveStatus: 401,
+			wantReference: "ref-1", wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var idempotencyKey string
+				server := httptest.NewServer(
+					http.HandlerFunc(
+						func(w http.ResponseWriter, r *http.Request) {
+							switch r.URL.Path {
+							case "/api/v1/payouts":
+								idempotencyKey = r.Header.Get("X-Idempotency-Key")
+								w.WriteHeader(tt.createStatus)
+								if tt.createStatus == 201 {
+									_, _ = w.Write([]byte(`{"payouts":[{"status":"queued","reference_no":"ref-1"}]}`))
+								}
+							case "/api/v1/payouts/approve":
+								w.WriteHeader(tt.approveStatus)
+							}
+						},
+					),
+				)
+				defer server.Close()
+
+				client := &IrisClient{BaseUrl: server.URL, CreatorKey: "creator", ApproverKey: "approver"}
+				res, err := client.Create(context.Background(), Payout{Id: "disbursement-1", Amount: 1_000_000})
+				if idempotencyKey != "disbursement-1" {
+					t.Fatalf("idempotency key %q, want the payout id", idempotencyKey)
+				}
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+				}
+				if errors.Is(err, ErrRejected) != tt.wantRejected {
+					t.Fatalf("got rejected %v, want %v", errors.Is(err, ErrRejected), tt.wantRejected)
+				}
+				if res.ReferenceNo != tt.wantReference {
+					t.Fatalf("got reference %q, want %q", res.ReferenceNo, tt.wantReference)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,85 @@
+package payout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+)
+
+// ErrRejected is wrapped by the errors of a payout the provider definitely did not accept, any other error of Create
+// leaves the payout unknown as it may still have been created
+var ErrRejected = errors.New("payout rejected")
+
+// Payout is a transfer to a borrower, Id is the disbursement id used to trace the transfer back. It is also the
+// idempotency key so creating the same payout again returns the existing transfer
+type Payout struct {
+	Id                 string
+	BeneficiaryName    string
+	BeneficiaryAccount string
+	BeneficiaryBank    string
+	Amount             int64
+	Notes              string
+}
+
+// Result is the state of a payout at the provider, Status is one of StatusProcessing, StatusCompleted or StatusFailed
+type Result struct {
+	ReferenceNo   string
+	Status        string
+	FailureReason string
+}
+
+// Client sends money out through a payout provider. Create may return a Result along with an error when the payout was
+// created but a later step failed
+type Client interface {
+	Create(ctx context.Context, payout Payout) (Result, error)
+	Get(ctx context.Context, referenceNo string) (Result, error)
+}
+
+// client is nil when no provider is configured, in which case nothing can be disbursed
+var client Client
+
+// Initialize uses Iris when IRIS_BASE_URL is set, the in memory FakeClient when PAYOUT_FAKE is "true"
+func Initialize() error {
+	if baseUrl := os.Getenv("IRIS_BASE_URL"); baseUrl != "" {
+		creatorKey := os.Getenv("IRIS_CREATOR_KEY")
+		if creatorKey == "" {
+			return fmt.Errorf("IRIS_CREATOR_KEY is required")
+		}
+		client = &IrisClient{
+			BaseUrl:     baseUrl,
+			CreatorKey:  creatorKey,
+			ApproverKey: os.Getenv("IRIS_APPROVER_KEY"),
+		}
+		return nil
+	}
+	if os.Getenv("PAYOUT_FAKE") == "true" {
+		client = NewFakeClient()
+	}
+	return nil
+}
+
+// SetClient replaces the configured client, mainly used to plug FakeClient
+func SetClient(c Client) {
+	client = c
+}
+
+func Create(ctx context.Context, payout Payout) (Result, error) {
+	if client == nil {
+		return Result{}, fmt.Errorf("%w: payout is not configured", ErrRejected)
+	}
+	return client.Create(ctx, payout)
+}
+
+func Get(ctx context.Context, referenceNo string) (Result, error) {
+	if client == nil {
+		return Result{}, fmt.Errorf("payout is not configured")
+	}
+	return client.Get(ctx, referenceNo)
+}
@@ -0,0 +1,146 @@
+package pdfutil
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	pageWidth  = 595 // A4 in points
+	pageHeight = 842
+	margin     = 50
+
+	textSize     = 10
+	textLeading  = 14
+	headingSize  = 14
+	headingSpace = 22
+	// wrapWidth is the characters per line of textSize Helvetica that fit between the margins
+	wrapWidth = 90
+)
+
+type line struct {
+	text    string
+	heading bool
+}
+
+// Document is a plain text pdf laid out on A4 pages with the standard Helvetica font, it needs no font file so the
+// output is small and always the same for the same lines
+type Document struct {
+	title string
+	lines []line
+}
+
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+func (d *Document) Heading(text string) {
+	d.lines = append(d.lines, line{text: text, heading: true})
+}
+
+// Text adds a paragraph, it is wrapped on spaces to fit the page. An empty text adds a blank line
+func (d *Document) Text(text string) {
+	for _, wrapped := range wrap(text, wrapWidth) {
+		d.lines = append(d.lines, line{text: wrapped})
+	}
+}
+
+func wrap(text string, width int) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+	//	keep the leading spaces as they are used to indent tables
+	indent := text[:len(text)-len(strings.TrimLeft(text, " "))]
+	var res []string
+	current := indent + words[0]
+	for _, word := range words[1:] {
+		if len(current)+1+len(word) > width {
+			res = append(res, current)
+			current = indent + word
+			continue
+		}
+		current += " " + word
+	}
+	return append(res, current)
+}
+
+// escape keeps the characters that exist in WinAnsiEncoding and escapes the pdf string delimiters
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// pages splits the lines into the content stream of every page
+func (d *Document) pages() []string {
+	var pages []string
+	var content bytes.Buffer
+	y := float64(pageHeight - margin)
+	for _, l := range d.lines {
+		size, leading, font := float64(textSize), float64(textLeading), "F1"
+		if l.heading {
+			size, leading, font = headingSize, headingSpace, "F2"
+		}
+		if y-leading < margin {
+			pages = append(pages, content.String())
+			content.Reset()
+			y = pageHeight - margin
+		}
+		y -= leading
+		fmt.Fprintf(&content, "BT /%s %.0f Tf %d %.0f Td (%s) Tj ET\n", font, size, margin, y, escape(l.text))
+	}
+	return append(pages, content.String())
+}
+
+func (d *Document) Bytes() []byte {
+	pages := d.pages()
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	//	1 catalog, 2 page tree, 3 and 4 fonts, 5 info, then a page and its content for every page
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+i*2)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) >>", escape(d.title)))
+	for i, content := range pages {
+		object(
+			fmt.Sprintf(
+				"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pageWidth, pageHeight, 7+i*2,
+			),
+		)
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(
+		&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref,
+	)
+	return buf.Bytes()
+}
@@ -3,6 +3,8 @@ package render
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/Tus1688/kim-hackathon-2023-api/jsonutil"
 )
 
 func JSON(w http.ResponseWriter, statusCode int, v any) error {
@@ -31,3 +33,21 @@ func HandleError(errMessage []string, statusCode int, w http.ResponseWriter) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
+
+type ErrFields struct {
+	Error  []string             `json:"error"`
+	Fields jsonutil.FieldErrors `json:"fields"`
+}
+
+// HandleFieldError is used when the request is well-formed but one or more fields are rejected by validation rules
+func HandleFieldError(fieldErrors jsonutil.FieldErrors, statusCode int, w http.ResponseWriter) {
+	err := JSON(
+		w, statusCode, ErrFields{
+			Error:  fieldErrors.Messages(),
+			Fields: fieldErrors,
+		},
+	)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
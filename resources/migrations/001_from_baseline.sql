@@ -0,0 +1,47 @@
+# run once on a database created from the schema before the lending platform, after schema.sql so the tables it adds
+# exist. It brings the users, lending and bill tables to their current definition, every other table is created by
+# schema.sql
+ALTER TABLE users
+    ADD COLUMN is_approver BOOL DEFAULT FALSE AFTER is_user,
+    ADD COLUMN is_document_viewer BOOL DEFAULT FALSE AFTER is_approver,
+    ADD COLUMN is_lender BOOL DEFAULT FALSE AFTER is_document_viewer;
+
+# the rate of the lending stored so far is the percentage of the amount charged over the whole tenor, they keep their
+# cost through flat_total while the lending created afterwards are priced with yearly rates
+ALTER TABLE lending
+    ALTER COLUMN interest_rate SET DEFAULT 0,
+    ADD COLUMN product VARCHAR(32) NULL AFTER interest_rate,
+    ADD COLUMN interest_method VARCHAR(32) NOT NULL DEFAULT 'flat_total' AFTER product;
+ALTER TABLE lending ALTER COLUMN interest_method SET DEFAULT 'flat';
+
+# last_education held the code as text, a missing or unknown one falls back to SMA like the credit score does
+UPDATE lending SET last_education = '0' WHERE last_education IS NULL OR last_education NOT IN ('0', '1', '2', '3', '4');
+ALTER TABLE lending MODIFY COLUMN last_education TINYINT NOT NULL DEFAULT 0;
+
+# the lending created so far keep their kk_url and ktp_url, they have no document, offer, funding nor disbursement
+ALTER TABLE lending
+    ADD COLUMN kk_document_refer BINARY(16) NULL AFTER ktp_url,
+    ADD COLUMN ktp_document_refer BINARY(16) NULL AFTER kk_document_refer,
+    ADD COLUMN pricing_grid_refer BINARY(16) NULL AFTER is_paid,
+    ADD COLUMN offer_credit_score_refer BINARY(16) NULL AFTER pricing_grid_refer,
+    ADD COLUMN offered_at TIMESTAMP NULL AFTER offer_credit_score_refer,
+    ADD COLUMN offer_expires_at TIMESTAMP NULL AFTER offered_at,
+    ADD COLUMN offer_accepted_at TIMESTAMP NULL AFTER offer_expires_at,
+    ADD COLUMN funded_at TIMESTAMP NULL AFTER offer_accepted_at,
+    ADD COLUMN disbursed_at TIMESTAMP NULL AFTER funded_at,
+    ADD INDEX (disbursed_at),
+    ADD FOREIGN KEY (pricing_grid_refer) REFERENCES pricing_grids(id),
+    ADD FOREIGN KEY (kk_document_refer) REFERENCES documents(id),
+    ADD FOREIGN KEY (ktp_document_refer) REFERENCES documents(id);
+
+# bill was not written to before, it only gets the columns of the lending repayments
+ALTER TABLE bill
+    ADD COLUMN lending_refer BINARY(16) NULL AFTER payment_url,
+    ADD COLUMN allocated_fee BIGINT NOT NULL DEFAULT 0 AFTER lending_refer,
+    ADD COLUMN allocated_interest BIGINT NOT NULL DEFAULT 0 AFTER allocated_fee,
+    ADD COLUMN allocated_principal BIGINT NOT NULL DEFAULT 0 AFTER allocated_interest,
+    ADD COLUMN unallocated BIGINT NOT NULL DEFAULT 0 AFTER allocated_principal,
+    ADD COLUMN paid_at TIMESTAMP NULL AFTER unallocated,
+    ADD INDEX (paid_at),
+    ADD INDEX (lending_refer, paid_at),
+    ADD FOREIGN KEY (lending_refer) REFERENCES lending(id);
@@ -4,6 +4,10 @@ CREATE TABLE IF NOT EXISTS users (
     hashed_password BINARY(60) NOT NULL,
     is_admin BOOL DEFAULT FALSE,
     is_user BOOL DEFAULT FALSE,
+    is_approver BOOL DEFAULT FALSE,
+    is_document_viewer BOOL DEFAULT FALSE,
+    # funds approved lending through the marketplace
+    is_lender BOOL DEFAULT FALSE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 );
@@ -68,32 +72,185 @@ CREATE TABLE IF NOT EXISTS orders(
     FOREIGN KEY (product_refer) REFERENCES products(id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS documents(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    user_refer BINARY(16) NOT NULL,
+    # kk, ktp or collateral
+    type VARCHAR(16) NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    # sha256 hex of the uploaded content
+    content_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_refer) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS document_access_logs(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    document_refer BINARY(16) NOT NULL,
+    # NULL when accessed through a signed url
+    user_refer BINARY(16) NULL,
+    # owner, staff, signed or sign (minting a signed url)
+    access_type VARCHAR(16) NOT NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (document_refer) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS pricing_grids(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    version VARCHAR(64) NOT NULL UNIQUE,
+    # only one grid is active at a time, it prices the new offers
+    is_active BOOL DEFAULT FALSE,
+    created_by BINARY(16) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS pricing_grid_rows(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    grid_refer BINARY(16) NOT NULL,
+    # matches the prediction of the latest credit score
+    score_label VARCHAR(64) NOT NULL,
+    # tenor and amount ranges are inclusive
+    tenor_min INT NOT NULL,
+    tenor_max INT NOT NULL,
+    amount_min DECIMAL(10,2) NOT NULL,
+    amount_max DECIMAL(10,2) NOT NULL,
+    # yearly percentage
+    interest_rate INT NOT NULL,
+    FOREIGN KEY (grid_refer) REFERENCES pricing_grids(id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS lending(
     id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
     user_refer BINARY(16) NOT NULL,
     amount DECIMAL(10,2) NOT NULL,
-    interest_rate INT NOT NULL,
+    # yearly percentage, 0 until priced by the pricing grid. flat_total lending predate the yearly rates, their rate is
+    # the percentage of the amount charged over the whole tenor
+    interest_rate INT NOT NULL DEFAULT 0,
+    # the product of the lending rules, NULL for the lending that predate the products
+    product VARCHAR(32) NULL,
+    # flat, annuity or declining_balance taken from the product, or flat_total
+    interest_method VARCHAR(32) NOT NULL DEFAULT 'flat',
     tenor INT NOT NULL,
     -- ml params
     age INT NOT NULL,
+    # the codes of models.Gender, models.Education, models.MaritalStatus and models.HomeOwnership
     # 0 = male
     gender bool DEFAULT FALSE,
     income DECIMAL(10,2) NOT NULL,
-    last_education VARCHAR(255) NULL,
+    # 0 = SMA, 1 = D3, 2 = S1, 3 = S2, 4 = S3
+    last_education TINYINT NOT NULL DEFAULT 0,
+    # 0 = single
     marital_status BOOL DEFAULT FALSE,
     number_of_children INT NOT NULL,
+    # 0 = renting
     home_ownership BOOL DEFAULT FALSE,
     -- ml params
     kk_url VARCHAR(255) NULL,
     ktp_url VARCHAR(255) NULL,
+    kk_document_refer BINARY(16) NULL,
+    ktp_document_refer BINARY(16) NULL,
     is_approved BOOL DEFAULT FALSE,
     is_rejected BOOL DEFAULT FALSE,
+    # pending_offer, offered, pending, awaiting_second_approval, approved, rejected, cancelled, disbursing, disbursed
+    # or paid
     status VARCHAR(32) NOT NULL,
     payment_token VARCHAR(255) NULL,
     payment_url VARCHAR(255) NULL,
     is_paid BOOL DEFAULT FALSE,
+    pricing_grid_refer BINARY(16) NULL,
+    # the credit score the offer was priced with
+    offer_credit_score_refer BINARY(16) NULL,
+    offered_at TIMESTAMP NULL,
+    # the offer has to be accepted before it, an expired offer is priced again on request
+    offer_expires_at TIMESTAMP NULL,
+    offer_accepted_at TIMESTAMP NULL,
+    # set once the commitments of the lenders reach the amount
+    funded_at TIMESTAMP NULL,
+    # set once the payout is confirmed, the repayment schedule starts from it
+    disbursed_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX (disbursed_at),
+    FOREIGN KEY (pricing_grid_refer) REFERENCES pricing_grids(id),
+    FOREIGN KEY (kk_document_refer) REFERENCES documents(id),
+    FOREIGN KEY (ktp_document_refer) REFERENCES documents(id)
+);
+
+CREATE TABLE IF NOT EXISTS lending_revisions(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL,
+    revision INT NOT NULL,
+    # edit, cancel, guarantor or collateral
+    action VARCHAR(16) NOT NULL,
+    reason VARCHAR(255) NULL,
+    # the proposal as it was before this revision
+    previous JSON NOT NULL,
+    created_by BINARY(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (lending_refer, revision),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS lending_guarantors(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    nik CHAR(16) NOT NULL,
+    phone_number VARCHAR(16) NOT NULL,
+    relationship VARCHAR(32) NOT NULL,
+    age INT NOT NULL,
+    income DECIMAL(10,2) NOT NULL,
+    # uploaded by the borrower on behalf of the guarantor
+    ktp_document_refer BINARY(16) NOT NULL,
+    # invited, confirmed or declined
+    status VARCHAR(16) NOT NULL,
+    # sha256 of the invitation token, the token itself is only texted to the phone number of the guarantor
+    invitation_hash CHAR(64) NOT NULL UNIQUE,
+    invitation_expires_at TIMESTAMP NOT NULL,
+    responded_at TIMESTAMP NULL,
+    responded_ip_address VARCHAR(45) NULL,
+    responded_user_agent VARCHAR(255) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (lending_refer, nik),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id) ON DELETE CASCADE,
+    FOREIGN KEY (ktp_document_refer) REFERENCES documents(id)
+);
+
+CREATE TABLE IF NOT EXISTS collaterals(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL,
+    # vehicle, land or inventory
+    type VARCHAR(16) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    # BPKB number, land certificate number or inventory reference
+    identifier VARCHAR(64) NOT NULL,
+    # pending_appraisal, pledged or released
+    status VARCHAR(32) NOT NULL,
+    appraisal_value DECIMAL(14,2) NULL,
+    appraised_by BINARY(16) NULL,
+    appraised_at TIMESTAMP NULL,
+    # set once the lending is repaid, rejected or cancelled
+    released_at TIMESTAMP NULL,
+    # NULL once released so an item secures at most one lending at a time
+    active_identifier VARCHAR(81) AS (IF(status = 'released', NULL, CONCAT(type, ':', identifier))) STORED,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (type, identifier),
+    UNIQUE (active_identifier),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id) ON DELETE CASCADE,
+    FOREIGN KEY (appraised_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS collateral_documents(
+    collateral_refer BINARY(16) NOT NULL,
+    document_refer BINARY(16) NOT NULL,
+    PRIMARY KEY (collateral_refer, document_refer),
+    FOREIGN KEY (collateral_refer) REFERENCES collaterals(id) ON DELETE CASCADE,
+    FOREIGN KEY (document_refer) REFERENCES documents(id)
 );
 
 CREATE TABLE IF NOT EXISTS bill(
@@ -104,6 +261,264 @@ CREATE TABLE IF NOT EXISTS bill(
     status VARCHAR(32) NOT NULL,
     payment_token VARCHAR(255) NULL,
     payment_url VARCHAR(255) NULL,
+    # set for the repayment of a lending, the allocation is filled once the payment settles
+    lending_refer BINARY(16) NULL,
+    allocated_fee BIGINT NOT NULL DEFAULT 0,
+    allocated_interest BIGINT NOT NULL DEFAULT 0,
+    allocated_principal BIGINT NOT NULL DEFAULT 0,
+    # paid above the outstanding balance, to be refunded
+    unallocated BIGINT NOT NULL DEFAULT 0,
+    paid_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-);
\ No newline at end of file
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX (paid_at),
+    INDEX (lending_refer, paid_at),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id)
+);
+
+CREATE TABLE IF NOT EXISTS lending_installments(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL,
+    number INT NOT NULL,
+    due_date DATE NOT NULL,
+    # whole rupiah, principal and interest are recalculated after a prepayment
+    principal BIGINT NOT NULL,
+    interest BIGINT NOT NULL,
+    # late fee
+    fee BIGINT NOT NULL DEFAULT 0,
+    paid_principal BIGINT NOT NULL DEFAULT 0,
+    paid_interest BIGINT NOT NULL DEFAULT 0,
+    paid_fee BIGINT NOT NULL DEFAULT 0,
+    is_paid BOOL DEFAULT FALSE,
+    # NULL for installments settled before it was recorded
+    paid_at TIMESTAMP NULL,
+    UNIQUE (lending_refer, number),
+    INDEX (due_date),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS agreements(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL UNIQUE,
+    # the generated pdf in go-blob and its sha256, the borrower accepts this exact document
+    file_name VARCHAR(255) NOT NULL,
+    content_hash CHAR(64) NOT NULL,
+    template_version VARCHAR(64) NOT NULL,
+    # the terms rendered in the pdf, the lending is disbursed and scheduled with them
+    amount BIGINT NOT NULL,
+    fee BIGINT NOT NULL,
+    interest_rate INT NOT NULL,
+    tenor INT NOT NULL,
+    interest_method VARCHAR(32) NOT NULL,
+    accepted_at TIMESTAMP NULL,
+    accepted_ip_address VARCHAR(64) NULL,
+    accepted_user_agent VARCHAR(255) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (lending_refer) REFERENCES lending(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS bank_accounts(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    user_refer BINARY(16) NOT NULL,
+    bank_code VARCHAR(32) NOT NULL,
+    account_number VARCHAR(20) NOT NULL,
+    account_holder VARCHAR(255) NOT NULL,
+    # lending is disbursed to the primary account unless another one is chosen
+    is_primary BOOL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_refer, bank_code, account_number),
+    FOREIGN KEY (user_refer) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS disbursements(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL,
+    bank_account_refer BINARY(16) NOT NULL,
+    # whole rupiah sent to the borrower, the lending amount minus the origination fee
+    amount BIGINT NOT NULL,
+    fee BIGINT NOT NULL DEFAULT 0,
+    # pending, processing, completed or failed
+    status VARCHAR(16) NOT NULL,
+    # reference of the payout provider
+    reference_no VARCHAR(255) NULL,
+    failure_reason VARCHAR(255) NULL,
+    created_by BINARY(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (lending_refer) REFERENCES lending(id),
+    FOREIGN KEY (bank_account_refer) REFERENCES bank_accounts(id),
+    FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS credit_scores(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL,
+    # the feature snapshot sent to the model and its raw response
+    features JSON NOT NULL,
+    raw_output JSON NOT NULL,
+    prediction VARCHAR(64) NULL,
+    model_version VARCHAR(64) NULL,
+    # ml or scorecard
+    engine VARCHAR(16) NOT NULL DEFAULT 'ml',
+    # numeric score when the engine provides one, the probability for ml and the points for scorecard
+    score DOUBLE NULL,
+    threshold DOUBLE NULL,
+    # per feature contributions and reason codes
+    reasons JSON NULL,
+    # why the primary engine was not used
+    fallback_reason VARCHAR(255) NULL,
+    # NULL when scored by the system
+    created_by BINARY(16) NULL,
+    created_at TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX (lending_refer, created_at),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS scoring_batches(
+    id BINARY(16) PRIMARY KEY,
+    # running or completed
+    status VARCHAR(16) NOT NULL,
+    total INT NOT NULL,
+    succeeded INT NOT NULL DEFAULT 0,
+    failed INT NOT NULL DEFAULT 0,
+    # the lending that could not be scored with their error, set once completed
+    failures JSON NULL,
+    created_by BINARY(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP NULL,
+    INDEX (status, created_at),
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS lending_decisions(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL,
+    policy_version VARCHAR(64) NOT NULL,
+    credit_score_refer BINARY(16) NULL,
+    # approve, reject or manual
+    outcome VARCHAR(16) NOT NULL,
+    reasons JSON NOT NULL,
+    # FALSE when the policy is disabled or the proposal was decided in the meantime
+    applied BOOL DEFAULT FALSE,
+    # NULL when triggered by the system
+    decided_by BINARY(16) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (lending_refer) REFERENCES lending(id) ON DELETE CASCADE,
+    FOREIGN KEY (credit_score_refer) REFERENCES credit_scores(id)
+);
+
+CREATE TABLE IF NOT EXISTS lending_approvals(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL,
+    # NULL when approved by the decision policy without an admin
+    approver_refer BINARY(16) NULL,
+    # the score the approver reviewed, NULL when approved without scoring
+    credit_score_refer BINARY(16) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (lending_refer, approver_refer),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id) ON DELETE CASCADE,
+    FOREIGN KEY (approver_refer) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (credit_score_refer) REFERENCES credit_scores(id)
+);
+
+CREATE TABLE IF NOT EXISTS export_logs(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    # loans, borrowers, repayments or delinquencies
+    report VARCHAR(32) NOT NULL,
+    # csv or xlsx
+    format VARCHAR(8) NOT NULL,
+    period_from DATE NOT NULL,
+    period_to DATE NOT NULL,
+    # running, completed or failed
+    status VARCHAR(16) NOT NULL,
+    row_count INT NOT NULL DEFAULT 0,
+    error VARCHAR(255) NULL,
+    created_by BINARY(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP NULL,
+    INDEX (created_at),
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS lending_commitments(
+    lending_refer BINARY(16) NOT NULL,
+    lender_refer BINARY(16) NOT NULL,
+    # rupiah, a lender tops up their single commitment
+    amount BIGINT NOT NULL,
+    # set once the lender transferred the commitment to the platform
+    collected_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (lending_refer, lender_refer),
+    INDEX (lender_refer),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id) ON DELETE CASCADE,
+    FOREIGN KEY (lender_refer) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS lender_distributions(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    bill_refer BINARY(16) NOT NULL,
+    lending_refer BINARY(16) NOT NULL,
+    lender_refer BINARY(16) NOT NULL,
+    # the pro rata share of the principal and interest allocated by the bill, in rupiah
+    principal BIGINT NOT NULL,
+    # net of the commission kept by the platform
+    interest BIGINT NOT NULL,
+    commission BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (lender_refer, created_at),
+    INDEX (lending_refer, lender_refer),
+    FOREIGN KEY (bill_refer) REFERENCES bill(id),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id),
+    FOREIGN KEY (lender_refer) REFERENCES users(id)
+);
+
+# bank transfers with the lenders made outside of the api, recorded by an admin
+CREATE TABLE IF NOT EXISTS lender_transfers(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    lending_refer BINARY(16) NOT NULL,
+    lender_refer BINARY(16) NOT NULL,
+    # collection of the commitment or payout of the distributions
+    type VARCHAR(16) NOT NULL,
+    amount BIGINT NOT NULL,
+    reference_no VARCHAR(255) NULL,
+    created_by BINARY(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (lending_refer, lender_refer),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id),
+    FOREIGN KEY (lender_refer) REFERENCES users(id),
+    FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+# the ledger is append-only, a correction is posted as a new entry
+CREATE TABLE IF NOT EXISTS journal_entries(
+    id BINARY(16) PRIMARY KEY DEFAULT (UUID_TO_BIN(UUID())),
+    # funding, disbursement, repayment, distribution, collection or lender_payout
+    reference_type VARCHAR(16) NOT NULL,
+    # the lending, disbursement, bill or lender transfer of the money movement
+    reference_id BINARY(16) NOT NULL,
+    lending_refer BINARY(16) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (reference_type, reference_id),
+    INDEX (lending_refer),
+    INDEX (created_at),
+    FOREIGN KEY (lending_refer) REFERENCES lending(id)
+);
+
+CREATE TABLE IF NOT EXISTS journal_lines(
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    entry_refer BINARY(16) NOT NULL,
+    # platform_cash, borrower_receivable, commitment_receivable, lender_payable, interest_income, fee_income or
+    # commission_income
+    account VARCHAR(32) NOT NULL,
+    # the borrower or lender of the line
+    party_refer BINARY(16) NULL,
+    # rupiah, exactly one of debit and credit is set
+    debit BIGINT NOT NULL DEFAULT 0,
+    credit BIGINT NOT NULL DEFAULT 0,
+    INDEX (account),
+    FOREIGN KEY (entry_refer) REFERENCES journal_entries(id),
+    FOREIGN KEY (party_refer) REFERENCES users(id)
+);
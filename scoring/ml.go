@@ -0,0 +1,101 @@
+package scoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// mlHttpClient bounds every call to the model server, the request context may carry a shorter deadline
+var mlHttpClient = &http.Client{Timeout: 10 * time.Second}
+
+// MLClient calls the flask /predict endpoint
+type MLClient struct {
+	BaseUrl string
+}
+
+// mlPredictResponse is the body returned by the flask /predict endpoint, every field besides predictions is optional
+// as older model builds do not return them
+type mlPredictResponse struct {
+	Predictions  []string `json:"predictions"`
+	ModelVersion string   `json:"model_version"`
+	Probability  *float64 `json:"probability"`
+	Threshold    *float64 `json:"threshold"`
+	// Contributions is keyed by the feature name of Features, e.g. SHAP values
+	Contributions map[string]float64 `json:"contributions"`
+	Reasons       []Reason           `json:"reasons"`
+}
+
+func (m *MLClient) Score(ctx context.Context, features Features) (Result, error) {
+	body, err := json.Marshal(features)
+	if err != nil {
+		return Result{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", m.BaseUrl+"/predict", bytes.NewBuffer(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := mlHttpClient.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("ml server error")
+	}
+
+	rawOutput, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, err
+	}
+	var output mlPredictResponse
+	err = json.Unmarshal(rawOutput, &output)
+	if err != nil {
+		return Result{}, err
+	}
+	// older model builds only expose the version through the header
+	if output.ModelVersion == "" {
+		output.ModelVersion = resp.Header.Get("X-Model-Version")
+	}
+	//	reason codes from the model take precedence, otherwise derive them from the contributions
+	reasons := output.Reasons
+	if len(reasons) == 0 {
+		for feature, contribution := range output.Contributions {
+			reasons = append(reasons, contributionReason(feature, contribution))
+		}
+	}
+	sortReasons(reasons)
+
+	return Result{
+		Engine:       EngineML,
+		Predictions:  output.Predictions,
+		ModelVersion: output.ModelVersion,
+		Score:        output.Probability,
+		Threshold:    output.Threshold,
+		Reasons:      reasons,
+		RawOutput:    rawOutput,
+	}, nil
+}
+
+func contributionReason(feature string, contribution float64) Reason {
+	code := strings.ToLower(feature) + "_positive"
+	message := feature + " increased the score"
+	if contribution < 0 {
+		code = strings.ToLower(feature) + "_negative"
+		message = feature + " decreased the score"
+	}
+	return Reason{
+		Feature:      feature,
+		Code:         code,
+		Contribution: contribution,
+		Message:      message,
+	}
+}
@@ -0,0 +1,241 @@
+package scoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Band awards Points when Min <= value < Max, a nil Max is unbounded
+type Band struct {
+	Min    float64  `json:"min"`
+	Max    *float64 `json:"max,omitempty"`
+	Points float64  `json:"points"`
+}
+
+// Label is given when the total points are at least Min
+type Label struct {
+	Min   float64 `json:"min"`
+	Label string  `json:"label"`
+}
+
+// Scorecard is a rule based engine, every feature is matched against its bands and the points are summed
+type Scorecard struct {
+	Version          string  `json:"version"`
+	BasePoints       float64 `json:"base_points"`
+	Age              []Band  `json:"age"`
+	Income           []Band  `json:"income"`
+	Education        []Band  `json:"education"`
+	MaritalStatus    []Band  `json:"marital_status"`
+	NumberOfChildren []Band  `json:"number_of_children"`
+	HomeOwnership    []Band  `json:"home_ownership"`
+	// GuarantorIncome is matched against the summed income of the guarantors, it is only scored when there is one
+	GuarantorIncome []Band `json:"guarantor_income"`
+	// Labels follow the classes of the ml model, they are checked in order so the highest Min goes first
+	Labels []Label `json:"labels"`
+	// Threshold is the total points under which a borrower is considered a bad risk
+	Threshold float64 `json:"threshold"`
+}
+
+func bandMax(v float64) *float64 {
+	return &v
+}
+
+var defaultScorecard = Scorecard{
+	Version:    "scorecard-v1",
+	BasePoints: 0,
+	Age: []Band{
+		{Min: 0, Max: bandMax(25), Points: 10},
+		{Min: 25, Max: bandMax(35), Points: 25},
+		{Min: 35, Max: bandMax(50), Points: 35},
+		{Min: 50, Points: 25},
+	},
+	// income is monthly in rupiah
+	Income: []Band{
+		{Min: 0, Max: bandMax(3_000_000), Points: 5},
+		{Min: 3_000_000, Max: bandMax(6_000_000), Points: 20},
+		{Min: 6_000_000, Max: bandMax(12_000_000), Points: 35},
+		{Min: 12_000_000, Points: 50},
+	},
+	// 0 = SMA, 1 = D3, 2 = S1, 3 = S2, 4 = S3
+	Education: []Band{
+		{Min: 0, Max: bandMax(1), Points: 10},
+		{Min: 1, Max: bandMax(2), Points: 20},
+		{Min: 2, Max: bandMax(3), Points: 30},
+		{Min: 3, Max: bandMax(4), Points: 35},
+		{Min: 4, Points: 40},
+	},
+	// 0 = single, 1 = married
+	MaritalStatus: []Band{
+		{Min: 0, Max: bandMax(1), Points: 15},
+		{Min: 1, Points: 25},
+	},
+	NumberOfChildren: []Band{
+		{Min: 0, Max: bandMax(1), Points: 25},
+		{Min: 1, Max: bandMax(3), Points: 20},
+		{Min: 3, Points: 10},
+	},
+	// 0 = rent, 1 = own
+	HomeOwnership: []Band{
+		{Min: 0, Max: bandMax(1), Points: 10},
+		{Min: 1, Points: 30},
+	},
+	GuarantorIncome: []Band{
+		{Min: 0, Max: bandMax(3_000_000), Points: 5},
+		{Min: 3_000_000, Max: bandMax(6_000_000), Points: 10},
+		{Min: 6_000_000, Points: 15},
+	},
+	Labels: []Label{
+		{Min: 150, Label: "High"},
+		{Min: 110, Label: "Average"},
+		{Min: 0, Label: "Low"},
+	},
+	Threshold: 110,
+}
+
+// loadScorecard reads the scorecard from path, the default scorecard is used when path is empty
+func loadScorecard(path string) (*Scorecard, error) {
+	scorecard := defaultScorecard
+	if path == "" {
+		return &scorecard, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&scorecard)
+	if err != nil {
+		return nil, err
+	}
+	if len(scorecard.Labels) == 0 {
+		return nil, fmt.Errorf("scorecard has no labels")
+	}
+	return &scorecard, nil
+}
+
+// scorecardOutput is stored as the raw output so the points of every feature can be audited
+type scorecardOutput struct {
+	Points map[string]float64 `json:"points"`
+	Total  float64            `json:"total"`
+	Label  string             `json:"label"`
+}
+
+// matchBand returns the matched band, nil when the value does not fall in any band
+func matchBand(bands []Band, value float64) *Band {
+	for i, band := range bands {
+		if value >= band.Min && (band.Max == nil || value < *band.Max) {
+			return &bands[i]
+		}
+	}
+	return nil
+}
+
+func maxPoints(bands []Band) float64 {
+	var best float64
+	for _, band := range bands {
+		if band.Points > best {
+			best = band.Points
+		}
+	}
+	return best
+}
+
+// scoreFeature awards the points of the matched band, the contribution is how many points were lost compared to the
+// best band of the feature
+func scoreFeature(feature string, bands []Band, value float64) (float64, Reason) {
+	best := maxPoints(bands)
+	band := matchBand(bands, value)
+	if band == nil {
+		return 0, Reason{
+			Feature:      feature,
+			Code:         feature + "_out_of_band",
+			Contribution: -best,
+			Message:      fmt.Sprintf("%s %.0f is outside every band", feature, value),
+		}
+	}
+	if band.Points >= best {
+		return band.Points, Reason{
+			Feature:      feature,
+			Code:         feature + "_top_band",
+			Contribution: 0,
+			Message:      fmt.Sprintf("%s is in the best band", feature),
+		}
+	}
+	bandRange := fmt.Sprintf("%.0f+", band.Min)
+	if band.Max != nil {
+		bandRange = fmt.Sprintf("%.0f-%.0f", band.Min, *band.Max)
+	}
+	return band.Points, Reason{
+		Feature:      feature,
+		Code:         feature + "_below_band",
+		Contribution: band.Points - best,
+		Message: fmt.Sprintf(
+			"%s below band, %s earns %.0f of %.0f points", feature, bandRange, band.Points, best,
+		),
+	}
+}
+
+func (s *Scorecard) Score(_ context.Context, features Features) (Result, error) {
+	bands := []struct {
+		feature string
+		bands   []Band
+		value   float64
+	}{
+		{"age", s.Age, float64(features.Age)},
+		{"income", s.Income, float64(features.Income)},
+		{"education", s.Education, float64(features.Education)},
+		{"marital_status", s.MaritalStatus, float64(features.MaritalStatus)},
+		{"number_of_children", s.NumberOfChildren, float64(features.NumberOfChildren)},
+		{"home_ownership", s.HomeOwnership, float64(features.HomeOwnership)},
+	}
+	if len(features.Guarantors) > 0 && len(s.GuarantorIncome) > 0 {
+		var income int
+		for _, guarantor := range features.Guarantors {
+			income += guarantor.Income
+		}
+		bands = append(
+			bands, struct {
+				feature string
+				bands   []Band
+				value   float64
+			}{"guarantor_income", s.GuarantorIncome, float64(income)},
+		)
+	}
+	output := scorecardOutput{
+		Points: map[string]float64{},
+		Total:  s.BasePoints,
+	}
+	reasons := make([]Reason, 0, len(bands))
+	for _, b := range bands {
+		points, reason := scoreFeature(b.feature, b.bands, b.value)
+		output.Points[b.feature] = points
+		output.Total += points
+		reasons = append(reasons, reason)
+	}
+	sortReasons(reasons)
+	for _, label := range s.Labels {
+		if output.Total >= label.Min {
+			output.Label = label.Label
+			break
+		}
+	}
+
+	rawOutput, err := json.Marshal(output)
+	if err != nil {
+		return Result{}, err
+	}
+	total := output.Total
+	threshold := s.Threshold
+	return Result{
+		Engine:       EngineScorecard,
+		Predictions:  []string{output.Label},
+		ModelVersion: s.Version,
+		Score:        &total,
+		Threshold:    &threshold,
+		Reasons:      reasons,
+		RawOutput:    rawOutput,
+	}, nil
+}
@@ -0,0 +1,187 @@
+package scoring
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMatchBand(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		want  float64
+		found bool
+	}{
+		{name: "first band", value: 18, want: 10, found: true},
+		{name: "min is inclusive", value: 25, want: 25, found: true},
+		{name: "max is exclusive", value: 34.99, want: 25, found: true},
+		{name: "next band starts at max", value: 35, want: 35, found: true},
+		{name: "unbounded last band", value: 50, want: 25, found: true},
+		{name: "far in the unbounded band", value: 120, want: 25, found: true},
+		{name: "below every band", value: -1},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				band := matchBand(defaultScorecard.Age, tt.value)
+				if (band != nil) != tt.found {
+					t.Fatalf("got band %v, want found %v", band, tt.found)
+				}
+				if band != nil && band.Points != tt.want {
+					t.Fatalf("got %.0f points, want %.0f", band.Points, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestScoreFeature(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      float64
+		wantPoints float64
+		wantReason Reason
+	}{
+		{
+			name: "top band", value: 12_000_000, wantPoints: 50,
+			wantReason: Reason{
+				Feature: "income", Code: "income_top_band", Contribution: 0, Message: "income is in the best band",
+			},
+		},
+		{
+			name: "below band", value: 5_999_999, wantPoints: 20,
+			wantReason: Reason{
+				Feature: "income", Code: "income_below_band", Contribution: -30,
+				Message: "income below band, 3000000-6000000 earns 20 of 50 points",
+			},
+		},
+		{
+			name: "out of band", value: -1, wantPoints: 0,
+			wantReason: Reason{
+				Feature: "income", Code: "income_out_of_band", Contribution: -50,
+				Message: "income -1 is outside every band",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				points, reason := scoreFeature("income", defaultScorecard.Income, tt.value)
+				if points != tt.wantPoints {
+					t.Fatalf("got %.0f points, want %.0f", points, tt.wantPoints)
+				}
+				if reason != tt.wantReason {
+					t.Fatalf("got %+v, want %+v", reason, tt.wantReason)
+				}
+			},
+		)
+	}
+}
+
+func TestScorecardScore(t *testing.T) {
+	tests := []struct {
+		name        string
+		features    Features
+		wantTotal   float64
+		wantLabel   string
+		wantReasons []string
+	}{
+		{
+			name: "high",
+			features: Features{
+				Age: 30, Income: 7_000_000, Education: 2, MaritalStatus: 1, NumberOfChildren: 0, HomeOwnership: 1,
+			},
+			wantTotal: 170,
+			wantLabel: "High",
+			wantReasons: []string{
+				"income_below_band", "age_below_band", "education_below_band", "marital_status_top_band",
+				"number_of_children_top_band", "home_ownership_top_band",
+			},
+		},
+		{
+			name: "low",
+			features: Features{
+				Age: 22, Income: 2_000_000, Education: 0, MaritalStatus: 0, NumberOfChildren: 3, HomeOwnership: 0,
+			},
+			wantTotal: 60,
+			wantLabel: "Low",
+			wantReasons: []string{
+				"income_below_band", "education_below_band", "age_below_band", "home_ownership_below_band",
+				"number_of_children_below_band", "marital_status_below_band",
+			},
+		},
+		{
+			name: "guarantor income is scored",
+			features: Features{
+				Age: 22, Income: 2_000_000, Education: 0, MaritalStatus: 0, NumberOfChildren: 3, HomeOwnership: 0,
+				Guarantors: []GuarantorFeatures{{Age: 40, Income: 4_000_000}, {Age: 45, Income: 3_000_000}},
+			},
+			wantTotal: 75,
+			wantLabel: "Low",
+			wantReasons: []string{
+				"income_below_band", "education_below_band", "age_below_band", "home_ownership_below_band",
+				"number_of_children_below_band", "marital_status_below_band", "guarantor_income_top_band",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				scorecard := defaultScorecard
+				res, err := scorecard.Score(context.Background(), tt.features)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Engine != EngineScorecard || *res.Score != tt.wantTotal || *res.Threshold != 110 {
+					t.Fatalf("got engine %s, score %.0f and threshold %.0f", res.Engine, *res.Score, *res.Threshold)
+				}
+				if !reflect.DeepEqual(res.Predictions, []string{tt.wantLabel}) {
+					t.Fatalf("got %v, want %s", res.Predictions, tt.wantLabel)
+				}
+				var codes []string
+				for _, reason := range res.Reasons {
+					codes = append(codes, reason.Code)
+				}
+				if !reflect.DeepEqual(codes, tt.wantReasons) {
+					t.Fatalf("got reasons %v, want %v", codes, tt.wantReasons)
+				}
+				var output scorecardOutput
+				err = json.Unmarshal(res.RawOutput, &output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if output.Total != tt.wantTotal || output.Label != tt.wantLabel {
+					t.Fatalf("got raw output %+v", output)
+				}
+			},
+		)
+	}
+}
+
+func TestScorecardLabels(t *testing.T) {
+	tests := []struct {
+		total float64
+		want  string
+	}{
+		{total: 200, want: "High"},
+		{total: 150, want: "High"},
+		{total: 149.5, want: "Average"},
+		{total: 110, want: "Average"},
+		{total: 109, want: "Low"},
+		{total: 0, want: "Low"},
+		{total: -1, want: ""},
+	}
+	for _, tt := range tests {
+		//	without bands the total is the base points
+		scorecard := Scorecard{BasePoints: tt.total, Labels: defaultScorecard.Labels}
+		res, err := scorecard.Score(context.Background(), Features{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Predictions[0] != tt.want {
+			t.Fatalf("total %.1f got label %q, want %q", tt.total, res.Predictions[0], tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package scoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+const (
+	EngineML        = "ml"
+	EngineScorecard = "scorecard"
+)
+
+// Features is the input of every engine, the json tags follow the flask /predict contract
+type Features struct {
+	Age              int `json:"Age"`
+	Gender           int `json:"Gender"`
+	Income           int `json:"Income"`
+	Education        int `json:"Education"`
+	MaritalStatus    int `json:"Marital_Status"`
+	NumberOfChildren int `json:"Number_of_Children"`
+	HomeOwnership    int `json:"Home_Ownership"`
+	// Guarantors are the guarantors who confirmed their participation, omitted when there are none so older model
+	// builds keep receiving the same request
+	Guarantors []GuarantorFeatures `json:"Guarantors,omitempty"`
+}
+
+type GuarantorFeatures struct {
+	Age    int `json:"Age"`
+	Income int `json:"Income"`
+}
+
+// Reason explains how a single feature moved the score, a negative Contribution pulled the score down
+type Reason struct {
+	Feature      string  `json:"feature"`
+	Code         string  `json:"code"`
+	Contribution float64 `json:"contribution"`
+	Message      string  `json:"message"`
+}
+
+type Result struct {
+	// Engine is the engine that actually produced the result, which differs from the configured one on fallback
+	Engine       string
+	Predictions  []string
+	ModelVersion string
+	// Score is nil when the engine only returns a label, it is a probability for ml and the points for scorecard
+	Score *float64
+	// Threshold is the decision threshold Score is compared against, nil when the engine does not provide one
+	Threshold *float64
+	// Reasons are ordered from the most negative contribution
+	Reasons   []Reason
+	RawOutput json.RawMessage
+	// FallbackReason is set when the primary engine failed and the scorecard took over
+	FallbackReason string
+}
+
+type Engine interface {
+	Score(ctx context.Context, features Features) (Result, error)
+}
+
+var primary Engine
+var fallback Engine
+
+// Initialize picks the primary engine from CREDIT_SCORE_ENGINE (ml by default), when it is ml the scorecard is used as
+// fallback unless CREDIT_SCORE_FALLBACK is "false"
+func Initialize() error {
+	scorecard, err := loadScorecard(os.Getenv("SCORECARD_FILE"))
+	if err != nil {
+		return err
+	}
+
+	switch engine := os.Getenv("CREDIT_SCORE_ENGINE"); engine {
+	case "", EngineML:
+		primary = &MLClient{BaseUrl: os.Getenv("FLASK_ML_BASE_URL")}
+		if os.Getenv("CREDIT_SCORE_FALLBACK") != "false" {
+			fallback = scorecard
+		}
+	case EngineScorecard:
+		primary = scorecard
+	default:
+		return fmt.Errorf("unknown CREDIT_SCORE_ENGINE %s", engine)
+	}
+	return nil
+}
+
+// Score runs the primary engine and falls back to the scorecard when the primary fails
+func Score(ctx context.Context, features Features) (Result, error) {
+	res, err := primary.Score(ctx, features)
+	if err == nil || fallback == nil {
+		return res, err
+	}
+	log.Print("credit score engine failed, using fallback: ", err)
+
+	res, fallbackErr := fallback.Score(ctx, features)
+	if fallbackErr != nil {
+		return Result{}, fallbackErr
+	}
+	res.FallbackReason = err.Error()
+	return res, nil
+}
+
+func sortReasons(reasons []Reason) {
+	sort.SliceStable(
+		reasons, func(i, j int) bool {
+			return reasons[i].Contribution < reasons[j].Contribution
+		},
+	)
+}
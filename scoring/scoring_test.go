@@ -0,0 +1,61 @@
+package scoring
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type fakeEngine struct {
+	res Result
+	err error
+}
+
+func (f fakeEngine) Score(context.Context, Features) (Result, error) {
+	return f.res, f.err
+}
+
+func TestScore(t *testing.T) {
+	ml := fakeEngine{res: Result{Engine: EngineML, Predictions: []string{"High"}}}
+	scorecard := fakeEngine{res: Result{Engine: EngineScorecard, Predictions: []string{"Average"}}}
+	mlDown := fakeEngine{err: errors.New("ml unavailable")}
+	scorecardBroken := fakeEngine{err: errors.New("scorecard broken")}
+
+	tests := []struct {
+		name               string
+		primary            Engine
+		fallback           Engine
+		wantEngine         string
+		wantFallbackReason string
+		wantErr            string
+	}{
+		{name: "primary", primary: ml, fallback: scorecard, wantEngine: EngineML},
+		{
+			name: "fallback when the primary fails", primary: mlDown, fallback: scorecard,
+			wantEngine: EngineScorecard, wantFallbackReason: "ml unavailable",
+		},
+		{name: "no fallback configured", primary: mlDown, wantErr: "ml unavailable"},
+		{name: "fallback fails too", primary: mlDown, fallback: scorecardBroken, wantErr: "scorecard broken"},
+	}
+	defer func(p Engine, f Engine) { primary, fallback = p, f }(primary, fallback)
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				primary, fallback = tt.primary, tt.fallback
+				res, err := Score(context.Background(), Features{})
+				if tt.wantErr != "" {
+					if err == nil || err.Error() != tt.wantErr {
+						t.Fatalf("got error %v, want %s", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Engine != tt.wantEngine || res.FallbackReason != tt.wantFallbackReason {
+					t.Fatalf("got engine %s and fallback reason %q", res.Engine, res.FallbackReason)
+				}
+			},
+		)
+	}
+}
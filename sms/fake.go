@@ -0,0 +1,42 @@
+package sms
+
+import (
+	"context"
+	"sync"
+)
+
+// Message is a text message kept by FakeSender
+type Message struct {
+	PhoneNumber string
+	Message     string
+}
+
+// FakeSender keeps the messages in memory instead of sending them
+type FakeSender struct {
+	// Err is returned for every message when set, to simulate the gateway being unreachable
+	Err error
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewFakeSender() *FakeSender {
+	return &FakeSender{}
+}
+
+func (f *FakeSender) Send(_ context.Context, phoneNumber string, message string) error {
+	if f.Err != nil {
+		return f.Err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, Message{PhoneNumber: phoneNumber, Message: message})
+	return nil
+}
+
+// Messages returns the messages sent so far
+func (f *FakeSender) Messages() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.messages...)
+}
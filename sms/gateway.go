@@ -0,0 +1,47 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// gatewayHttpClient bounds every call to the gateway, the request context may carry a shorter deadline
+var gatewayHttpClient = &http.Client{Timeout: 10 * time.Second}
+
+// GatewaySender posts the message as json to Url with ApiKey as bearer token
+type GatewaySender struct {
+	Url    string
+	ApiKey string
+}
+
+type gatewayMessage struct {
+	To      string `json:"to"`
+	Message string `json:"message"`
+}
+
+func (g *GatewaySender) Send(ctx context.Context, phoneNumber string, message string) error {
+	body, err := json.Marshal(gatewayMessage{To: phoneNumber, Message: message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", g.Url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+g.ApiKey)
+
+	res, err := gatewayHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("sms gateway error %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Sender delivers a text message to a phone number
+type Sender interface {
+	Send(ctx context.Context, phoneNumber string, message string) error
+}
+
+// sender is nil when no gateway is configured, in which case nothing that needs a message can be done
+var sender Sender
+
+// Initialize uses the http gateway when SMS_GATEWAY_URL is set, the in memory FakeSender when SMS_FAKE is "true"
+func Initialize() error {
+	if baseUrl := os.Getenv("SMS_GATEWAY_URL"); baseUrl != "" {
+		apiKey := os.Getenv("SMS_GATEWAY_KEY")
+		if apiKey == "" {
+			return fmt.Errorf("SMS_GATEWAY_KEY is required")
+		}
+		sender = &GatewaySender{Url: baseUrl, ApiKey: apiKey}
+		return nil
+	}
+	if os.Getenv("SMS_FAKE") == "true" {
+		sender = NewFakeSender()
+	}
+	return nil
+}
+
+// SetSender replaces the configured sender, mainly used to plug FakeSender
+func SetSender(s Sender) {
+	sender = s
+}
+
+func Send(ctx context.Context, phoneNumber string, message string) error {
+	if sender == nil {
+		return fmt.Errorf("sms is not configured")
+	}
+	return sender.Send(ctx, phoneNumber, message)
+}
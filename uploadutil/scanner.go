@@ -0,0 +1,140 @@
+package uploadutil
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scanner inspects an upload for malware, it returns an *InfectedError when a signature matches
+type Scanner interface {
+	Scan(ctx context.Context, content []byte) error
+}
+
+type InfectedError struct {
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return "file infected: " + e.Signature
+}
+
+// ErrScannerUnavailable rejects every upload while no scanner is configured, uploads are never stored unscanned unless
+// scanning is explicitly disabled
+var ErrScannerUnavailable = errors.New("upload scanning is not configured")
+
+// scanner is nil when CLAMD_ADDRESS is not set, in which case uploads are rejected unless scanDisabled
+var scanner Scanner
+
+// scanDisabled is set by UPLOAD_SCAN_DISABLED "true" for environments without clamd, e.g. local development
+var scanDisabled bool
+
+func InitializeScanner() {
+	address := os.Getenv("CLAMD_ADDRESS")
+	if address != "" {
+		scanner = &ClamdScanner{Address: address}
+		return
+	}
+	if os.Getenv("UPLOAD_SCAN_DISABLED") == "true" {
+		scanDisabled = true
+		log.Print("warning: UPLOAD_SCAN_DISABLED is set, uploads are stored without a malware scan")
+		return
+	}
+	log.Print("warning: CLAMD_ADDRESS is not set, every upload is rejected until a scanner is configured")
+}
+
+// SetScanner replaces the configured scanner, mainly used to plug FakeScanner
+func SetScanner(s Scanner) {
+	scanner = s
+}
+
+// clamdChunkSize must stay below StreamMaxLength of clamd.conf
+const clamdChunkSize = 64 << 10
+
+// ClamdScanner speaks the clamd INSTREAM protocol over tcp, e.g. Address "clamav:3310"
+type ClamdScanner struct {
+	Address string
+}
+
+func (c *ClamdScanner) Scan(ctx context.Context, content []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(scanTimeout))
+	}
+
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return err
+	}
+	//	every chunk is prefixed by its length as 4 bytes big endian, a zero length chunk ends the stream
+	size := make([]byte, 4)
+	for start := 0; start < len(content); start += clamdChunkSize {
+		end := start + clamdChunkSize
+		if end > len(content) {
+			end = len(content)
+		}
+		binary.BigEndian.PutUint32(size, uint32(end-start))
+		_, err = conn.Write(size)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Write(content[start:end])
+		if err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	_, err = conn.Write(size)
+	if err != nil {
+		return err
+	}
+
+	//	reply is "stream: OK", "stream: <signature> FOUND" or "<reason> ERROR" terminated by \0
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return err
+	}
+	reply = strings.TrimSuffix(reply, "\x00")
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &InfectedError{Signature: strings.TrimSuffix(reply, " FOUND")}
+	default:
+		return fmt.Errorf("clamd error: %s", reply)
+	}
+}
+
+// FakeScanner flags any content containing one of Signatures, the key is the content and the value the signature name
+type FakeScanner struct {
+	Signatures map[string]string
+	// Err is returned for every scan when set, to simulate clamd being unreachable
+	Err error
+}
+
+func (f *FakeScanner) Scan(_ context.Context, content []byte) error {
+	if f.Err != nil {
+		return f.Err
+	}
+	for pattern, name := range f.Signatures {
+		if strings.Contains(string(content), pattern) {
+			return &InfectedError{Signature: name}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package uploadutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// multipartOverhead is the room left for the multipart boundaries and the other form fields on top of Policy.MaxBytes
+const multipartOverhead = 1 << 20
+
+// maxImagePixels guards against decompression bombs, a 40MP image is already bigger than any phone camera
+const maxImagePixels = 40_000_000
+
+const scanTimeout = 30 * time.Second
+
+// Policy describes what a single upload field accepts
+type Policy struct {
+	MaxBytes int64
+	// AllowedTypes are MIME types as sniffed by http.DetectContentType
+	AllowedTypes []string
+}
+
+var ImagePolicy = Policy{
+	MaxBytes:     5 << 20,
+	AllowedTypes: []string{"image/jpeg", "image/png"},
+}
+
+var DocumentPolicy = Policy{
+	MaxBytes:     10 << 20,
+	AllowedTypes: []string{"image/jpeg", "image/png", "application/pdf"},
+}
+
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+var ErrTooLarge = errors.New("file too large")
+
+// File is an upload that passed validation, images are re-encoded so Content no longer carries EXIF or other metadata
+type File struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// Process reads the multipart field, enforces the policy size limit, sniffs the content type from the magic bytes,
+// decodes and re-encodes images and finally runs the configured Scanner, failing with ErrScannerUnavailable when there
+// is none
+func Process(w http.ResponseWriter, r *http.Request, field string, policy Policy) (File, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, policy.MaxBytes+multipartOverhead)
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return File{}, ErrTooLarge
+		}
+		return File{}, fmt.Errorf("invalid file")
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, policy.MaxBytes+1))
+	if err != nil {
+		return File{}, err
+	}
+	if int64(len(content)) > policy.MaxBytes {
+		return File{}, ErrTooLarge
+	}
+	if len(content) == 0 {
+		return File{}, fmt.Errorf("invalid file")
+	}
+
+	contentType := http.DetectContentType(content)
+	allowed := false
+	for _, t := range policy.AllowedTypes {
+		if t == contentType {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return File{}, fmt.Errorf("invalid file type %s", contentType)
+	}
+
+	if strings.HasPrefix(contentType, "image/") {
+		content, err = sanitizeImage(content, contentType)
+		if err != nil {
+			return File{}, err
+		}
+	}
+
+	switch {
+	case scanner != nil:
+		ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+		defer cancel()
+		err = scanner.Scan(ctx, content)
+		if err != nil {
+			return File{}, err
+		}
+	case !scanDisabled:
+		return File{}, ErrScannerUnavailable
+	}
+
+	return File{
+		Filename:    sanitizeFilename(header.Filename, contentType),
+		ContentType: contentType,
+		Content:     content,
+	}, nil
+}
+
+// sanitizeImage makes sure the image actually decodes and re-encodes it, which drops EXIF and any trailing payload
+func sanitizeImage(content []byte, contentType string) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image")
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("invalid image dimension")
+	}
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image")
+	}
+
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sanitizeFilename strips any directory and replaces the extension with the one matching the sniffed content type
+func sanitizeFilename(name string, contentType string) string {
+	base := filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	if base == "" || base == "." || base == "/" {
+		base = "file"
+	}
+	return base + extensions[contentType]
+}
@@ -0,0 +1,151 @@
+package uploadutil
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newUploadRequest(t *testing.T, filename string, content []byte) (*httptest.ResponseRecorder, *http.Request) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = part.Write(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = form.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return httptest.NewRecorder(), r
+}
+
+func encodePng(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// useScanner plugs s for the duration of the test, a nil s leaves uploads without a scanner
+func useScanner(t *testing.T, s Scanner, disabled bool) {
+	t.Helper()
+	previous, previousDisabled := scanner, scanDisabled
+	SetScanner(s)
+	scanDisabled = disabled
+	t.Cleanup(
+		func() {
+			scanner, scanDisabled = previous, previousDisabled
+		},
+	)
+}
+
+func TestProcess(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+	policy := Policy{MaxBytes: 1 << 10, AllowedTypes: []string{"image/png", "application/pdf"}}
+	scanErr := errors.New("connection refused")
+
+	tests := []struct {
+		name        string
+		filename    string
+		content     []byte
+		scanner     Scanner
+		disabled    bool
+		wantErr     error
+		wantInvalid bool
+		wantInfect  string
+		wantName    string
+		wantType    string
+	}{
+		{
+			name: "clean pdf", filename: "../../etc/statement.PDF", content: pdf,
+			scanner: &FakeScanner{}, wantName: "statement.pdf", wantType: "application/pdf",
+		},
+		{
+			name: "image is re-encoded", filename: "ktp.jpeg", content: append(encodePng(t), []byte("<?php")...),
+			scanner: &FakeScanner{Signatures: map[string]string{"<?php": "Php.Webshell"}}, wantName: "ktp.png",
+			wantType: "image/png",
+		},
+		{
+			name: "infected", filename: "kk.pdf", content: append(pdf, []byte("EICAR")...),
+			scanner:    &FakeScanner{Signatures: map[string]string{"EICAR": "Eicar-Test-Signature"}},
+			wantInfect: "Eicar-Test-Signature",
+		},
+		{
+			name: "scanner unreachable", filename: "kk.pdf", content: pdf, scanner: &FakeScanner{Err: scanErr},
+			wantErr: scanErr,
+		},
+		{
+			name: "no scanner fails closed", filename: "kk.pdf", content: pdf, wantErr: ErrScannerUnavailable,
+		},
+		{
+			name: "scanning disabled", filename: "kk.pdf", content: pdf, disabled: true, wantName: "kk.pdf",
+			wantType: "application/pdf",
+		},
+		{
+			name: "too large", filename: "kk.pdf", content: append(pdf, make([]byte, 1<<10)...),
+			scanner: &FakeScanner{}, wantErr: ErrTooLarge,
+		},
+		{
+			name: "type not allowed", filename: "kk.pdf", content: []byte("plain text pretending to be a pdf"),
+			scanner: &FakeScanner{}, wantInvalid: true,
+		},
+		{
+			name: "corrupt image", filename: "ktp.png", content: encodePng(t)[:40], scanner: &FakeScanner{},
+			wantInvalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				useScanner(t, tt.scanner, tt.disabled)
+				w, r := newUploadRequest(t, tt.filename, tt.content)
+				file, err := Process(w, r, "file", policy)
+
+				switch {
+				case tt.wantErr != nil:
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("err = %v, want %v", err, tt.wantErr)
+					}
+					return
+				case tt.wantInfect != "":
+					var infectedErr *InfectedError
+					if !errors.As(err, &infectedErr) || infectedErr.Signature != tt.wantInfect {
+						t.Fatalf("err = %v, want infected by %s", err, tt.wantInfect)
+					}
+					return
+				case tt.wantInvalid:
+					if err == nil || !strings.Contains(err.Error(), "invalid") {
+						t.Fatalf("err = %v, want an invalid file error", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected err %v", err)
+				}
+				if file.Filename != tt.wantName || file.ContentType != tt.wantType {
+					t.Fatalf("got %s %s, want %s %s", file.Filename, file.ContentType, tt.wantName, tt.wantType)
+				}
+				if bytes.Contains(file.Content, []byte("<?php")) {
+					t.Fatal("trailing payload survived sanitizing")
+				}
+			},
+		)
+	}
+}